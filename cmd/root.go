@@ -32,6 +32,9 @@ var cfgFile string
 
 const envPrefix = "GORGE"
 
+// defaultJwtSecret is the default of --jwt-secret, serve refuses to use it outside of dev mode
+const defaultJwtSecret = "changeme"
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "gorge",
@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -26,25 +27,33 @@ import (
 	"os"
 	"os/signal"
 	"os/user"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/dadav/gorge/internal/auth"
 	config "github.com/dadav/gorge/internal/config"
 	log "github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/metrics"
 	customMiddleware "github.com/dadav/gorge/internal/middleware"
+	"github.com/dadav/gorge/internal/tracing"
 	"github.com/dadav/gorge/internal/utils"
 	v3 "github.com/dadav/gorge/internal/v3/api"
 	backend "github.com/dadav/gorge/internal/v3/backend"
 	"github.com/dadav/gorge/internal/v3/ui"
+	"github.com/dadav/gorge/internal/watch"
 	openapi "github.com/dadav/gorge/pkg/gen/v3/openapi"
 	"github.com/dadav/stampede"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/go-chi/jwtauth/v5"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -59,9 +68,25 @@ set yet.
 
 You can also enable the caching functionality to speed things up.`,
 	Run: func(_ *cobra.Command, _ []string) {
-		var err error
-
 		log.Setup(config.Dev)
+		log.SetupAccess(log.AccessConfig{
+			Output:     config.AccessLog,
+			MaxSize:    config.AccessLogMaxSize,
+			MaxBackups: config.AccessLogMaxBackups,
+			MaxAge:     config.AccessLogMaxAge,
+			Compress:   config.AccessLogCompress,
+		})
+
+		shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+			Exporter:    config.TracingExporter,
+			Endpoint:    config.TracingEndpoint,
+			File:        config.TracingFile,
+			SampleRatio: config.TracingSampleRatio,
+			Version:     version,
+		})
+		if err != nil {
+			log.Log.Fatal(err)
+		}
 
 		config.ModulesDir, err = utils.ExpandTilde(config.ModulesDir)
 		if err != nil {
@@ -80,12 +105,31 @@ You can also enable the caching functionality to speed things up.`,
 			log.Log.Fatal(err)
 		}
 
-		if config.Backend == "filesystem" {
-			backend.ConfiguredBackend = backend.NewFilesystemBackend(config.ModulesDir)
-		} else {
-			log.Log.Fatalf("Invalid backend: %s", config.Backend)
+		// Everybody knows the default secret, so it would allow anyone to sign tokens
+		if !config.Dev && config.JwtSecret == defaultJwtSecret {
+			log.Log.Fatalf("Refusing to start with the default --jwt-secret %q, set a secret of your own", defaultJwtSecret)
+		}
+
+		if config.ValidateReleases {
+			policy := &backend.ValidationPolicy{
+				MaxArchiveSize: config.MaxReleaseSize * 1024 * 1024,
+				EnforceOnScan:  config.ValidateScannedReleases,
+			}
+			for _, license := range strings.Split(config.LicenseAllowlist, ",") {
+				if license = strings.TrimSpace(license); license != "" {
+					policy.LicenseAllowlist = append(policy.LicenseAllowlist, license)
+				}
+			}
+			backend.ConfiguredValidationPolicy = policy
 		}
 
+		backend.ConfiguredArchiveLimits = backend.ArchiveLimits{
+			MaxUncompressedSize: config.MaxUncompressedSize * 1024 * 1024,
+			MaxEntries:          config.MaxArchiveEntries,
+			MaxPathDepth:        config.MaxArchivePathDepth,
+		}
+
+		// The filesystem and sql backends store the tarballs and the sqlite database in the modules dir
 		if _, err := os.Stat(config.ModulesDir); err != nil {
 			err = os.MkdirAll(config.ModulesDir, os.ModePerm)
 			if err != nil {
@@ -118,38 +162,92 @@ You can also enable the caching functionality to speed things up.`,
 			}
 		}
 
+		switch config.Backend {
+		case "filesystem":
+			backend.ConfiguredBackend = backend.NewFilesystemBackend(config.ModulesDir)
+		case "s3":
+			backend.ConfiguredBackend, err = backend.NewS3Backend(backend.S3Config{
+				Endpoint:  config.S3Endpoint,
+				Bucket:    config.S3Bucket,
+				Prefix:    config.S3Prefix,
+				Region:    config.S3Region,
+				AccessKey: config.S3AccessKey,
+				SecretKey: config.S3SecretKey,
+				Insecure:  config.S3Insecure,
+			})
+			if err != nil {
+				log.Log.Fatal(err)
+			}
+		case "sql":
+			backend.ConfiguredBackend, err = backend.NewSQLBackend(backend.SQLConfig{
+				Driver:     config.DatabaseDriver,
+				DSN:        config.DatabaseDSN,
+				ModulesDir: config.ModulesDir,
+			})
+			if err != nil {
+				log.Log.Fatal(err)
+			}
+		default:
+			log.Log.Fatalf("Invalid backend: %s", config.Backend)
+		}
+
 		if config.ApiVersion == "v3" {
 			moduleService := v3.NewModuleOperationsApi()
 			releaseService := v3.NewReleaseOperationsApi()
 			searchFilterService := v3.NewSearchFilterOperationsApi()
 			userService := v3.NewUserOperationsApi()
 
+			var upstreams []*customMiddleware.Upstream
+			if config.FallbackProxyUrl != "" {
+				upstreamConfig := customMiddleware.UpstreamConfig{
+					Timeout:          time.Duration(config.ProxyTimeoutSec) * time.Second,
+					Retries:          config.ProxyRetries,
+					FailureThreshold: config.ProxyFailureThreshold,
+					Cooldown:         time.Duration(config.ProxyCooldownSec) * time.Second,
+				}
+				for _, proxy := range strings.Split(config.FallbackProxyUrl, ",") {
+					upstreams = append(upstreams, customMiddleware.NewUpstream(proxy, upstreamConfig))
+				}
+			}
+
 			r := chi.NewRouter()
 
-			// 0. Inject statistic middleware
-			x := customMiddleware.NewStatistics()
-			r.Use(func(next http.Handler) http.Handler {
-				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					ctx := context.WithValue(r.Context(), "stats", x)
-					next.ServeHTTP(w, r.WithContext(ctx))
-				})
-			})
+			// 0. Tracing and metrics should be first to measure all requests, including the ones failing in other middleware
+			r.Use(customMiddleware.Tracing(r))
+			r.Use(customMiddleware.Metrics(r))
 
-			// 1. Recoverer should be first to catch panics in all other middleware
+			// 1. Recoverer catches panics in all following middleware and handlers, tracing and metrics see the resulting 500
 			r.Use(middleware.Recoverer)
 			// 2. RealIP should be early to ensure all other middleware sees the correct IP
 			r.Use(middleware.RealIP)
-			// 3. CORS should be early as it might reject requests before doing unnecessary work
+			// 3. The access log needs the client IP and the request ID
+			r.Use(customMiddleware.RequestID)
+			r.Use(customMiddleware.AccessLog(log.Access, r))
+			// 4. CORS should be early as it might reject requests before doing unnecessary work
 			r.Use(cors.Handler(cors.Options{
 				AllowedOrigins:   strings.Split(config.CORSOrigins, ","),
 				AllowedMethods:   []string{"GET", "POST", "DELETE", "PATCH"},
-				AllowedHeaders:   []string{"Accept", "Content-Type"},
+				AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", customMiddleware.RequestIDHeader},
 				AllowCredentials: false,
 				MaxAge:           300,
 			}))
-			// 4. RequireUserAgent should be early to ensure all other middleware sees the correct user agent
+			// 5. RequireUserAgent should be early to ensure all other middleware sees the correct user agent
 			r.Use(customMiddleware.RequireUserAgent)
 
+			var tokenAuth *jwtauth.JWTAuth
+			var tokenStore *auth.TokenStore
+			if config.Dev {
+				log.Log.Warn("Dev mode is enabled, protected endpoints are accessible without authentication")
+			} else {
+				tokenAuth = jwtauth.New("HS256", []byte(config.JwtSecret), nil)
+				if err := writeAdminToken(tokenAuth, config.JwtTokenPath); err != nil {
+					log.Log.Fatal(err)
+				}
+				log.Log.Infof("Admin token written to %s", config.JwtTokenPath)
+
+				tokenStore = auth.NewTokenStore(config.ModulesDir)
+			}
+
 			if config.UI {
 				r.Group(func(r chi.Router) {
 					r.HandleFunc("/", ui.IndexHandler)
@@ -157,12 +255,55 @@ You can also enable the caching functionality to speed things up.`,
 					r.HandleFunc("/modules/{module}", ui.ModuleHandler)
 					r.HandleFunc("/modules/{module}/{version}", ui.ReleaseHandler)
 					r.HandleFunc("/authors/{author}", ui.AuthorHandler)
-					r.HandleFunc("/statistics", ui.StatisticsHandler(x))
+					r.HandleFunc("/statistics", ui.StatisticsHandler(upstreams))
 					r.Handle("/assets/*", ui.HandleAssets())
+
+					// Saved searches are private, browsers can send the token in the jwt cookie
+					r.Group(func(r chi.Router) {
+						if tokenAuth != nil {
+							r.Use(customMiddleware.AuthMiddleware(tokenAuth, tokenStore, func(*http.Request) bool { return true }))
+						}
+						r.HandleFunc("/searches", ui.SearchFiltersHandler(searchFilterService.SearchFilterStore()))
+					})
 				})
 			}
 
 			r.Group(func(r chi.Router) {
+				if tokenAuth != nil {
+					r.Use(customMiddleware.AuthMiddleware(tokenAuth, tokenStore, func(r *http.Request) bool {
+						if r.URL.Path == "/metrics" {
+							return !config.MetricsNoAuth
+						}
+						if !strings.HasPrefix(r.URL.Path, "/v3/") {
+							return false
+						}
+						// Search filters always belong to an authenticated user
+						if strings.HasPrefix(r.URL.Path, "/v3/search_filters") {
+							return true
+						}
+						// Resolving dependencies only reads releases
+						if r.URL.Path == "/v3/resolve" {
+							return config.JwtProtectReads
+						}
+						switch r.Method {
+						case http.MethodPost, http.MethodDelete, http.MethodPatch, http.MethodPut:
+							return true
+						case http.MethodGet, http.MethodHead:
+							return config.JwtProtectReads
+						default:
+							return false
+						}
+					}))
+				}
+
+				r.Use(customMiddleware.CountDownloads(func(filename string) {
+					slug := strings.TrimSuffix(filename, ".tar.gz")
+					// Proxied releases are counted by the upstream forge
+					if _, err := backend.ConfiguredBackend.GetReleaseBySlug(slug); err == nil {
+						backend.ConfiguredDownloadCounter.Count(slug)
+					}
+				}))
+
 				if !config.NoCache {
 					log.Log.Debug("Setting up cache middleware")
 					customKeyFunc := func(r *http.Request) uint64 {
@@ -173,23 +314,21 @@ You can also enable the caching functionality to speed things up.`,
 							requestURI = r.URL.RequestURI()
 						}
 
-						return stampede.StringToHash(r.Method, requestURI, strings.ToLower(token))
+						generation := strconv.FormatUint(customMiddleware.CacheGeneration(), 10)
+						return stampede.StringToHash(r.Method, requestURI, strings.ToLower(token), generation)
 					}
 
 					cbFunc := func(fromCache bool, w http.ResponseWriter, r *http.Request) error {
-						x.Mutex.Lock()
+						trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("gorge.cache.hit", fromCache))
 						if fromCache {
 							log.Log.Debugf("Cache hit for path: %s", r.URL.Path)
-							x.TotalCacheHits++
-							x.CacheHitsPerEndpoint[r.URL.Path]++
+							metrics.CacheRequests.WithLabelValues(customMiddleware.Route(r), "hit").Inc()
 							w.Header().Set("X-Cache", "Hit from gorge")
 						} else {
 							log.Log.Debugf("Cache miss for path: %s", r.URL.Path)
-							x.TotalCacheMisses++
-							x.CacheMissesPerEndpoint[r.URL.Path]++
+							metrics.CacheRequests.WithLabelValues(customMiddleware.Route(r), "miss").Inc()
 							w.Header().Set("X-Cache", "MISS from gorge")
 						}
-						x.Mutex.Unlock()
 						return nil
 					}
 
@@ -205,6 +344,12 @@ You can also enable the caching functionality to speed things up.`,
 
 					r.Use(func(next http.Handler) http.Handler {
 						return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							// Partial and conditional responses must not end up in the cache
+							if r.Header.Get("Range") != "" || r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+								next.ServeHTTP(w, r)
+								return
+							}
+
 							shouldCache := false
 							for _, prefix := range cachePrefixes {
 								if strings.HasPrefix(r.URL.Path, strings.TrimSpace(prefix)) {
@@ -214,7 +359,9 @@ You can also enable the caching functionality to speed things up.`,
 							}
 
 							if shouldCache {
-								cachedMiddleware(next).ServeHTTP(w, r)
+								ctx, span := tracing.Start(r.Context(), "cache")
+								defer span.End()
+								cachedMiddleware(next).ServeHTTP(w, r.WithContext(ctx))
 							} else {
 								next.ServeHTTP(w, r)
 							}
@@ -222,8 +369,8 @@ You can also enable the caching functionality to speed things up.`,
 					})
 				}
 
-				if config.FallbackProxyUrl != "" {
-					proxies := strings.Split(config.FallbackProxyUrl, ",")
+				if len(upstreams) > 0 {
+					proxies := slices.Clone(upstreams)
 					slices.Reverse(proxies)
 
 					proxyPrefixes := strings.Split(config.ProxyPrefixes, ",")
@@ -242,7 +389,7 @@ You can also enable the caching functionality to speed things up.`,
 								return shouldProxy && status == http.StatusNotFound
 							},
 							func(r *http.Response) {
-								r.Header.Add("X-Proxied-To", proxy)
+								r.Header.Add("X-Proxied-To", proxy.Url)
 
 								if config.ImportProxiedReleases && strings.HasPrefix(r.Request.URL.Path, "/v3/files/") && r.StatusCode == http.StatusOK {
 									body, err := io.ReadAll(r.Body)
@@ -254,7 +401,7 @@ You can also enable the caching functionality to speed things up.`,
 									// restore the body
 									r.Body = io.NopCloser(bytes.NewBuffer(body))
 
-									release, err := backend.ConfiguredBackend.AddRelease(body)
+									release, err := backend.Traced(r.Request.Context()).AddRelease(backend.NewUploadFromBytes(body))
 									if err != nil {
 										log.Log.Error(err)
 										return
@@ -267,23 +414,36 @@ You can also enable the caching functionality to speed things up.`,
 					}
 				}
 
-				// StatisticsMiddleware should be last to ensure all other middleware is counted
-				r.Use(customMiddleware.StatisticsMiddleware(x))
+				r.Handle("/metrics", metrics.Handler())
 
 				apiRouter := openapi.NewRouter(
-					openapi.NewModuleOperationsAPIController(moduleService),
-					openapi.NewReleaseOperationsAPIController(releaseService),
+					v3.NewModuleOperationsController(moduleService),
+					v3.NewReleaseOperationsController(releaseService),
 					openapi.NewSearchFilterOperationsAPIController(searchFilterService),
 					openapi.NewUserOperationsAPIController(userService),
+					v3.NewResolveController(upstreams),
 				)
 
 				r.Mount("/", apiRouter)
 			})
 
 			r.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+				// Unhealthy upstreams don't affect the readiness, the local modules are still served
+				statuses := customMiddleware.UpstreamStatuses(upstreams)
+				message := "ok"
+				for _, status := range statuses {
+					if !status.Healthy {
+						message = "degraded"
+						break
+					}
+				}
+
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(200)
-				w.Write([]byte(`{"message": "ok"}`))
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"message":   message,
+					"upstreams": statuses,
+				})
 			})
 
 			r.Get("/livez", func(w http.ResponseWriter, r *http.Request) {
@@ -300,10 +460,22 @@ You can also enable the caching functionality to speed things up.`,
 			defer restoreDefaultSignalHandling()
 			g, gCtx := errgroup.WithContext(sigCtx)
 
-			if err := backend.ConfiguredBackend.LoadModules(); err != nil {
+			if err := loadModules(); err != nil {
 				log.Log.Fatal(fmt.Errorf("initial module load failed: %w", err))
 			}
 
+			metrics.RegisterInventory(func() (int, int, error) {
+				_, modules, err := backend.ConfiguredBackend.QueryModules(&backend.ModuleQuery{Limit: 1})
+				if err != nil {
+					return 0, 0, err
+				}
+				_, releases, err := backend.ConfiguredBackend.QueryReleases(&backend.ReleaseQuery{Limit: 1})
+				if err != nil {
+					return 0, 0, err
+				}
+				return modules, releases, nil
+			})
+
 			if config.ModulesScanSec > 0 {
 				g.Go(func() error {
 					ticker := time.NewTicker(time.Duration(config.ModulesScanSec) * time.Second)
@@ -314,7 +486,7 @@ You can also enable the caching functionality to speed things up.`,
 						case <-gCtx.Done():
 							return nil
 						case <-ticker.C:
-							if err := backend.ConfiguredBackend.LoadModules(); err != nil {
+							if err := loadModules(); err != nil {
 								log.Log.Errorf("Failed to load modules: %v", err)
 								// Continue running instead of failing completely
 							}
@@ -323,6 +495,77 @@ You can also enable the caching functionality to speed things up.`,
 				})
 			}
 
+			if config.DownloadsFlushSec > 0 {
+				g.Go(func() error {
+					ticker := time.NewTicker(time.Duration(config.DownloadsFlushSec) * time.Second)
+					defer ticker.Stop()
+
+					for {
+						select {
+						case <-gCtx.Done():
+							return nil
+						case <-ticker.C:
+							if err := backend.ConfiguredDownloadCounter.Flush(); err != nil {
+								log.Log.Errorf("Failed to save download counters: %v", err)
+							}
+						}
+					}
+				})
+			}
+
+			if config.TrashRetentionDays > 0 {
+				g.Go(func() error {
+					ticker := time.NewTicker(trashPurgeInterval)
+					defer ticker.Stop()
+
+					for {
+						purgeDeletedReleases()
+
+						select {
+						case <-gCtx.Done():
+							return nil
+						case <-ticker.C:
+						}
+					}
+				})
+			}
+
+			if len(upstreams) > 0 && config.ProxyHealthCheckSec > 0 {
+				g.Go(func() error {
+					ticker := time.NewTicker(time.Duration(config.ProxyHealthCheckSec) * time.Second)
+					defer ticker.Stop()
+
+					for {
+						customMiddleware.CheckUpstreams(gCtx, upstreams)
+
+						select {
+						case <-gCtx.Done():
+							return nil
+						case <-ticker.C:
+						}
+					}
+				})
+			}
+
+			if config.Watch {
+				if config.Backend == "s3" {
+					log.Log.Fatal("--watch requires a backend storing the modules in --modulesdir")
+				}
+
+				g.Go(func() error {
+					err := watch.Watch(gCtx, config.ModulesDir, func() {
+						if err := loadModules(); err != nil {
+							log.Log.Errorf("Failed to load modules: %v", err)
+						}
+					})
+					if err != nil {
+						log.Log.Errorf("Failed to watch %s: %v", config.ModulesDir, err)
+					}
+					// The periodic scan keeps working, so don't stop the server
+					return nil
+				})
+			}
+
 			bindPort := fmt.Sprintf("%s:%d", config.Bind, config.Port)
 			listener, err := net.Listen("tcp", bindPort)
 			if err != nil {
@@ -375,6 +618,18 @@ You can also enable the caching functionality to speed things up.`,
 				if err := server.Shutdown(shutdownCtx); err != nil {
 					return fmt.Errorf("server shutdown failed: %w", err)
 				}
+
+				// Save the downloads counted since the last flush
+				if err := backend.ConfiguredDownloadCounter.Flush(); err != nil {
+					log.Log.Errorf("Failed to save download counters: %v", err)
+				}
+
+				log.Access.Sync()
+
+				// Export the remaining spans
+				if err := shutdownTracing(shutdownCtx); err != nil {
+					log.Log.Errorf("Failed to shut down tracing: %v", err)
+				}
 				return nil
 			})
 
@@ -387,6 +642,57 @@ You can also enable the caching functionality to speed things up.`,
 	},
 }
 
+// loadModules (re)loads the modules of the backend and records the duration of the scan
+func loadModules() error {
+	start := time.Now()
+	err := backend.Traced(context.Background()).LoadModules()
+	metrics.ScanDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ScanFailures.Inc()
+	}
+	return err
+}
+
+// trashPurgeInterval is the time between two runs of the purge job
+const trashPurgeInterval = time.Hour
+
+// purgeDeletedReleases permanently removes the releases deleted longer than the retention period ago
+func purgeDeletedReleases() {
+	deletedBefore := time.Now().AddDate(0, 0, -config.TrashRetentionDays)
+	purged, err := backend.Traced(context.Background()).PurgeDeletedReleases(deletedBefore)
+	if err != nil {
+		log.Log.Errorf("Failed to purge deleted releases: %v", err)
+	}
+	if purged > 0 {
+		log.Log.Infof("Purged %d releases deleted before %s", purged, deletedBefore.Format(time.RFC3339))
+	}
+}
+
+// writeAdminToken signs a token for the admin user and writes it to path,
+// creating the parent directory if needed.
+func writeAdminToken(tokenAuth *jwtauth.JWTAuth, path string) error {
+	claims := map[string]interface{}{
+		"sub":    "admin",
+		"scopes": []string{auth.ScopeAdmin},
+	}
+	jwtauth.SetIssuedNow(claims)
+
+	_, tokenString, err := tokenAuth.Encode(claims)
+	if err != nil {
+		return fmt.Errorf("failed to sign admin token: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create token directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, []byte(tokenString), 0600); err != nil {
+		return fmt.Errorf("failed to write admin token: %w", err)
+	}
+
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
 
@@ -397,16 +703,53 @@ func init() {
 	serveCmd.Flags().StringVar(&config.Bind, "bind", "127.0.0.1", "host to listen to")
 	serveCmd.Flags().StringVar(&config.ModulesDir, "modulesdir", "~/.gorge/modules", "directory containing all the modules")
 	serveCmd.Flags().IntVar(&config.ModulesScanSec, "modules-scan-sec", 0, "seconds between scans of directory containing all the modules. (default 0 means only scan at startup)")
-	serveCmd.Flags().StringVar(&config.Backend, "backend", "filesystem", "backend to use")
+	serveCmd.Flags().BoolVar(&config.Watch, "watch", false, "reload modules as soon as tarballs are added to or removed from the modules directory")
+	serveCmd.Flags().IntVar(&config.TrashRetentionDays, "trash-retention-days", 30, "days deleted releases can be restored before they are purged (0 keeps them forever)")
+	serveCmd.Flags().BoolVar(&config.ValidateReleases, "validate-releases", true, "validate releases on upload and scan (semver, directory layout, license and dependencies)")
+	serveCmd.Flags().BoolVar(&config.ValidateScannedReleases, "validate-scanned-releases", false, "skip tarballs found while scanning which violate the validation, otherwise the violations are only logged")
+	serveCmd.Flags().StringVar(&config.LicenseAllowlist, "license-allowlist", "", "optional comma separated list of licenses releases must use")
+	serveCmd.Flags().Int64Var(&config.MaxReleaseSize, "max-release-size", 0, "maximum size of release archives in MiB (0 means unlimited)")
+	serveCmd.Flags().Int64Var(&config.MaxUncompressedSize, "max-uncompressed-size", 512, "maximum uncompressed size of release archives in MiB (0 means unlimited)")
+	serveCmd.Flags().IntVar(&config.MaxArchiveEntries, "max-archive-entries", 10000, "maximum number of files and directories in release archives (0 means unlimited)")
+	serveCmd.Flags().IntVar(&config.MaxArchivePathDepth, "max-archive-path-depth", 20, "maximum directory depth of files in release archives (0 means unlimited)")
+	serveCmd.Flags().BoolVar(&config.AllowOverwrite, "allow-overwrite", false, "allow admins to replace the tarball of an existing release by uploading it again")
+	serveCmd.Flags().Int64Var(&config.MaxUploadSize, "max-upload-size", 100, "maximum size of uploaded tarballs in MiB (0 means unlimited)")
+	serveCmd.Flags().IntVar(&config.DownloadsFlushSec, "downloads-flush-sec", 60, "seconds between saving the download counters of the releases (0 only saves them on shutdown)")
+	serveCmd.Flags().StringVar(&config.AccessLog, "access-log", "stdout", "where to write the access log (stdout, stderr, none or the path of a file)")
+	serveCmd.Flags().IntVar(&config.AccessLogMaxSize, "access-log-max-size", 100, "size in MiB the access log file may reach before it's rotated")
+	serveCmd.Flags().IntVar(&config.AccessLogMaxBackups, "access-log-max-backups", 5, "number of rotated access log files to keep (0 keeps all)")
+	serveCmd.Flags().IntVar(&config.AccessLogMaxAge, "access-log-max-age", 30, "days to keep rotated access log files (0 keeps them forever)")
+	serveCmd.Flags().BoolVar(&config.AccessLogCompress, "access-log-compress", false, "gzip rotated access log files")
+	serveCmd.Flags().StringVar(&config.TracingExporter, "tracing-exporter", "none", "exporter of the opentelemetry traces (none, otlp, stdout or file)")
+	serveCmd.Flags().StringVar(&config.TracingEndpoint, "tracing-endpoint", "", "url of the otlp http receiver, e.g. http://localhost:4318 (defaults to the OTEL_EXPORTER_OTLP_* environment variables)")
+	serveCmd.Flags().StringVar(&config.TracingFile, "tracing-file", "", "file the traces are appended to by the file exporter")
+	serveCmd.Flags().Float64Var(&config.TracingSampleRatio, "tracing-sample-ratio", 1, "fraction of the requests without sampled parent span which are traced")
+	serveCmd.Flags().StringVar(&config.Backend, "backend", "filesystem", "backend to use (filesystem, s3 or sql)")
+	serveCmd.Flags().StringVar(&config.S3Endpoint, "s3-endpoint", "", "host[:port] of the s3 compatible object storage")
+	serveCmd.Flags().StringVar(&config.S3Bucket, "s3-bucket", "gorge", "bucket to store the modules in")
+	serveCmd.Flags().StringVar(&config.S3Prefix, "s3-prefix", "", "optional key prefix for all objects in the bucket")
+	serveCmd.Flags().StringVar(&config.S3Region, "s3-region", "", "region of the bucket")
+	serveCmd.Flags().StringVar(&config.S3AccessKey, "s3-access-key", "", "access key for the object storage")
+	serveCmd.Flags().StringVar(&config.S3SecretKey, "s3-secret-key", "", "secret key for the object storage")
+	serveCmd.Flags().BoolVar(&config.S3Insecure, "s3-insecure", false, "use plain http to connect to the object storage")
+	serveCmd.Flags().StringVar(&config.DatabaseDriver, "db-driver", "sqlite", "database used by the sql backend (sqlite or postgres)")
+	serveCmd.Flags().StringVar(&config.DatabaseDSN, "db-dsn", "", "data source name of the database (defaults to <modulesdir>/.gorge.db for sqlite)")
 	serveCmd.Flags().StringVar(&config.CORSOrigins, "cors", "*", "allowed cors origins separated by comma")
 	serveCmd.Flags().StringVar(&config.FallbackProxyUrl, "fallback-proxy", "", "optional comma separated list of fallback upstream proxy urls")
+	serveCmd.Flags().IntVar(&config.ProxyTimeoutSec, "proxy-timeout-sec", 10, "seconds to wait for connecting to a fallback proxy and for its response headers")
+	serveCmd.Flags().IntVar(&config.ProxyRetries, "proxy-retries", 1, "number of retries of requests to a fallback proxy which failed to connect (only GET and HEAD requests)")
+	serveCmd.Flags().IntVar(&config.ProxyFailureThreshold, "proxy-failure-threshold", 3, "consecutive failures after which a fallback proxy is considered unhealthy and skipped")
+	serveCmd.Flags().IntVar(&config.ProxyCooldownSec, "proxy-cooldown-sec", 30, "seconds an unhealthy fallback proxy is skipped before it's tried again")
+	serveCmd.Flags().IntVar(&config.ProxyHealthCheckSec, "proxy-health-check-sec", 30, "seconds between health checks of the fallback proxies (0 disables the checks)")
 	serveCmd.Flags().BoolVar(&config.Dev, "dev", false, "enables dev mode")
 	serveCmd.Flags().BoolVar(&config.DropPrivileges, "drop-privileges", false, "drops privileges to the given user/group")
 	serveCmd.Flags().BoolVar(&config.UI, "ui", false, "enables the web ui")
 	serveCmd.Flags().StringVar(&config.CachePrefixes, "cache-prefixes", "/v3/files", "url prefixes to cache")
 	serveCmd.Flags().StringVar(&config.ProxyPrefixes, "proxy-prefixes", "/v3", "url prefixes to proxy")
-	serveCmd.Flags().StringVar(&config.JwtSecret, "jwt-secret", "changeme", "jwt secret")
+	serveCmd.Flags().StringVar(&config.JwtSecret, "jwt-secret", defaultJwtSecret, "jwt secret")
 	serveCmd.Flags().StringVar(&config.JwtTokenPath, "jwt-token-path", "~/.gorge/token", "jwt token path")
+	serveCmd.Flags().BoolVar(&config.MetricsNoAuth, "metrics-no-auth", false, "serve the prometheus metrics at /metrics without requiring a jwt token")
+	serveCmd.Flags().BoolVar(&config.JwtProtectReads, "jwt-protect-reads", false, "also require a valid jwt token for read-only api requests")
 	serveCmd.Flags().StringVar(&config.TlsCertPath, "tls-cert", "", "path to tls cert file")
 	serveCmd.Flags().StringVar(&config.TlsKeyPath, "tls-key", "", "path to tls key file")
 	serveCmd.Flags().Int64Var(&config.CacheMaxAge, "cache-max-age", 86400, "max number of seconds responses should be cached")
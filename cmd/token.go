@@ -0,0 +1,155 @@
+/*
+Copyright © 2024 dadav
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dadav/gorge/internal/auth"
+	config "github.com/dadav/gorge/internal/config"
+	"github.com/dadav/gorge/internal/utils"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenSubject string
+	tokenScopes  []string
+	tokenTTL     time.Duration
+)
+
+// tokenCmd represents the token command
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage scoped api tokens",
+	Long: `Create, list and revoke api tokens.
+
+Every token carries one or more scopes in the format <action>[:<module glob>].
+Valid actions are publish, delete, deprecate and admin. The optional glob
+is matched against the module slug, so publish:acme-* only allows publishing
+modules of the acme namespace.`,
+}
+
+// tokenCreateCmd represents the token create command
+var tokenCreateCmd = &cobra.Command{
+	Use:     "create",
+	Short:   "Create a new token and print it",
+	Example: `  gorge token create --subject ci-publisher --scope publish:acme-* --ttl 720h`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		store, err := newTokenStore()
+		if err != nil {
+			return err
+		}
+
+		tokenAuth := jwtauth.New("HS256", []byte(config.JwtSecret), nil)
+		info, tokenString, err := store.Create(tokenAuth, tokenSubject, tokenScopes, tokenTTL)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Created token %s for %s\n", info.ID, info.Subject)
+		fmt.Println(tokenString)
+		return nil
+	},
+}
+
+// tokenListCmd represents the token list command
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all created tokens",
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		store, err := newTokenStore()
+		if err != nil {
+			return err
+		}
+
+		tokens, err := store.List()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSUBJECT\tSCOPES\tCREATED\tEXPIRES\tREVOKED")
+		for _, token := range tokens {
+			expires := "never"
+			if token.ExpiresAt != nil {
+				expires = token.ExpiresAt.Format(time.RFC3339)
+			}
+			revoked := "-"
+			if token.RevokedAt != nil {
+				revoked = token.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				token.ID,
+				token.Subject,
+				strings.Join(token.Scopes, ","),
+				token.CreatedAt.Format(time.RFC3339),
+				expires,
+				revoked,
+			)
+		}
+		return w.Flush()
+	},
+}
+
+// tokenRevokeCmd represents the token revoke command
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke a token by its id",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := newTokenStore()
+		if err != nil {
+			return err
+		}
+
+		if err := store.Revoke(args[0]); err != nil {
+			return err
+		}
+
+		fmt.Printf("Revoked token %s\n", args[0])
+		return nil
+	},
+}
+
+// newTokenStore returns the token store located in the configured modules directory
+func newTokenStore() (*auth.TokenStore, error) {
+	modulesDir, err := utils.ExpandTilde(config.ModulesDir)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewTokenStore(modulesDir), nil
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+
+	tokenCmd.PersistentFlags().StringVar(&config.ModulesDir, "modulesdir", "~/.gorge/modules", "directory containing all the modules")
+	tokenCmd.PersistentFlags().StringVar(&config.JwtSecret, "jwt-secret", defaultJwtSecret, "jwt secret")
+
+	tokenCreateCmd.Flags().StringVar(&tokenSubject, "subject", "", "subject (e.g. user or ci job) the token is issued to")
+	tokenCreateCmd.Flags().StringSliceVar(&tokenScopes, "scope", nil, "scope to grant, can be given multiple times (e.g. publish:acme-*)")
+	tokenCreateCmd.Flags().DurationVar(&tokenTTL, "ttl", 0, "lifetime of the token (default 0 means no expiry)")
+	tokenCreateCmd.MarkFlagRequired("subject")
+	tokenCreateCmd.MarkFlagRequired("scope")
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/jwtauth/v5"
+)
+
+const (
+	// ScopeAdmin grants every other scope
+	ScopeAdmin = "admin"
+	// ScopePublish allows uploading new releases
+	ScopePublish = "publish"
+	// ScopeDelete allows deleting modules and releases
+	ScopeDelete = "delete"
+	// ScopeDeprecate allows deprecating modules
+	ScopeDeprecate = "deprecate"
+
+	scopesClaim = "scopes"
+)
+
+var validActions = []string{ScopeAdmin, ScopePublish, ScopeDelete, ScopeDeprecate}
+
+// Scope grants an action on all modules matching Pattern
+type Scope struct {
+	Action  string
+	Pattern string
+}
+
+// ParseScope parses a scope in the format "<action>[:<module glob>]"
+// Example valid scopes: "admin", "publish:acme-*", "delete:acme-foo"
+func ParseScope(raw string) (Scope, error) {
+	action, pattern, found := strings.Cut(strings.TrimSpace(raw), ":")
+	if !slices.Contains(validActions, action) {
+		return Scope{}, fmt.Errorf("invalid scope action %q, must be one of %s", action, strings.Join(validActions, ", "))
+	}
+
+	if !found || pattern == "" {
+		pattern = "*"
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return Scope{}, fmt.Errorf("invalid module pattern %q: %w", pattern, err)
+	}
+
+	return Scope{Action: action, Pattern: pattern}, nil
+}
+
+func (s Scope) String() string {
+	return fmt.Sprintf("%s:%s", s.Action, s.Pattern)
+}
+
+// Allows checks if the scope grants action on the module with the given slug
+// An empty slug only checks the action
+func (s Scope) Allows(action, moduleSlug string) bool {
+	if s.Action != ScopeAdmin && s.Action != action {
+		return false
+	}
+
+	if moduleSlug == "" {
+		return true
+	}
+
+	matched, _ := path.Match(s.Pattern, moduleSlug)
+	return matched
+}
+
+// ScopesFromClaims extracts all valid scopes from the jwt claims
+// Invalid scopes are silently ignored
+func ScopesFromClaims(claims map[string]interface{}) []Scope {
+	var raw []string
+
+	switch v := claims[scopesClaim].(type) {
+	case []string:
+		raw = v
+	case []interface{}:
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				raw = append(raw, str)
+			}
+		}
+	case string:
+		raw = strings.Fields(v)
+	}
+
+	scopes := []Scope{}
+	for _, r := range raw {
+		scope, err := ParseScope(r)
+		if err != nil {
+			continue
+		}
+		scopes = append(scopes, scope)
+	}
+
+	return scopes
+}
+
+// Allowed checks if any of the scopes grants action on the module
+func Allowed(scopes []Scope, action, moduleSlug string) bool {
+	for _, scope := range scopes {
+		if scope.Allows(action, moduleSlug) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks if the token stored in the context grants action on the module
+// If the request was not authenticated at all (e.g. dev mode), access is granted
+func Authorize(ctx context.Context, action, moduleSlug string) bool {
+	token, claims, err := jwtauth.FromContext(ctx)
+	if token == nil && err == nil {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+
+	return Allowed(ScopesFromClaims(claims), action, moduleSlug)
+}
+
+// RequiredScope returns the action and module slug a request needs permissions for
+// Requests which only need a valid token return an empty action, unknown write requests require admin
+func RequiredScope(r *http.Request) (string, string) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return "", ""
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v3" {
+		return ScopeAdmin, ""
+	}
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "releases":
+		// The module slug is only known once the tarball was read
+		return ScopePublish, ""
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[1] == "releases" && parts[3] == "restore":
+		// Deleted releases can only be restored by admins
+		return ScopeAdmin, ""
+	case r.Method == http.MethodDelete && len(parts) == 3 && parts[1] == "releases":
+		return ScopeDelete, releaseToModule(parts[2])
+	case r.Method == http.MethodDelete && len(parts) == 3 && parts[1] == "modules":
+		return ScopeDelete, parts[2]
+	case r.Method == http.MethodPatch && len(parts) == 3 && parts[1] == "modules":
+		return ScopeDeprecate, parts[2]
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "resolve":
+		// Resolving dependencies only reads releases
+		return "", ""
+	case (r.Method == http.MethodPost && len(parts) == 2 || r.Method == http.MethodDelete && len(parts) == 3) && parts[1] == "search_filters":
+		// Search filters belong to the user of the token
+		return "", ""
+	}
+
+	return ScopeAdmin, ""
+}
+
+// ModuleSlug converts module names like acme/foo to the slug acme-foo, which scope patterns are matched against
+func ModuleSlug(name string) string {
+	return strings.Replace(name, "/", "-", 1)
+}
+
+// releaseToModule strips the version from a release slug
+func releaseToModule(releaseSlug string) string {
+	idx := strings.LastIndex(releaseSlug, "-")
+	if idx < 0 {
+		return releaseSlug
+	}
+	return releaseSlug[:idx]
+}
+
+// AnonymousSubject is used for requests which were not authenticated (e.g. dev mode)
+const AnonymousSubject = "anonymous"
+
+// Subject returns the subject of the token stored in the context
+// Returns AnonymousSubject if the request was not authenticated and an empty string if the token is invalid
+func Subject(ctx context.Context) string {
+	token, claims, err := jwtauth.FromContext(ctx)
+	if token == nil && err == nil {
+		return AnonymousSubject
+	}
+	if err != nil {
+		return ""
+	}
+
+	if sub, ok := claims["sub"].(string); ok {
+		return sub
+	}
+	return ""
+}
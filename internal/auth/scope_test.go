@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    Scope
+		wantErr bool
+	}{
+		{name: "action only", raw: "admin", want: Scope{Action: ScopeAdmin, Pattern: "*"}},
+		{name: "action with pattern", raw: "publish:acme-*", want: Scope{Action: ScopePublish, Pattern: "acme-*"}},
+		{name: "exact module", raw: "delete:acme-foo", want: Scope{Action: ScopeDelete, Pattern: "acme-foo"}},
+		{name: "empty pattern", raw: "deprecate:", want: Scope{Action: ScopeDeprecate, Pattern: "*"}},
+		{name: "surrounding whitespace", raw: " publish ", want: Scope{Action: ScopePublish, Pattern: "*"}},
+		{name: "unknown action", raw: "read:acme-*", wantErr: true},
+		{name: "empty", raw: "", wantErr: true},
+		{name: "invalid pattern", raw: "publish:acme-[", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScope(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseScope(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseScope(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		name       string
+		scope      Scope
+		action     string
+		moduleSlug string
+		want       bool
+	}{
+		{name: "matching action and module", scope: Scope{ScopePublish, "acme-*"}, action: ScopePublish, moduleSlug: "acme-foo", want: true},
+		{name: "other module", scope: Scope{ScopePublish, "acme-*"}, action: ScopePublish, moduleSlug: "other-foo", want: false},
+		{name: "other action", scope: Scope{ScopePublish, "*"}, action: ScopeDelete, moduleSlug: "acme-foo", want: false},
+		{name: "admin grants every action", scope: Scope{ScopeAdmin, "*"}, action: ScopeDelete, moduleSlug: "acme-foo", want: true},
+		{name: "admin is limited to its pattern", scope: Scope{ScopeAdmin, "acme-*"}, action: ScopeDelete, moduleSlug: "other-foo", want: false},
+		{name: "empty slug only checks the action", scope: Scope{ScopePublish, "acme-*"}, action: ScopePublish, moduleSlug: "", want: true},
+		{name: "empty slug with other action", scope: Scope{ScopeDeprecate, "*"}, action: ScopePublish, moduleSlug: "", want: false},
+		{name: "exact pattern", scope: Scope{ScopeDelete, "acme-foo"}, action: ScopeDelete, moduleSlug: "acme-foobar", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.scope.Allows(tt.action, tt.moduleSlug); got != tt.want {
+				t.Errorf("%v.Allows(%q, %q) = %v, want %v", tt.scope, tt.action, tt.moduleSlug, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedModuleName(t *testing.T) {
+	scopes := []Scope{{Action: ScopePublish, Pattern: "acme-*"}}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "acme-foo", want: true},
+		{name: "acme/foo", want: true},
+		{name: "other/foo", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Allowed(scopes, ScopePublish, ModuleSlug(tt.name)); got != tt.want {
+				t.Errorf("Allowed(%v, %q, ModuleSlug(%q)) = %v, want %v", scopes, ScopePublish, tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		wantAction string
+		wantModule string
+	}{
+		{method: http.MethodPost, path: "/v3/releases", wantAction: ScopePublish},
+		{method: http.MethodPost, path: "/v3/releases/acme-foo-1.0.0/restore", wantAction: ScopeAdmin},
+		{method: http.MethodDelete, path: "/v3/releases/acme-foo-1.0.0", wantAction: ScopeDelete, wantModule: "acme-foo"},
+		{method: http.MethodDelete, path: "/v3/modules/acme-foo", wantAction: ScopeDelete, wantModule: "acme-foo"},
+		{method: http.MethodPatch, path: "/v3/modules/acme-foo", wantAction: ScopeDeprecate, wantModule: "acme-foo"},
+		{method: http.MethodGet, path: "/v3/modules/acme-foo"},
+		{method: http.MethodHead, path: "/v3/files/acme-foo-1.0.0.tar.gz"},
+		{method: http.MethodGet, path: "/metrics"},
+		{method: http.MethodPost, path: "/v3/resolve"},
+		{method: http.MethodGet, path: "/v3/search_filters"},
+		{method: http.MethodPost, path: "/v3/search_filters"},
+		{method: http.MethodDelete, path: "/v3/search_filters/1"},
+		{method: http.MethodPut, path: "/v3/search_filters/1", wantAction: ScopeAdmin},
+		{method: http.MethodPost, path: "/v3/unknown", wantAction: ScopeAdmin},
+		{method: http.MethodPut, path: "/v3/releases", wantAction: ScopeAdmin},
+		{method: http.MethodDelete, path: "/v3/modules/acme-foo/dependents", wantAction: ScopeAdmin},
+		{method: http.MethodPost, path: "/unknown", wantAction: ScopeAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			action, moduleSlug := RequiredScope(r)
+			if action != tt.wantAction || moduleSlug != tt.wantModule {
+				t.Errorf("RequiredScope(%s %s) = (%q, %q), want (%q, %q)", tt.method, tt.path, action, moduleSlug, tt.wantAction, tt.wantModule)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+)
+
+const tokensFile = ".tokens.json"
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenInfo describes an issued api token
+// The token itself is never stored, only the information needed to list and revoke it
+type TokenInfo struct {
+	ID        string     `json:"id"`
+	Subject   string     `json:"subject"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// TokenStore persists issued tokens and their revocation state in the modules directory
+// The file is re-read whenever it changes on disk, so revocations done by
+// `gorge token revoke` are picked up by a running server
+type TokenStore struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	tokens  []TokenInfo
+}
+
+func NewTokenStore(modulesDir string) *TokenStore {
+	return &TokenStore{
+		path: filepath.Join(modulesDir, tokensFile),
+	}
+}
+
+// load reads the store from disk if it changed since the last read
+func (s *TokenStore) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.tokens = nil
+			s.modTime = time.Time{}
+			return nil
+		}
+		return err
+	}
+
+	if info.ModTime().Equal(s.modTime) && s.tokens != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	tokens := []TokenInfo{}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+
+	s.tokens = tokens
+	s.modTime = info.ModTime()
+	return nil
+}
+
+func (s *TokenStore) save() error {
+	data, err := json.MarshalIndent(s.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmpFile := s.path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, s.path)
+}
+
+// Create signs a new token for subject with the given scopes and registers it in the store
+// A ttl of zero creates a token which never expires
+func (s *TokenStore) Create(tokenAuth *jwtauth.JWTAuth, subject string, scopes []string, ttl time.Duration) (*TokenInfo, string, error) {
+	if subject == "" {
+		return nil, "", errors.New("subject is required")
+	}
+
+	if len(scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+
+	normalizedScopes := make([]string, 0, len(scopes))
+	for _, raw := range scopes {
+		scope, err := ParseScope(raw)
+		if err != nil {
+			return nil, "", err
+		}
+		normalizedScopes = append(normalizedScopes, scope.String())
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now().UTC()
+	info := TokenInfo{
+		ID:        hex.EncodeToString(idBytes),
+		Subject:   subject,
+		Scopes:    normalizedScopes,
+		CreatedAt: now,
+	}
+
+	claims := map[string]interface{}{
+		"jti":       info.ID,
+		"sub":       subject,
+		scopesClaim: normalizedScopes,
+	}
+	jwtauth.SetIssuedAt(claims, now)
+
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		info.ExpiresAt = &expiresAt
+		jwtauth.SetExpiry(claims, expiresAt)
+	}
+
+	_, tokenString, err := tokenAuth.Encode(claims)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, "", err
+	}
+
+	s.tokens = append(s.tokens, info)
+	if err := s.save(); err != nil {
+		return nil, "", err
+	}
+
+	return &info, tokenString, nil
+}
+
+// List returns all registered tokens
+func (s *TokenStore) List() ([]TokenInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	result := make([]TokenInfo, len(s.tokens))
+	copy(result, s.tokens)
+	return result, nil
+}
+
+// Revoke marks the token with the given id as revoked
+func (s *TokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for i := range s.tokens {
+		if s.tokens[i].ID == id {
+			if s.tokens[i].RevokedAt != nil {
+				return nil
+			}
+			now := time.Now().UTC()
+			s.tokens[i].RevokedAt = &now
+			return s.save()
+		}
+	}
+
+	return ErrTokenNotFound
+}
+
+// IsRevoked checks if the token with the given id has been revoked
+// Unknown ids are not considered revoked, because tokens like the admin token are never registered
+func (s *TokenStore) IsRevoked(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return false, err
+	}
+
+	for _, token := range s.tokens {
+		if token.ID == id {
+			return token.RevokedAt != nil, nil
+		}
+	}
+
+	return false, nil
+}
@@ -1,27 +1,64 @@
 package config
 
 var (
-	User                  string
-	Group                 string
-	ApiVersion            string
-	Port                  int
-	Bind                  string
-	Dev                   bool
-	DropPrivileges        bool
-	UI                    bool
-	ModulesDir            string
-	ModulesScanSec        int
-	Backend               string
-	CORSOrigins           string
-	FallbackProxyUrl      string
-	NoCache               bool
-	CachePrefixes         string
-	ProxyPrefixes         string
-	CacheByFullRequestURI bool
-	CacheMaxAge           int64
-	ImportProxiedReleases bool
-	JwtSecret             string
-	TlsCertPath           string
-	TlsKeyPath            string
-	JwtTokenPath          string
+	User                    string
+	Group                   string
+	ApiVersion              string
+	Port                    int
+	Bind                    string
+	Dev                     bool
+	DropPrivileges          bool
+	UI                      bool
+	ModulesDir              string
+	ModulesScanSec          int
+	Watch                   bool
+	TrashRetentionDays      int
+	ValidateReleases        bool
+	ValidateScannedReleases bool
+	LicenseAllowlist        string
+	MaxReleaseSize          int64
+	MaxUncompressedSize     int64
+	MaxArchiveEntries       int
+	MaxArchivePathDepth     int
+	AllowOverwrite          bool
+	MaxUploadSize           int64
+	DownloadsFlushSec       int
+	Backend                 string
+	S3Endpoint              string
+	S3Bucket                string
+	S3Prefix                string
+	S3Region                string
+	S3AccessKey             string
+	S3SecretKey             string
+	S3Insecure              bool
+	DatabaseDriver          string
+	DatabaseDSN             string
+	CORSOrigins             string
+	FallbackProxyUrl        string
+	ProxyTimeoutSec         int
+	ProxyRetries            int
+	ProxyFailureThreshold   int
+	ProxyCooldownSec        int
+	ProxyHealthCheckSec     int
+	NoCache                 bool
+	CachePrefixes           string
+	ProxyPrefixes           string
+	CacheByFullRequestURI   bool
+	CacheMaxAge             int64
+	ImportProxiedReleases   bool
+	JwtSecret               string
+	TlsCertPath             string
+	TlsKeyPath              string
+	JwtTokenPath            string
+	JwtProtectReads         bool
+	MetricsNoAuth           bool
+	TracingExporter         string
+	TracingEndpoint         string
+	TracingFile             string
+	TracingSampleRatio      float64
+	AccessLog               string
+	AccessLogMaxSize        int
+	AccessLogMaxBackups     int
+	AccessLogMaxAge         int
+	AccessLogCompress       bool
 )
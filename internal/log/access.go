@@ -0,0 +1,52 @@
+package log
+
+import (
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Access writes one entry per request, it's a nop logger until SetupAccess is called
+var Access = zap.NewNop()
+
+// AccessConfig configures the output of the access log
+type AccessConfig struct {
+	// Output is stdout, stderr, none or the path of the log file
+	Output string
+	// MaxSize is the size in MiB a log file may reach before it's rotated
+	MaxSize int
+	// MaxBackups is the number of rotated files to keep, 0 keeps all
+	MaxBackups int
+	// MaxAge is the number of days to keep rotated files, 0 keeps them forever
+	MaxAge int
+	// Compress gzips the rotated files
+	Compress bool
+}
+
+// SetupAccess configures the access log, the entries are written as json
+func SetupAccess(config AccessConfig) {
+	var sink zapcore.WriteSyncer
+	switch config.Output {
+	case "", "none":
+		Access = zap.NewNop()
+		return
+	case "stdout":
+		sink = zapcore.Lock(os.Stdout)
+	case "stderr":
+		sink = zapcore.Lock(os.Stderr)
+	default:
+		sink = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   config.Output,
+			MaxSize:    config.MaxSize,
+			MaxBackups: config.MaxBackups,
+			MaxAge:     config.MaxAge,
+			Compress:   config.Compress,
+		})
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	Access = zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), sink, zapcore.InfoLevel))
+}
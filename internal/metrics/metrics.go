@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/dadav/gorge/internal/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gorge"
+
+// Registry contains all metrics of gorge, it's exposed by Handler and shown on the statistics page
+var Registry = prometheus.NewRegistry()
+
+var (
+	RequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "Number of requests currently being served.",
+	})
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of served requests by method, route and status code.",
+	}, []string{"method", "route", "code"})
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time spent serving requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Number of requests answered from the cache (hit) or passed on to the handlers (miss) by route.",
+	}, []string{"route", "result"})
+	ProxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_requests_total",
+		Help:      "Number of requests forwarded to the fallback proxies by upstream, route and status code of the upstream.",
+	}, []string{"upstream", "route", "code"})
+	SkippedProxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_skipped_requests_total",
+		Help:      "Number of requests not forwarded to a fallback proxy because it was unhealthy.",
+	}, []string{"upstream"})
+	UpstreamUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_upstream_up",
+		Help:      "Whether the fallback proxy is healthy (1) or skipped by the circuit breaker (0).",
+	}, []string{"upstream"})
+	ScanDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "module_scan_duration_seconds",
+		Help:      "Time spent loading the modules from the backend.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	})
+	ScanFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "module_scan_failures_total",
+		Help:      "Number of failed module scans.",
+	})
+	UploadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_failures_total",
+		Help:      "Number of refused or failed release uploads by status code.",
+	}, []string{"code"})
+	RejectedUploads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rejected_uploads_total",
+		Help:      "Number of uploaded archives refused because they were unsafe.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestsInFlight,
+		Requests,
+		RequestDuration,
+		CacheRequests,
+		ProxyRequests,
+		SkippedProxyRequests,
+		UpstreamUp,
+		ScanDuration,
+		ScanFailures,
+		UploadFailures,
+		RejectedUploads,
+	)
+}
+
+// Handler serves the metrics in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// inventoryCollector reports the number of modules and releases
+type inventoryCollector struct {
+	count    func() (int, int, error)
+	modules  *prometheus.Desc
+	releases *prometheus.Desc
+}
+
+// RegisterInventory registers the gauges of the modules and releases
+// count is called on every scrape and returns the number of modules and releases
+func RegisterInventory(count func() (int, int, error)) {
+	Registry.MustRegister(&inventoryCollector{
+		count:    count,
+		modules:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "modules"), "Number of modules.", nil, nil),
+		releases: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "releases"), "Number of releases which are not deleted.", nil, nil),
+	})
+}
+
+func (c *inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.modules
+	ch <- c.releases
+}
+
+func (c *inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	modules, releases, err := c.count()
+	if err != nil {
+		log.Log.Errorf("Failed to count modules and releases: %v", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.modules, prometheus.GaugeValue, float64(modules))
+	ch <- prometheus.MustNewConstMetric(c.releases, prometheus.GaugeValue, float64(releases))
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// RouteSummary contains the statistics of a single route
+type RouteSummary struct {
+	Route              string
+	Connections        int
+	ProxiedConnections int
+	TotalResponseTime  time.Duration
+	CacheHits          int
+	CacheMisses        int
+}
+
+// AverageResponseTime returns the mean time spent serving a request of the route
+func (r *RouteSummary) AverageResponseTime() time.Duration {
+	if r.Connections == 0 {
+		return 0
+	}
+	return r.TotalResponseTime / time.Duration(r.Connections)
+}
+
+// Summary contains the statistics shown in the ui
+type Summary struct {
+	ActiveConnections  int
+	TotalConnections   int
+	ProxiedConnections int
+	TotalResponseTime  time.Duration
+	TotalCacheHits     int
+	TotalCacheMisses   int
+	RejectedUploads    int
+	FailedUploads      int
+	// Routes is sorted by route
+	Routes []*RouteSummary
+}
+
+// Summarize gathers the metrics of the registry and sums them up per route
+func Summarize() (*Summary, error) {
+	families, err := Registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{}
+	routes := map[string]*RouteSummary{}
+	route := func(metric *dto.Metric) *RouteSummary {
+		name := label(metric, "route")
+		if _, ok := routes[name]; !ok {
+			routes[name] = &RouteSummary{Route: name}
+		}
+		return routes[name]
+	}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			switch family.GetName() {
+			case namespace + "_http_requests_in_flight":
+				summary.ActiveConnections = int(metric.GetGauge().GetValue())
+			case namespace + "_http_requests_total":
+				count := int(metric.GetCounter().GetValue())
+				summary.TotalConnections += count
+				route(metric).Connections += count
+			case namespace + "_http_request_duration_seconds":
+				duration := time.Duration(metric.GetHistogram().GetSampleSum() * float64(time.Second))
+				summary.TotalResponseTime += duration
+				route(metric).TotalResponseTime += duration
+			case namespace + "_cache_requests_total":
+				count := int(metric.GetCounter().GetValue())
+				if label(metric, "result") == "hit" {
+					summary.TotalCacheHits += count
+					route(metric).CacheHits += count
+				} else {
+					summary.TotalCacheMisses += count
+					route(metric).CacheMisses += count
+				}
+			case namespace + "_proxy_requests_total":
+				count := int(metric.GetCounter().GetValue())
+				summary.ProxiedConnections += count
+				route(metric).ProxiedConnections += count
+			case namespace + "_upload_failures_total":
+				summary.FailedUploads += int(metric.GetCounter().GetValue())
+			case namespace + "_rejected_uploads_total":
+				summary.RejectedUploads += int(metric.GetCounter().GetValue())
+			}
+		}
+	}
+
+	for _, r := range routes {
+		summary.Routes = append(summary.Routes, r)
+	}
+	sort.Slice(summary.Routes, func(i, j int) bool {
+		return summary.Routes[i].Route < summary.Routes[j].Route
+	})
+
+	return summary, nil
+}
+
+// label returns the value of the label with the given name
+func label(metric *dto.Metric, name string) string {
+	for _, pair := range metric.GetLabel() {
+		if pair.GetName() == name {
+			return pair.GetValue()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chiMiddleware "github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader contains the id correlating the log entries of a request
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of request ids sent by clients
+const maxRequestIDLength = 128
+
+// RequestID uses the request id sent by the client or issues a new one
+// The id is returned in the response headers and stored in the context, see RequestIDFromContext
+// It's also set in the request headers, so it's passed on to the fallback proxies
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("gorge.request_id", id))
+
+		next.ServeHTTP(&requestIDWriter{ResponseWriter: w, id: id}, r.WithContext(context.WithValue(r.Context(), chiMiddleware.RequestIDKey, id)))
+	})
+}
+
+// requestIDWriter adds the request id to the response headers right before they are sent,
+// so it isn't replaced by the headers of cached or proxied responses
+type requestIDWriter struct {
+	http.ResponseWriter
+	id          string
+	wroteHeader bool
+}
+
+func (w *requestIDWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set(RequestIDHeader, w.id)
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *requestIDWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// RequestIDFromContext returns the id of the request, as set by RequestID
+func RequestIDFromContext(ctx context.Context) string {
+	return chiMiddleware.GetReqID(ctx)
+}
+
+// validRequestID only accepts short ids of printable ascii characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientIP strips the port from the remote address, RealIP has already replaced it with the address of the client
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// AccessLog writes an entry for every request to logger
+// It has to run after RealIP and RequestID, routes is used to look up the route pattern of the request
+func AccessLog(logger *zap.Logger, routes chi.Routes) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			logger.Info("request",
+				zap.String("request_id", RequestIDFromContext(r.Context())),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("route", findRoute(routes, r)),
+				zap.Int("status", status),
+				zap.Int64("bytes", recorder.bytes),
+				zap.Duration("duration", time.Since(start)),
+				zap.String("user_agent", r.UserAgent()),
+				zap.String("client_ip", clientIP(r)),
+				zap.String("cache", w.Header().Get("X-Cache")),
+				zap.String("proxied_to", w.Header().Get("X-Proxied-To")),
+			)
+		})
+	}
+}
@@ -3,14 +3,51 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/dadav/gorge/internal/auth"
+	"github.com/dadav/gorge/internal/log"
 	"github.com/go-chi/jwtauth/v5"
 )
 
-func AuthMiddleware(tokenAuth *jwtauth.JWTAuth, isProtected func(*http.Request) bool) func(next http.Handler) http.Handler {
+// requireScope rejects tokens which are revoked or lack the scope the request needs
+func requireScope(tokenStore *auth.TokenStore) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, claims, err := jwtauth.FromContext(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if id, ok := claims["jti"].(string); ok && tokenStore != nil {
+				revoked, err := tokenStore.IsRevoked(id)
+				if err != nil {
+					log.Log.Errorf("Failed to check token revocation: %v", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				if revoked {
+					http.Error(w, "token is revoked", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			action, moduleSlug := auth.RequiredScope(r)
+			if action != "" && !auth.Allowed(auth.ScopesFromClaims(claims), action, moduleSlug) {
+				log.Log.Infof("Token of %v lacks scope %s for %s %s", claims["sub"], action, r.Method, r.URL.Path)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func AuthMiddleware(tokenAuth *jwtauth.JWTAuth, tokenStore *auth.TokenStore, isProtected func(*http.Request) bool) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if isProtected(r) {
-				jwtauth.Verifier(tokenAuth)(jwtauth.Authenticator(tokenAuth)(next)).ServeHTTP(w, r)
+				jwtauth.Verifier(tokenAuth)(jwtauth.Authenticator(tokenAuth)(requireScope(tokenStore)(next))).ServeHTTP(w, r)
 				return
 			}
 			next.ServeHTTP(w, r)
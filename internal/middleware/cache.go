@@ -0,0 +1,16 @@
+package middleware
+
+import "sync/atomic"
+
+// cacheGeneration is part of every cache key, increasing it makes all cached responses stale
+var cacheGeneration atomic.Uint64
+
+// CacheGeneration returns the current generation of the response cache
+func CacheGeneration() uint64 {
+	return cacheGeneration.Load()
+}
+
+// InvalidateCache drops all cached responses, e.g. after a release has been overwritten
+func InvalidateCache() {
+	cacheGeneration.Add(1)
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// downloadPrefix is the path of the release tarballs
+const downloadPrefix = "/v3/files/"
+
+// statusRecorder remembers the status code and the size of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// CountDownloads calls count with the filename of every tarball which has been downloaded completely
+// It has to run in front of the cache, otherwise cached downloads would be missed
+func CountDownloads(count func(filename string)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, downloadPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			// Partial and conditional responses are no complete downloads
+			if recorder.status == http.StatusOK {
+				count(strings.TrimPrefix(r.URL.Path, downloadPrefix))
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dadav/gorge/internal/metrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// unmatchedRoute is the route of requests which don't match any route, e.g. unknown paths
+const unmatchedRoute = "unmatched"
+
+type routeKey struct{}
+
+// Metrics records the number, status and duration of the requests per route
+// The route is the pattern of the matching route in routes (e.g. /v3/files/{filename}), so
+// the number of time series doesn't grow with the number of modules
+func Metrics(routes chi.Routes) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			method := requestMethod(r)
+			route := findRoute(routes, r)
+
+			metrics.RequestsInFlight.Inc()
+			defer metrics.RequestsInFlight.Dec()
+
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), routeKey{}, route)))
+
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			metrics.Requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+			metrics.RequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// findRoute returns the pattern of the route in routes matching the request
+func findRoute(routes chi.Routes, r *http.Request) string {
+	if route := routes.Find(chi.NewRouteContext(), r.Method, r.URL.Path); route != "" {
+		return route
+	}
+	return unmatchedRoute
+}
+
+// Route returns the route pattern of the request, as determined by the Metrics middleware
+func Route(r *http.Request) string {
+	if route, ok := r.Context().Value(routeKey{}).(string); ok {
+		return route
+	}
+	return unmatchedRoute
+}
+
+// requestMethod limits the method label to the methods known to the router
+func requestMethod(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return r.Method
+	}
+	return "OTHER"
+}
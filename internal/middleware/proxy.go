@@ -5,15 +5,20 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 
 	"github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/metrics"
 )
 
-// capturedResponseWriter is a custom response writer that captures the response status
+// capturedResponseWriter buffers 404 responses, so they can be replaced by the response of an upstream
+// All other responses are streamed to the client right away
 type capturedResponseWriter struct {
 	http.ResponseWriter
 	body   *bytes.Buffer
 	status int
+	// captured is set if the response is buffered instead of being sent
+	captured bool
 }
 
 func NewCapturedResponseWriter(w http.ResponseWriter) *capturedResponseWriter {
@@ -24,11 +29,39 @@ func NewCapturedResponseWriter(w http.ResponseWriter) *capturedResponseWriter {
 }
 
 func (w *capturedResponseWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
 	w.status = code
+	if code == http.StatusNotFound {
+		w.captured = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
 }
 
 func (w *capturedResponseWriter) Write(body []byte) (int, error) {
-	return w.body.Write(body)
+	// Handlers which didn't set a status code succeeded
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.captured {
+		return w.body.Write(body)
+	}
+	return w.ResponseWriter.Write(body)
+}
+
+// Flush sends the buffered data of streamed responses to the client
+func (w *capturedResponseWriter) Flush() {
+	if w.captured {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.WriteHeader(http.StatusOK)
+		}
+		flusher.Flush()
+	}
 }
 
 func (w *capturedResponseWriter) sendCapturedResponse() {
@@ -36,17 +69,20 @@ func (w *capturedResponseWriter) sendCapturedResponse() {
 	w.ResponseWriter.Write(w.body.Bytes())
 }
 
-func NewSingleHostReverseProxy(target *url.URL) *httputil.ReverseProxy {
+func NewSingleHostReverseProxy(target *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
 	return &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
 			req.URL.Scheme = target.Scheme
 			req.URL.Host = target.Host
 			req.Host = target.Host
 		},
+		Transport: transport,
 	}
 }
 
-func ProxyFallback(upstreamHost string, forwardToProxy func(*http.Request, int) bool, proxiedResponseCb func(*http.Response)) func(next http.Handler) http.Handler {
+// ProxyFallback forwards the request to the upstream if forwardToProxy returns true
+// Unhealthy upstreams are skipped and the original response is served instead
+func ProxyFallback(upstream *Upstream, forwardToProxy func(*http.Request, int) bool, proxiedResponseCb func(*http.Response)) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Store original headers before any modifications
@@ -58,9 +94,21 @@ func ProxyFallback(upstreamHost string, forwardToProxy func(*http.Request, int)
 			capturedResponseWriter := NewCapturedResponseWriter(w)
 			next.ServeHTTP(capturedResponseWriter, r)
 
-			if forwardToProxy(r, capturedResponseWriter.status) {
-				log.Log.Infof("Forwarding request to %s\n", upstreamHost)
-				u, err := url.Parse(upstreamHost)
+			// Only 404 responses are buffered, all others have been sent already
+			if !capturedResponseWriter.captured {
+				return
+			}
+
+			forward := forwardToProxy(r, capturedResponseWriter.status)
+			if forward && !upstream.Allow() {
+				log.Log.Debugw("Skipping unhealthy upstream", "upstream", upstream.Url, "path", r.URL.Path, "request_id", RequestIDFromContext(r.Context()))
+				metrics.SkippedProxyRequests.WithLabelValues(upstream.Url).Inc()
+				forward = false
+			}
+
+			if forward {
+				log.Log.Infow("Forwarding request", "upstream", upstream.Url, "path", r.URL.Path, "request_id", RequestIDFromContext(r.Context()))
+				u, err := url.Parse(upstream.Url)
 				if err != nil {
 					log.Log.Error(err)
 					// Restore original headers before sending captured response
@@ -75,15 +123,21 @@ func ProxyFallback(upstreamHost string, forwardToProxy func(*http.Request, int)
 					w.Header().Del(k)
 				}
 
-				proxy := NewSingleHostReverseProxy(u)
+				proxy := NewSingleHostReverseProxy(u, upstream.transport)
 
+				// The status code of the upstream, requests which failed are counted as error
+				code := "error"
 				proxy.ModifyResponse = func(r *http.Response) error {
+					code = strconv.Itoa(r.StatusCode)
+					upstream.Observe(r, nil)
 					proxiedResponseCb(r)
 					return nil
 				}
 
 				// if some error occurs, return the original content
 				proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+					code = "error"
+					upstream.Observe(nil, err)
 					log.Log.Error(err)
 					// Restore original headers before sending captured response
 					for k, v := range originalHeaders {
@@ -92,17 +146,12 @@ func ProxyFallback(upstreamHost string, forwardToProxy func(*http.Request, int)
 					capturedResponseWriter.sendCapturedResponse()
 				}
 
-				stats := r.Context().Value("stats").(*Statistics)
-				stats.Mutex.Lock()
-				stats.ProxiedConnections++
-				stats.ProxiedConnectionsPerEndpoint[r.URL.Path]++
-				stats.Mutex.Unlock()
-
 				proxy.ServeHTTP(w, r)
+				metrics.ProxyRequests.WithLabelValues(upstream.Url, Route(r), code).Inc()
 				return
 			}
 
-			// If the response status is not 404, serve the original response
+			// If the request isn't forwarded, serve the original response
 			// Restore original headers before sending captured response
 			for k, v := range originalHeaders {
 				w.Header()[k] = v
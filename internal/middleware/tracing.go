@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// untracedPaths are polled by monitoring systems, their spans would only be noise
+var untracedPaths = []string{"/livez", "/readyz", "/metrics"}
+
+// Tracing starts a span for every request, continuing the trace of the trace headers of the request
+// The spans are named by the method and the pattern of the matching route in routes
+func Tracing(routes chi.Routes) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return otelhttp.NewHandler(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(findRoute(routes, r)))
+				next.ServeHTTP(w, r)
+			}),
+			"gorge",
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return requestMethod(r) + " " + findRoute(routes, r)
+			}),
+			otelhttp.WithFilter(func(r *http.Request) bool {
+				return !slices.Contains(untracedPaths, r.URL.Path)
+			}),
+		)
+	}
+}
@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/metrics"
+	"github.com/dadav/gorge/internal/tracing"
+)
+
+// healthCheckPath is requested by the active health checks, it's served by every forge
+const healthCheckPath = "/v3/modules?limit=1"
+
+// UpstreamConfig configures the timeouts and the circuit breaker of the fallback proxies
+type UpstreamConfig struct {
+	// Timeout limits connecting to the upstream and waiting for the response headers, 0 disables it
+	Timeout time.Duration
+	// Retries is the number of times idempotent requests are retried after connection errors
+	Retries int
+	// FailureThreshold is the number of consecutive failures after which the upstream is skipped
+	FailureThreshold int
+	// Cooldown is the time an unhealthy upstream is skipped before it's tried again
+	Cooldown time.Duration
+}
+
+// Upstream is a fallback proxy, it tracks the health of the proxy by the results of the
+// forwarded requests and the health checks. After FailureThreshold consecutive failures the
+// circuit opens and the proxy is skipped for the cooldown. Afterwards requests are forwarded
+// again, a single failure opens the circuit again while a success closes it.
+type Upstream struct {
+	Url       string
+	config    UpstreamConfig
+	transport http.RoundTripper
+
+	mu          sync.Mutex
+	failures    int
+	openUntil   time.Time
+	lastError   string
+	lastSuccess time.Time
+}
+
+// UpstreamStatus is the health of an upstream, as shown in the ui and by /readyz
+type UpstreamStatus struct {
+	Url     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	// State of the circuit breaker, closed (healthy), open (skipped) or half-open (tried again)
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	// RetryAt is set while the upstream is skipped
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+}
+
+func NewUpstream(url string, config UpstreamConfig) *Upstream {
+	config.FailureThreshold = max(config.FailureThreshold, 1)
+
+	base := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: config.Timeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   config.Timeout,
+		ResponseHeaderTimeout: config.Timeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   10,
+	}
+
+	upstream := &Upstream{
+		Url:       strings.TrimSuffix(strings.TrimSpace(url), "/"),
+		config:    config,
+		transport: &retryTransport{base: tracing.NewTransport(base), retries: config.Retries},
+	}
+	metrics.UpstreamUp.WithLabelValues(upstream.Url).Set(1)
+	return upstream
+}
+
+// Allow reports whether requests may be forwarded to the upstream
+func (u *Upstream) Allow() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.state(time.Now()) != "open"
+}
+
+// state returns the state of the circuit breaker, the caller has to hold mu
+func (u *Upstream) state(now time.Time) string {
+	if u.failures < u.config.FailureThreshold {
+		return "closed"
+	}
+	if now.Before(u.openUntil) {
+		return "open"
+	}
+	return "half-open"
+}
+
+// Success records a request answered by the upstream, it closes the circuit
+func (u *Upstream) Success() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.failures >= u.config.FailureThreshold {
+		log.Log.Infof("Upstream %s is healthy again", u.Url)
+	}
+	u.failures = 0
+	u.lastSuccess = time.Now()
+	metrics.UpstreamUp.WithLabelValues(u.Url).Set(1)
+}
+
+// Failure records a failed request, the circuit opens once the threshold is reached
+func (u *Upstream) Failure(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.failures++
+	u.lastError = err.Error()
+	if u.failures >= u.config.FailureThreshold {
+		if u.failures == u.config.FailureThreshold {
+			log.Log.Warnf("Upstream %s is unhealthy, skipping it for %s: %v", u.Url, u.config.Cooldown, err)
+		}
+		u.openUntil = time.Now().Add(u.config.Cooldown)
+		metrics.UpstreamUp.WithLabelValues(u.Url).Set(0)
+	}
+}
+
+// Observe records the result of a request sent to the upstream
+// Server errors count as failure, requests canceled by the client are ignored
+func (u *Upstream) Observe(resp *http.Response, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+	case err != nil:
+		u.Failure(err)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		u.Failure(fmt.Errorf("unexpected status %s", resp.Status))
+	default:
+		u.Success()
+	}
+}
+
+// ErrUpstreamUnhealthy is returned by Do while the upstream is skipped
+var ErrUpstreamUnhealthy = errors.New("upstream is unhealthy")
+
+// Do sends a request to the upstream and records the result
+// Requests are refused while the circuit is open
+func (u *Upstream) Do(req *http.Request) (*http.Response, error) {
+	if !u.Allow() {
+		metrics.SkippedProxyRequests.WithLabelValues(u.Url).Inc()
+		return nil, fmt.Errorf("%w: %s", ErrUpstreamUnhealthy, u.Url)
+	}
+
+	resp, err := u.transport.RoundTrip(req)
+	u.Observe(resp, err)
+	return resp, err
+}
+
+// Check actively requests the upstream and records the result
+func (u *Upstream) Check(ctx context.Context) {
+	if u.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.Url+healthCheckPath, nil)
+	if err != nil {
+		u.Failure(err)
+		return
+	}
+	req.Header.Set("User-Agent", "gorge")
+
+	resp, err := u.transport.RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	// The check was aborted by the shutdown
+	if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+		return
+	}
+	u.Observe(resp, err)
+}
+
+// Status returns the current health of the upstream
+func (u *Upstream) Status() UpstreamStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	status := UpstreamStatus{
+		Url:                 u.Url,
+		State:               u.state(now),
+		ConsecutiveFailures: u.failures,
+		LastError:           u.lastError,
+	}
+	status.Healthy = status.State == "closed"
+	if !u.lastSuccess.IsZero() {
+		lastSuccess := u.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	if status.State == "open" {
+		retryAt := u.openUntil
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// UpstreamStatuses returns the health of all upstreams
+func UpstreamStatuses(upstreams []*Upstream) []UpstreamStatus {
+	statuses := make([]UpstreamStatus, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		statuses = append(statuses, upstream.Status())
+	}
+	return statuses
+}
+
+// CheckUpstreams checks the health of all upstreams concurrently
+func CheckUpstreams(ctx context.Context, upstreams []*Upstream) {
+	var wg sync.WaitGroup
+	for _, upstream := range upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			upstream.Check(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// retryTransport retries idempotent requests without body after connection errors
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	retryable := (req.Method == http.MethodGet || req.Method == http.MethodHead) && (req.Body == nil || req.Body == http.NoBody)
+	for attempt := 0; err != nil && retryable && attempt < t.retries && req.Context().Err() == nil; attempt++ {
+		log.Log.Debugf("Retrying %s %s after error: %v", req.Method, req.URL, err)
+		resp, err = t.base.RoundTrip(req)
+	}
+	return resp, err
+}
@@ -25,4 +25,5 @@ type ReleaseMetadata struct {
 	IssuesUrl              string              `json:"issues_url,omitempty"`
 	OperatingsystemSupport []SupportedOS       `json:"operatingsystem_support,omitempty"`
 	Tags                   []string            `json:"tags,omitempty"`
+	PdkVersion             string              `json:"pdk-version,omitempty"`
 }
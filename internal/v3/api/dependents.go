@@ -0,0 +1,169 @@
+package v3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/v3/backend"
+	"github.com/dadav/gorge/internal/v3/utils"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+	"github.com/go-chi/chi/v5"
+)
+
+// moduleOperationsController wraps the generated controller
+// It adds the endpoint listing the dependents of a module and the force parameter of DeleteModule
+type moduleOperationsController struct {
+	gen.Router
+	service *ModuleOperationsApi
+}
+
+func NewModuleOperationsController(s *ModuleOperationsApi) gen.Router {
+	return &moduleOperationsController{
+		Router:  gen.NewModuleOperationsAPIController(s),
+		service: s,
+	}
+}
+
+func (c *moduleOperationsController) Routes() gen.Routes {
+	routes := c.Router.Routes()
+	if route, ok := routes["DeleteModule"]; ok {
+		route.HandlerFunc = withForce(route.HandlerFunc)
+		routes["DeleteModule"] = route
+	}
+	routes["GetModuleDependents"] = gen.Route{
+		Method:      http.MethodGet,
+		Pattern:     "/v3/modules/{module_slug}/dependents",
+		HandlerFunc: c.GetModuleDependents,
+	}
+	return routes
+}
+
+// GetModuleDependents - List the releases depending on a module
+func (c *moduleOperationsController) GetModuleDependents(w http.ResponseWriter, r *http.Request) {
+	moduleSlug := chi.URLParam(r, "module_slug")
+	result, err := c.service.GetModuleDependents(r.Context(), moduleSlug)
+	if err != nil {
+		gen.DefaultErrorHandler(w, r, err, &result)
+		return
+	}
+	gen.EncodeJSONResponse(result.Body, &result.Code, w)
+}
+
+type forceKey struct{}
+
+// withForce stores the force query parameter in the request context,
+// the generated handlers don't pass it to the services
+func withForce(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+		next(w, r.WithContext(context.WithValue(r.Context(), forceKey{}, force)))
+	}
+}
+
+// forced reports whether the request asked to ignore the dependents of the deleted module or release
+func forced(ctx context.Context) bool {
+	force, _ := ctx.Value(forceKey{}).(bool)
+	return force
+}
+
+// ModuleDependent is a release depending on a module
+type ModuleDependent struct {
+	Slug               string `json:"slug"`
+	Uri                string `json:"uri"`
+	Module             string `json:"module"`
+	Version            string `json:"version"`
+	VersionRequirement string `json:"version_requirement"`
+	Current            bool   `json:"current"`
+}
+
+type GetModuleDependents200Response struct {
+	Results []ModuleDependent `json:"results"`
+}
+
+type Delete409Response struct {
+	Message    string            `json:"message,omitempty"`
+	Errors     []string          `json:"errors,omitempty"`
+	Dependents []ModuleDependent `json:"dependents"`
+}
+
+func toModuleDependents(dependents []*backend.Dependent) []ModuleDependent {
+	result := make([]ModuleDependent, 0, len(dependents))
+	for _, dependent := range dependents {
+		result = append(result, ModuleDependent{
+			Slug:               dependent.Release.Slug,
+			Uri:                dependent.Release.Uri,
+			Module:             dependent.Release.Module.Slug,
+			Version:            dependent.Release.Version,
+			VersionRequirement: dependent.VersionRequirement,
+			Current:            dependent.Current,
+		})
+	}
+	return result
+}
+
+// GetModuleDependents - List the releases depending on a module
+func (s *ModuleOperationsApi) GetModuleDependents(ctx context.Context, moduleSlug string) (gen.ImplResponse, error) {
+	if !utils.CheckModuleSlug(moduleSlug) {
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: "invalid module slug",
+			Errors:  []string{"invalid module slug"},
+		}), nil
+	}
+
+	dependents, err := backend.Traced(ctx).GetDependents(moduleSlug)
+	if err != nil {
+		return gen.Response(http.StatusInternalServerError, GetModule500Response{
+			Message: "Failed to fetch dependents",
+			Errors:  []string{err.Error()},
+		}), nil
+	}
+
+	return gen.Response(http.StatusOK, GetModuleDependents200Response{
+		Results: toModuleDependents(dependents),
+	}), nil
+}
+
+// checkDependents refuses the deletion of the releases if current releases of other modules would
+// have no matching release left. With force the deletion is only logged as a warning.
+// A nil response means the deletion can proceed.
+func checkDependents(ctx context.Context, moduleSlug string, removed []string) (*gen.ImplResponse, error) {
+	dependents, err := backend.Traced(ctx).GetDependents(moduleSlug)
+	if err != nil || len(dependents) == 0 {
+		return nil, err
+	}
+
+	releases, _, err := backend.Traced(ctx).QueryReleases(&backend.ReleaseQuery{Module: moduleSlug})
+	if err != nil {
+		return nil, err
+	}
+	if removed == nil {
+		for _, release := range releases {
+			removed = append(removed, release.Slug)
+		}
+	}
+
+	broken := backend.BrokenDependents(dependents, releases, removed)
+	if len(broken) == 0 {
+		return nil, nil
+	}
+
+	reasons := make([]string, 0, len(broken))
+	for _, dependent := range broken {
+		reasons = append(reasons, fmt.Sprintf("%s requires %s %s", dependent.Release.Slug, moduleSlug, dependent.VersionRequirement))
+	}
+
+	if forced(ctx) {
+		log.Log.Warnf("Deleting %v although it's still required: %v", removed, reasons)
+		return nil, nil
+	}
+
+	response := gen.Response(http.StatusConflict, Delete409Response{
+		Message:    "Still required by other modules, use force=true to delete anyway",
+		Errors:     reasons,
+		Dependents: toModuleDependents(broken),
+	})
+	return &response, nil
+}
@@ -0,0 +1,49 @@
+package v3
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// splitSpaceDelimited splits list parameters which are space delimited in the spec (e.g. base+pe_only),
+// the generated controllers only split them at commas
+func splitSpaceDelimited(values []string) []string {
+	result := []string{}
+	for _, value := range values {
+		result = append(result, strings.Fields(value)...)
+	}
+	return result
+}
+
+// selectFields reduces the json object of v to the fields in includeFields
+// and removes the fields in excludeFields. Only top level fields are considered.
+func selectFields(v interface{}, includeFields, excludeFields []string) (interface{}, error) {
+	if len(includeFields) == 0 && len(excludeFields) == 0 {
+		return v, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	if len(includeFields) > 0 {
+		for field := range fields {
+			if !slices.Contains(includeFields, field) {
+				delete(fields, field)
+			}
+		}
+	}
+
+	for _, field := range excludeFields {
+		delete(fields, field)
+	}
+
+	return fields, nil
+}
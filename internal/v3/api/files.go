@@ -0,0 +1,85 @@
+package v3
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dadav/gorge/internal/v3/backend"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+	"github.com/go-chi/chi/v5"
+)
+
+// releaseOperationsController wraps the generated controller
+// The generated GetFile handler reads the whole file into memory and doesn't support range or conditional requests
+type releaseOperationsController struct {
+	gen.Router
+	service *ReleaseOperationsApi
+}
+
+// NewReleaseOperationsController creates the release controller with a GetFile handler streaming from the backend,
+// streamed uploads, an additional endpoint to restore deleted releases and the force parameter of DeleteRelease
+func NewReleaseOperationsController(s *ReleaseOperationsApi) gen.Router {
+	return &releaseOperationsController{
+		Router:  gen.NewReleaseOperationsAPIController(s, gen.WithReleaseOperationsAPIErrorHandler(uploadErrorHandler)),
+		service: s,
+	}
+}
+
+func (c *releaseOperationsController) Routes() gen.Routes {
+	routes := c.Router.Routes()
+	if route, ok := routes["GetFile"]; ok {
+		route.HandlerFunc = c.GetFile
+		routes["GetFile"] = route
+	}
+	if route, ok := routes["AddRelease"]; ok {
+		route.HandlerFunc = countUploadFailures(c.withStreamedUploads(route.HandlerFunc))
+		routes["AddRelease"] = route
+	}
+	if route, ok := routes["DeleteRelease"]; ok {
+		route.HandlerFunc = withForce(route.HandlerFunc)
+		routes["DeleteRelease"] = route
+	}
+	routes["RestoreRelease"] = gen.Route{
+		Method:      http.MethodPost,
+		Pattern:     "/v3/releases/{release_slug}/restore",
+		HandlerFunc: c.RestoreRelease,
+	}
+	return routes
+}
+
+// RestoreRelease - Restore a deleted module release
+func (c *releaseOperationsController) RestoreRelease(w http.ResponseWriter, r *http.Request) {
+	releaseSlug := chi.URLParam(r, "release_slug")
+	result, err := c.service.RestoreRelease(r.Context(), releaseSlug)
+	if err != nil {
+		gen.DefaultErrorHandler(w, r, err, &result)
+		return
+	}
+	gen.EncodeJSONResponse(result.Body, &result.Code, w)
+}
+
+// GetFile - Download module release
+func (c *releaseOperationsController) GetFile(w http.ResponseWriter, r *http.Request) {
+	filename := chi.URLParam(r, "filename")
+	result, err := c.service.GetFile(r.Context(), filename)
+	if err != nil {
+		gen.DefaultErrorHandler(w, r, err, &result)
+		return
+	}
+
+	f, ok := result.Body.(*backend.ReleaseFile)
+	if !ok {
+		gen.EncodeJSONResponse(result.Body, &result.Code, w)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/x-gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	if f.Sha256 != "" {
+		w.Header().Set("ETag", fmt.Sprintf(`"%s"`, f.Sha256))
+	}
+
+	// Handles range requests, If-None-Match and If-Modified-Since
+	http.ServeContent(w, r, filename, f.ModTime, f)
+}
@@ -3,13 +3,15 @@ package v3
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
-	"net/url"
+	"os"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	customMiddleware "github.com/dadav/gorge/internal/middleware"
 	"github.com/dadav/gorge/internal/v3/backend"
 	"github.com/dadav/gorge/internal/v3/utils"
 	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
@@ -47,11 +49,36 @@ func (s *ModuleOperationsApi) DeleteModule(ctx context.Context, moduleSlug strin
 		), nil
 	}
 
-	err := backend.ConfiguredBackend.DeleteModuleBySlug(moduleSlug)
+	response, err := checkDependents(ctx, moduleSlug, nil)
+	if err != nil {
+		return gen.Response(
+			500,
+			DeleteModule500Response{
+				Message: err.Error(),
+				Errors:  []string{err.Error()},
+			},
+		), nil
+	}
+	if response != nil {
+		return *response, nil
+	}
+
+	err = backend.Traced(ctx).DeleteModuleBySlug(moduleSlug, reason)
 	if err == nil {
+		// Cached downloads of the releases must not be served anymore
+		customMiddleware.InvalidateCache()
 		return gen.Response(204, nil), nil
 	}
 
+	if errors.Is(err, os.ErrNotExist) {
+		return gen.Response(
+			http.StatusNotFound,
+			GetModule404Response{
+				Message: http.StatusText(http.StatusNotFound),
+				Errors:  []string{"Module could not be found"},
+			}), nil
+	}
+
 	return gen.Response(
 		500,
 		DeleteModule500Response{
@@ -62,6 +89,7 @@ func (s *ModuleOperationsApi) DeleteModule(ctx context.Context, moduleSlug strin
 }
 
 // DeprecateModule - Deprecate module
+// The action undeprecate reverts a previous deprecation
 func (s *ModuleOperationsApi) DeprecateModule(ctx context.Context, moduleSlug string, deprecationRequest gen.DeprecationRequest) (gen.ImplResponse, error) {
 	// Validate module slug
 	if !utils.CheckModuleSlug(moduleSlug) {
@@ -76,7 +104,7 @@ func (s *ModuleOperationsApi) DeprecateModule(ctx context.Context, moduleSlug st
 	}
 
 	// Check if module exists
-	module, err := backend.ConfiguredBackend.GetModuleBySlug(moduleSlug)
+	module, err := backend.Traced(ctx).GetModuleBySlug(moduleSlug)
 	if err != nil {
 		return gen.Response(
 			http.StatusNotFound,
@@ -87,24 +115,55 @@ func (s *ModuleOperationsApi) DeprecateModule(ctx context.Context, moduleSlug st
 		), nil
 	}
 
-	// Update module deprecation status
-	deprecatedAt := time.Now().UTC().Format(time.RFC3339)
-	module.DeprecatedAt = &deprecatedAt
-	module.DeprecatedFor = deprecationRequest.Params.Reason
+	switch deprecationRequest.Action {
+	case "", "deprecate":
+		deprecatedAt := time.Now().UTC().Format(time.RFC3339)
+		module.DeprecatedAt = &deprecatedAt
+		module.DeprecatedFor = nil
+		module.SupersededBy = gen.ModuleSupersededBy{}
+
+		if params := deprecationRequest.Params; params != nil {
+			module.DeprecatedFor = params.Reason
 
-	if *deprecationRequest.Params.ReplacementSlug != "" {
-		module.SupersededBy = gen.ModuleSupersededBy{
-			Slug: *deprecationRequest.Params.ReplacementSlug,
+			if params.ReplacementSlug != nil && *params.ReplacementSlug != "" {
+				if !utils.CheckModuleSlug(*params.ReplacementSlug) {
+					err := errors.New("invalid replacement slug")
+					return gen.Response(
+						http.StatusBadRequest,
+						DeleteModule500Response{
+							Message: err.Error(),
+							Errors:  []string{err.Error()},
+						},
+					), nil
+				}
+				module.SupersededBy = gen.ModuleSupersededBy{
+					Uri:  fmt.Sprintf("/v3/modules/%s", *params.ReplacementSlug),
+					Slug: *params.ReplacementSlug,
+				}
+			}
 		}
+	case "undeprecate":
+		module.DeprecatedAt = nil
+		module.DeprecatedFor = nil
+		module.SupersededBy = gen.ModuleSupersededBy{}
+	default:
+		err := fmt.Errorf("invalid action %s, must be deprecate or undeprecate", deprecationRequest.Action)
+		return gen.Response(
+			http.StatusBadRequest,
+			DeleteModule500Response{
+				Message: err.Error(),
+				Errors:  []string{err.Error()},
+			},
+		), nil
 	}
 
 	// Save the updated module
-	err = backend.ConfiguredBackend.UpdateModule(module)
+	err = backend.Traced(ctx).UpdateModule(module)
 	if err != nil {
 		return gen.Response(
 			http.StatusInternalServerError,
 			DeleteModule500Response{
-				Message: "Failed to deprecate module",
+				Message: "Failed to update module",
 				Errors:  []string{err.Error()},
 			},
 		), nil
@@ -125,8 +184,9 @@ type GetModule500Response struct {
 
 // GetModule - Fetch module
 func (s *ModuleOperationsApi) GetModule(ctx context.Context, moduleSlug string, withHtml bool, includeFields []string, excludeFields []string, ifModifiedSince string) (gen.ImplResponse, error) {
-	module, err := backend.ConfiguredBackend.GetModuleBySlug(moduleSlug)
-	if err != nil {
+	module, err := backend.Traced(ctx).GetModuleBySlug(moduleSlug)
+	// Modules whose releases have all been deleted are hidden
+	if err != nil || backend.ModuleDeleted(module) {
 		return gen.Response(
 			http.StatusNotFound,
 			GetModule404Response{
@@ -135,7 +195,36 @@ func (s *ModuleOperationsApi) GetModule(ctx context.Context, moduleSlug string,
 			}), nil
 	}
 
-	return gen.Response(http.StatusOK, module), nil
+	result, err := selectFields(module, splitSpaceDelimited(includeFields), splitSpaceDelimited(excludeFields))
+	if err != nil {
+		return gen.Response(
+			http.StatusInternalServerError,
+			GetModule500Response{
+				Message: "Failed to fetch module",
+				Errors:  []string{err.Error()},
+			}), nil
+	}
+
+	return gen.Response(http.StatusOK, result), nil
+}
+
+type GetModules200Response struct {
+	Pagination gen.GetModules200ResponsePagination `json:"pagination,omitempty"`
+	Results    []interface{}                       `json:"results"`
+}
+
+var moduleSortValues = []string{"", "rank", "downloads", "latest_release"}
+
+// parseReleaseSince accepts a date like 2024-01-31 or a RFC3339 timestamp
+func parseReleaseSince(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid with_release_since %q, expected a date like 2024-01-31", value)
+	}
+	return t, nil
 }
 
 // GetModules - List modules
@@ -149,121 +238,192 @@ func (s *ModuleOperationsApi) GetModules(ctx context.Context, limit int32, offse
 	if offset < 0 {
 		offset = defaultOffset
 	}
+	endorsements = splitSpaceDelimited(endorsements)
+	moduleGroups = splitSpaceDelimited(moduleGroups)
+	slugs = splitSpaceDelimited(slugs)
+	includeFields = splitSpaceDelimited(includeFields)
+	excludeFields = splitSpaceDelimited(excludeFields)
 
-	// Get all modules with error handling
-	allModules, err := backend.ConfiguredBackend.GetAllModules()
-	if err != nil {
-		return gen.Response(
-			http.StatusInternalServerError,
-			GetModule500Response{
-				Message: "Failed to fetch modules",
-				Errors:  []string{err.Error()},
-			}), nil
+	moduleQuery := &backend.ModuleQuery{
+		Limit:                  int(limit),
+		Offset:                 int(offset),
+		SortBy:                 sortBy,
+		Query:                  query,
+		Tag:                    tag,
+		Owner:                  owner,
+		WithTasks:              withTasks,
+		WithPlans:              withPlans,
+		WithPdk:                withPdk,
+		Premium:                premium,
+		ExcludePremium:         excludePremium,
+		Endorsements:           endorsements,
+		ShowDeleted:            showDeleted,
+		HideDeprecated:         hideDeprecated,
+		Supported:              supported,
+		ModuleGroups:           moduleGroups,
+		Slugs:                  slugs,
+		StartsWith:             startsWith,
+		Operatingsystem:        operatingsystem,
+		OperatingsystemRelease: operatingsystemrelease,
+		WithMinimumScore:       int(withMinimumScore),
+		OnlyLatest:             onlyLatest,
 	}
 
-	// Check offset validity early
-	if int(offset) >= len(allModules) {
-		return gen.Response(
-			http.StatusNotFound,
-			GetModule404Response{
-				Message: "Invalid offset",
-				Errors:  []string{"The given offset is larger than the total number of modules"},
-			}), nil
+	validationErrors := []string{}
+	if !slices.Contains(moduleSortValues, sortBy) {
+		validationErrors = append(validationErrors, fmt.Sprintf("invalid sort_by %q, must be one of rank, downloads or latest_release", sortBy))
 	}
-
-	// Create filter function type and map of filters
-	type filterFunc func(m *gen.Module) bool
-	filters := make(map[string]filterFunc)
-
-	// Add filters conditionally
-	if query != "" {
-		filters["query"] = func(m *gen.Module) bool {
-			return strings.Contains(m.Slug, query) || strings.Contains(m.Owner.Slug, query)
-		}
+	if premium && excludePremium {
+		validationErrors = append(validationErrors, "premium and exclude_premium can't be combined")
 	}
-	if tag != "" {
-		filters["tag"] = func(m *gen.Module) bool {
-			return slices.Contains(m.CurrentRelease.Tags, tag)
-		}
+	if operatingsystemrelease != "" && operatingsystem == "" {
+		validationErrors = append(validationErrors, "operatingsystemrelease requires operatingsystem")
 	}
-	if owner != "" {
-		filters["owner"] = func(m *gen.Module) bool {
-			return m.Owner.Username == owner
-		}
+	if withMinimumScore < 0 {
+		validationErrors = append(validationErrors, "with_minimum_score must not be negative")
 	}
-	if withTasks {
-		filters["with_tasks"] = func(m *gen.Module) bool {
-			return len(m.CurrentRelease.Tasks) > 0
+	for _, group := range moduleGroups {
+		if group != backend.ModuleGroupBase && group != backend.ModuleGroupPeOnly {
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid module group %q, must be base or pe_only", group))
 		}
 	}
-	if withPlans {
-		filters["with_plans"] = func(m *gen.Module) bool {
-			return len(m.CurrentRelease.Plans) > 0
+	if peRequirement != "" {
+		r, err := utils.ParseVersionRange(peRequirement)
+		if err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid pe_requirement: %v", err))
 		}
+		moduleQuery.PeRequirement = r
 	}
-	if withPdk {
-		filters["with_pdk"] = func(m *gen.Module) bool {
-			return m.CurrentRelease.Pdk
+	if puppetRequirement != "" {
+		r, err := utils.ParseVersionRange(puppetRequirement)
+		if err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid puppet_requirement: %v", err))
 		}
+		moduleQuery.PuppetRequirement = r
 	}
-	if premium {
-		filters["premium"] = func(m *gen.Module) bool {
-			return m.Premium
+	if withReleaseSince != "" {
+		since, err := parseReleaseSince(withReleaseSince)
+		if err != nil {
+			validationErrors = append(validationErrors, err.Error())
 		}
+		moduleQuery.WithReleaseSince = since
 	}
-	if excludePremium {
-		filters["exclude_premium"] = func(m *gen.Module) bool {
-			return !m.Premium
+	if len(slugs) > 0 {
+		// The slugs select the modules directly, so no further filters are allowed
+		if query != "" || tag != "" || owner != "" || withTasks || withPlans || withPdk || premium || excludePremium ||
+			len(endorsements) > 0 || operatingsystem != "" || peRequirement != "" || puppetRequirement != "" ||
+			withMinimumScore > 0 || len(moduleGroups) > 0 || hideDeprecated || startsWith != "" || supported || withReleaseSince != "" {
+			validationErrors = append(validationErrors, "slugs can't be combined with other filters")
 		}
-	}
-	if len(endorsements) > 0 {
-		filters["endorsements"] = func(m *gen.Module) bool {
-			return m.Endorsement != nil && slices.Contains(endorsements, *m.Endorsement)
+		for _, slug := range slugs {
+			if !utils.CheckModuleSlug(slug) {
+				validationErrors = append(validationErrors, fmt.Sprintf("invalid module slug %q", slug))
+			}
 		}
 	}
+	if len(validationErrors) > 0 {
+		return gen.Response(
+			http.StatusBadRequest,
+			gen.GetFile400Response{
+				Message: "Invalid query parameters",
+				Errors:  validationErrors,
+			}), nil
+	}
 
-	// Apply filters
-	filtered := make([]gen.Module, 0)
-	for _, m := range allModules[offset:] {
-		passes := true
-		for _, filter := range filters {
-			if !filter(m) {
-				passes = false
-				break
+	// All requested modules have to exist
+	if len(slugs) > 0 {
+		missing := []string{}
+		for _, slug := range slugs {
+			module, err := backend.Traced(ctx).GetModuleBySlug(slug)
+			if err != nil || (!showDeleted && backend.ModuleDeleted(module)) {
+				missing = append(missing, fmt.Sprintf("Module %s could not be found", slug))
 			}
 		}
-		if passes {
-			filtered = append(filtered, *m)
+		if len(missing) > 0 {
+			return gen.Response(
+				http.StatusNotFound,
+				GetModule404Response{
+					Message: http.StatusText(http.StatusNotFound),
+					Errors:  missing,
+				}), nil
 		}
 	}
 
-	// Apply pagination
-	endIndex := int(limit)
-	if endIndex > len(filtered) {
-		endIndex = len(filtered)
+	modules, total, err := backend.Traced(ctx).QueryModules(moduleQuery)
+	if err != nil {
+		return gen.Response(
+			http.StatusInternalServerError,
+			GetModule500Response{
+				Message: "Failed to fetch modules",
+				Errors:  []string{err.Error()},
+			}), nil
 	}
-	results := filtered[:endIndex]
 
-	base, _ := url.Parse("/v3/modules")
-	params := url.Values{}
-	params.Add("offset", strconv.Itoa(int(offset)))
-	params.Add("limit", strconv.Itoa(int(limit)))
+	// Check offset validity
+	if offset > 0 && int(offset) >= total {
+		return gen.Response(
+			http.StatusNotFound,
+			GetModule404Response{
+				Message: "Invalid offset",
+				Errors:  []string{"The given offset is larger than the total number of modules"},
+			}), nil
+	}
 
-	base.RawQuery = params.Encode()
-	currentInf := interface{}(base.String())
-	params.Set("offset", "0")
-	firstInf := interface{}(base.String())
-	params.Set("offset", strconv.Itoa(int(offset)+len(results)))
-	nextInf := interface{}(base.String())
+	results := make([]interface{}, 0, len(modules))
+	for _, m := range modules {
+		result, err := selectFields(m, includeFields, excludeFields)
+		if err != nil {
+			return gen.Response(
+				http.StatusInternalServerError,
+				GetModule500Response{
+					Message: "Failed to fetch modules",
+					Errors:  []string{err.Error()},
+				}), nil
+		}
+		results = append(results, result)
+	}
+
+	// The links keep all filters of the current request
+	params := queryParams{}
+	params.set("sort_by", sortBy)
+	params.set("query", query)
+	params.set("tag", tag)
+	params.set("owner", owner)
+	params.setBool("with_tasks", withTasks)
+	params.setBool("with_plans", withPlans)
+	params.setBool("with_pdk", withPdk)
+	params.setBool("premium", premium)
+	params.setBool("exclude_premium", excludePremium)
+	params.set("endorsements", strings.Join(endorsements, ","))
+	params.set("operatingsystem", operatingsystem)
+	params.set("operatingsystemrelease", operatingsystemrelease)
+	params.set("pe_requirement", peRequirement)
+	params.set("puppet_requirement", puppetRequirement)
+	if withMinimumScore > 0 {
+		params.set("with_minimum_score", strconv.Itoa(int(withMinimumScore)))
+	}
+	params.set("module_groups", strings.Join(moduleGroups, ","))
+	params.setBool("show_deleted", showDeleted)
+	params.setBool("hide_deprecated", hideDeprecated)
+	params.setBool("only_latest", onlyLatest)
+	params.set("slugs", strings.Join(slugs, ","))
+	params.setBool("with_html", withHtml)
+	params.set("include_fields", strings.Join(includeFields, ","))
+	params.set("exclude_fields", strings.Join(excludeFields, ","))
+	params.set("starts_with", startsWith)
+	params.setBool("supported", supported)
+	params.set("with_release_since", withReleaseSince)
+	links := newPaginationLinks("/v3/modules", params, limit, offset, len(results), total)
 
-	return gen.Response(http.StatusOK, gen.GetModules200Response{
+	return gen.Response(http.StatusOK, GetModules200Response{
 		Pagination: gen.GetModules200ResponsePagination{
-			Limit:   limit,
-			Offset:  offset,
-			First:   &firstInf,
-			Current: &currentInf,
-			Next:    &nextInf,
-			Total:   int32(len(allModules)),
+			Limit:    limit,
+			Offset:   offset,
+			First:    links.First,
+			Previous: links.Previous,
+			Current:  links.Current,
+			Next:     links.Next,
+			Total:    int32(total),
 		},
 		Results: results,
 	}), nil
@@ -0,0 +1,57 @@
+package v3
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// queryParams collects the parameters of the current request for the pagination links, empty values are skipped
+type queryParams url.Values
+
+func (p queryParams) set(key, value string) {
+	if value != "" {
+		url.Values(p).Set(key, value)
+	}
+}
+
+func (p queryParams) setBool(key string, value bool) {
+	if value {
+		url.Values(p).Set(key, "true")
+	}
+}
+
+// paginationLinks contains the links of the pagination object of a listing
+type paginationLinks struct {
+	First    *interface{}
+	Previous *string
+	Current  *interface{}
+	Next     *interface{}
+}
+
+// newPaginationLinks builds the links of the listing at path, all of them keep the parameters of the current request
+// count is the number of results on the current page and total the number of all results, next is null on the last page
+func newPaginationLinks(path string, params queryParams, limit, offset int32, count, total int) paginationLinks {
+	values := url.Values(params)
+	link := func(offset int) string {
+		values.Set("offset", strconv.Itoa(offset))
+		values.Set("limit", strconv.Itoa(int(limit)))
+		return path + "?" + values.Encode()
+	}
+
+	first := interface{}(link(0))
+	current := interface{}(link(int(offset)))
+	links := paginationLinks{First: &first, Current: &current}
+
+	var next interface{}
+	if nextOffset := int(offset) + count; nextOffset < total {
+		next = link(nextOffset)
+	}
+	links.Next = &next
+
+	if offset > 0 {
+		previous := link(max(int(offset)-int(limit), 0))
+		links.Previous = &previous
+	}
+
+	return links
+}
@@ -6,14 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"os"
-	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 
+	"github.com/dadav/gorge/internal/auth"
 	"github.com/dadav/gorge/internal/config"
 	"github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/metrics"
+	customMiddleware "github.com/dadav/gorge/internal/middleware"
 	"github.com/dadav/gorge/internal/v3/backend"
 	"github.com/dadav/gorge/internal/v3/utils"
 	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
@@ -49,16 +50,70 @@ func (s *ReleaseOperationsApi) AddRelease(ctx context.Context, addReleaseRequest
 		}), nil
 	}
 
-	decodedTarball, err := base64.StdEncoding.DecodeString(addReleaseRequest.File)
+	// The tarball is decoded while it's written to the temporary file
+	maxSize := maxUploadSize()
+	upload, err := receiveUpload(base64.NewDecoder(base64.StdEncoding, strings.NewReader(addReleaseRequest.File)), maxSize)
 	if err != nil {
+		return uploadFailed(err, maxSize), nil
+	}
+	defer upload.Close()
+
+	return s.addRelease(ctx, upload)
+}
+
+// addRelease publishes the tarball, regardless of how it has been uploaded
+func (s *ReleaseOperationsApi) addRelease(ctx context.Context, upload *backend.Upload) (gen.ImplResponse, error) {
+	if backend.ConfiguredValidationPolicy != nil {
+		var validationErr *backend.ValidationError
+		if err := backend.ConfiguredValidationPolicy.CheckSize(upload.Size); errors.As(err, &validationErr) {
+			return validationFailed(validationErr), nil
+		}
+	}
+
+	archive, err := upload.Archive()
+	if err != nil {
+		if errors.Is(err, backend.ErrUnsafeArchive) {
+			return rejectUnsafeArchive(err), nil
+		}
 		return gen.Response(400, gen.GetFile400Response{
-			Message: "Invalid base64 encoded data",
+			Message: "Failed to read release metadata",
 			Errors:  []string{err.Error()},
 		}), nil
 	}
 
-	release, err := backend.ConfiguredBackend.AddRelease(decodedTarball)
+	moduleSlug := auth.ModuleSlug(archive.Metadata.Name)
+	if !auth.Authorize(ctx, auth.ScopePublish, moduleSlug) {
+		return gen.Response(http.StatusForbidden, gen.GetFile400Response{
+			Message: http.StatusText(http.StatusForbidden),
+			Errors:  []string{fmt.Sprintf("token is not allowed to publish %s", moduleSlug)},
+		}), nil
+	}
+
+	release, err := backend.Traced(ctx).AddRelease(upload)
+	if errors.Is(err, backend.ErrReleaseExists) && config.AllowOverwrite && auth.Authorize(ctx, auth.ScopeAdmin, moduleSlug) {
+		release, err = backend.Traced(ctx).OverwriteRelease(upload)
+		if err == nil {
+			log.Log.Warnf("Release %s has been overwritten", release.Slug)
+			customMiddleware.InvalidateCache()
+		}
+	}
 	if err != nil {
+		if errors.Is(err, backend.ErrReleaseExists) {
+			return gen.Response(http.StatusConflict, gen.GetFile400Response{
+				Message: "Release already exists",
+				Errors:  []string{err.Error()},
+			}), nil
+		}
+		if errors.Is(err, backend.ErrReleaseDeleted) {
+			return gen.Response(http.StatusConflict, gen.GetFile400Response{
+				Message: "Failed to add release",
+				Errors:  []string{err.Error()},
+			}), nil
+		}
+		var validationErr *backend.ValidationError
+		if errors.As(err, &validationErr) {
+			return validationFailed(validationErr), nil
+		}
 		return gen.Response(400, gen.GetFile400Response{
 			Message: "Failed to add release",
 			Errors:  []string{err.Error()},
@@ -72,6 +127,24 @@ func (s *ReleaseOperationsApi) AddRelease(ctx context.Context, addReleaseRequest
 	}), nil
 }
 
+// rejectUnsafeArchive logs and counts the rejection of an unsafe upload
+func rejectUnsafeArchive(err error) gen.ImplResponse {
+	log.Log.Warnf("Rejected release upload: %v", err)
+	metrics.RejectedUploads.Inc()
+	return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+		Message: "Unsafe release archive",
+		Errors:  []string{err.Error()},
+	})
+}
+
+// validationFailed converts a violation of the validation policy into a response listing every violation
+func validationFailed(validationErr *backend.ValidationError) gen.ImplResponse {
+	return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+		Message: "Release validation failed",
+		Errors:  validationErr.Violations,
+	})
+}
+
 type DeleteRelease500Response struct {
 	Message string   `json:"message,omitempty"`
 	Errors  []string `json:"errors,omitempty"`
@@ -89,11 +162,78 @@ func (s *ReleaseOperationsApi) DeleteRelease(ctx context.Context, releaseSlug st
 			},
 		), nil
 	}
-	err := backend.ConfiguredBackend.DeleteReleaseBySlug(releaseSlug)
+
+	if release, err := backend.Traced(ctx).GetReleaseBySlug(releaseSlug); err == nil && !backend.ReleaseDeleted(release) {
+		response, err := checkDependents(ctx, release.Module.Slug, []string{releaseSlug})
+		if err != nil {
+			return gen.Response(
+				500,
+				DeleteRelease500Response{
+					Message: err.Error(),
+					Errors:  []string{err.Error()},
+				},
+			), nil
+		}
+		if response != nil {
+			return *response, nil
+		}
+	}
+
+	err := backend.Traced(ctx).DeleteReleaseBySlug(releaseSlug, reason)
 	if err == nil {
+		// Cached downloads of the release must not be served anymore
+		customMiddleware.InvalidateCache()
 		return gen.Response(204, nil), nil
 	}
 
+	if errors.Is(err, os.ErrNotExist) {
+		return gen.Response(http.StatusNotFound, GetRelease404Response{
+			Message: http.StatusText(http.StatusNotFound),
+			Errors:  []string{"release not found"},
+		}), nil
+	}
+
+	return gen.Response(
+		500,
+		DeleteRelease500Response{
+			Message: err.Error(),
+			Errors:  []string{err.Error()},
+		},
+	), nil
+}
+
+// RestoreRelease - Restore a deleted module release
+func (s *ReleaseOperationsApi) RestoreRelease(ctx context.Context, releaseSlug string) (gen.ImplResponse, error) {
+	if !utils.CheckReleaseSlug(releaseSlug) {
+		err := errors.New("invalid release slug")
+		return gen.Response(
+			400,
+			DeleteRelease500Response{
+				Message: err.Error(),
+				Errors:  []string{err.Error()},
+			},
+		), nil
+	}
+
+	release, err := backend.Traced(ctx).RestoreReleaseBySlug(releaseSlug)
+	if err == nil {
+		customMiddleware.InvalidateCache()
+		return gen.Response(http.StatusOK, release), nil
+	}
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return gen.Response(http.StatusNotFound, GetRelease404Response{
+			Message: http.StatusText(http.StatusNotFound),
+			Errors:  []string{"release not found"},
+		}), nil
+	case errors.Is(err, backend.ErrReleaseNotDeleted):
+		return gen.Response(http.StatusConflict, DeleteRelease500Response{
+			Message: err.Error(),
+			Errors:  []string{err.Error()},
+		}), nil
+	}
+
 	return gen.Response(
 		500,
 		DeleteRelease500Response{
@@ -138,11 +278,9 @@ func (s *ReleaseOperationsApi) GetFile(ctx context.Context, filename string) (ge
 		}), nil
 	}
 
-	filePath := filepath.Join(config.ModulesDir, ReleaseToModule(filename), filename)
-
-	f, err := os.Open(filePath)
+	f, err := backend.Traced(ctx).GetReleaseFile(releaseSlug)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, os.ErrNotExist) {
 			return gen.Response(http.StatusNotFound, gen.GetFile404Response{
 				Message: "File not found",
 				Errors:  []string{"the file does not exist"},
@@ -164,7 +302,7 @@ type GetRelease500Response struct {
 
 // GetRelease - Fetch module release
 func (s *ReleaseOperationsApi) GetRelease(ctx context.Context, releaseSlug string, withHtml bool, includeFields []string, excludeFields []string, ifModifiedSince string) (gen.ImplResponse, error) {
-	release, err := backend.ConfiguredBackend.GetReleaseBySlug(releaseSlug)
+	release, err := backend.Traced(ctx).GetReleaseBySlug(releaseSlug)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return gen.Response(http.StatusNotFound, gen.GetFile404Response{
@@ -178,11 +316,24 @@ func (s *ReleaseOperationsApi) GetRelease(ctx context.Context, releaseSlug strin
 		}), nil
 	}
 
-	return gen.Response(http.StatusOK, release), nil
+	result, err := selectFields(release, splitSpaceDelimited(includeFields), splitSpaceDelimited(excludeFields))
+	if err != nil {
+		return gen.Response(http.StatusInternalServerError, GetRelease500Response{
+			Message: http.StatusText(http.StatusInternalServerError),
+			Errors:  []string{err.Error()},
+		}), nil
+	}
+
+	return gen.Response(http.StatusOK, result), nil
 }
 
 func abbrReleaseToFullReleasePlan(abbrReleasePlan gen.ReleasePlanAbbreviated) gen.ReleasePlan {
-	planFile := fmt.Sprintf("plans/%s.pp", strings.Join(strings.Split(abbrReleasePlan.Name, "::")[1:], "/"))
+	planPath := strings.Join(strings.Split(abbrReleasePlan.Name, "::")[1:], "/")
+	if planPath == "" {
+		// The plan named like the module lives in init.pp
+		planPath = "init"
+	}
+	planFile := fmt.Sprintf("plans/%s.pp", planPath)
 	return gen.ReleasePlan{
 		Uri:      abbrReleasePlan.Uri,
 		Name:     abbrReleasePlan.Name,
@@ -198,7 +349,7 @@ func abbrReleaseToFullReleasePlan(abbrReleasePlan gen.ReleasePlanAbbreviated) ge
 
 // GetReleasePlan - Fetch module release plan
 func (s *ReleaseOperationsApi) GetReleasePlan(ctx context.Context, releaseSlug string, planName string) (gen.ImplResponse, error) {
-	release, err := backend.ConfiguredBackend.GetReleaseBySlug(releaseSlug)
+	release, err := backend.Traced(ctx).GetReleaseBySlug(releaseSlug)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return gen.Response(http.StatusNotFound, gen.GetFile404Response{
@@ -226,7 +377,7 @@ func (s *ReleaseOperationsApi) GetReleasePlan(ctx context.Context, releaseSlug s
 
 // GetReleasePlans - List module release plans
 func (s *ReleaseOperationsApi) GetReleasePlans(ctx context.Context, releaseSlug string) (gen.ImplResponse, error) {
-	release, err := backend.ConfiguredBackend.GetReleaseBySlug(releaseSlug)
+	release, err := backend.Traced(ctx).GetReleaseBySlug(releaseSlug)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return gen.Response(http.StatusNotFound, gen.GetFile404Response{
@@ -251,109 +402,86 @@ func (s *ReleaseOperationsApi) GetReleasePlans(ctx context.Context, releaseSlug
 	}), nil
 }
 
+type GetReleases200Response struct {
+	Pagination gen.GetReleases200ResponsePagination `json:"pagination,omitempty"`
+	Results    []interface{}                        `json:"results"`
+}
+
+// version sorts by semantic version and is the default
+var releaseSortValues = []string{"", "version", "downloads", "release_date", "module"}
+
 // GetReleases - List module releases
 func (s *ReleaseOperationsApi) GetReleases(ctx context.Context, limit int32, offset int32, sortBy string, module string, owner string, withPdk bool, operatingsystem string, operatingsystemrelease string, peRequirement string, puppetRequirement string, moduleGroups []string, showDeleted bool, hideDeprecated bool, withHtml bool, includeFields []string, excludeFields []string, ifModifiedSince string, supported bool) (gen.ImplResponse, error) {
 	if limit <= 0 {
-		limit = 20 // Default limit
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		limit = maxLimit
 	}
 	if offset < 0 {
-		offset = 0
+		offset = defaultOffset
 	}
-
-	results := []gen.Release{}
-	filtered := []*gen.Release{}
-	allReleases, _ := backend.ConfiguredBackend.GetAllReleases()
-
-	base, _ := url.Parse("/v3/releases")
-	params := url.Values{}
-
-	filterSet := false
-
-	if module != "" {
-		filterSet = true
-		params.Add("module", module)
+	moduleGroups = splitSpaceDelimited(moduleGroups)
+	includeFields = splitSpaceDelimited(includeFields)
+	excludeFields = splitSpaceDelimited(excludeFields)
+
+	releaseQuery := &backend.ReleaseQuery{
+		Limit:                  int(limit),
+		Offset:                 int(offset),
+		SortBy:                 sortBy,
+		Module:                 module,
+		Owner:                  owner,
+		ShowDeleted:            showDeleted,
+		WithPdk:                withPdk,
+		Operatingsystem:        operatingsystem,
+		OperatingsystemRelease: operatingsystemrelease,
+		HideDeprecated:         hideDeprecated,
+		Supported:              supported,
+		ModuleGroups:           moduleGroups,
 	}
 
-	if owner != "" {
-		filterSet = true
-		params.Add("owner", owner)
+	validationErrors := []string{}
+	if !slices.Contains(releaseSortValues, sortBy) {
+		validationErrors = append(validationErrors, fmt.Sprintf("invalid sort_by %q, must be one of version, downloads, release_date or module", sortBy))
 	}
-
-	params.Add("offset", strconv.Itoa(int(offset)))
-	params.Add("limit", strconv.Itoa(int(limit)))
-
-	base.RawQuery = params.Encode()
-	currentInf := interface{}(base.String())
-
-	// We know there's no releases and a fallback proxy, so we should return a 404 to let the proxy handle it
-	if config.FallbackProxyUrl != "" && len(allReleases) == 0 {
-		log.Log.Debugln("Could not find *any* releases in the backend, returning 404 so we can proxy if desired")
-
-		return gen.Response(http.StatusNotFound, GetRelease404Response{
-			Message: "No releases found",
-			Errors:  []string{"Did not retrieve any releases from the backend."},
-		}), nil
+	if operatingsystemrelease != "" && operatingsystem == "" {
+		validationErrors = append(validationErrors, "operatingsystemrelease requires operatingsystem")
 	}
-
-	if module != "" {
-		// Perform an early query to see if the module even exists in the backend, optimization for instances with _many_ modules
-		_, err := backend.ConfiguredBackend.GetModuleBySlug(module)
+	for _, group := range moduleGroups {
+		if group != backend.ModuleGroupBase && group != backend.ModuleGroupPeOnly {
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid module group %q, must be base or pe_only", group))
+		}
+	}
+	if peRequirement != "" {
+		r, err := utils.ParseVersionRange(peRequirement)
 		if err != nil {
-			log.Log.Debugf("Could not find module with slug '%s' in backend, returning 404 so we can proxy if desired\n", module)
-
-			if config.FallbackProxyUrl != "" {
-				return gen.Response(http.StatusNotFound, GetRelease404Response{
-					Message: "No releases found",
-					Errors:  []string{"No module(s) found for given query."},
-				}), nil
-			} else {
-				return gen.Response(http.StatusOK, gen.GetReleases200Response{
-					Pagination: gen.GetReleases200ResponsePagination{
-						Limit:    limit,
-						Offset:   offset,
-						First:    &currentInf,
-						Previous: nil,
-						Current:  &currentInf,
-						Next:     nil,
-						Total:    0,
-					},
-					Results: []gen.Release{},
-				}), nil
-			}
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid pe_requirement: %v", err))
 		}
+		releaseQuery.PeRequirement = r
 	}
-
-	if filterSet {
-		// We search through all available releases to see if they match the filter
-		for _, r := range allReleases {
-			if module != "" && r.Module.Slug != module {
-				continue
-			}
-
-			if owner != "" && r.Module.Owner.Slug != owner {
-				continue
-			}
-
-			filtered = append(filtered, r)
+	if puppetRequirement != "" {
+		r, err := utils.ParseVersionRange(puppetRequirement)
+		if err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid puppet_requirement: %v", err))
 		}
-	} else {
-		filtered = allReleases
+		releaseQuery.PuppetRequirement = r
+	}
+	if len(validationErrors) > 0 {
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: "Invalid query parameters",
+			Errors:  validationErrors,
+		}), nil
 	}
 
-	if len(filtered) > int(offset) {
-		i := 1
-		for _, release := range filtered[offset:] {
-			if i > int(limit) {
-				break
-			}
-
-			results = append(results, *release)
-			i++
-		}
+	releases, total, err := backend.Traced(ctx).QueryReleases(releaseQuery)
+	if err != nil {
+		return gen.Response(http.StatusInternalServerError, GetRelease500Response{
+			Message: "Failed to fetch releases",
+			Errors:  []string{err.Error()},
+		}), nil
 	}
 
 	// If we're using a fallback-proxy, we should return a 404 so the proxy can handle the request
-	if config.FallbackProxyUrl != "" && len(results) == 0 {
+	if config.FallbackProxyUrl != "" && len(releases) == 0 {
 		if module != "" {
 			log.Log.Debugf("No releases for '%s' found in backend\n", module)
 		} else {
@@ -366,34 +494,46 @@ func (s *ReleaseOperationsApi) GetReleases(ctx context.Context, limit int32, off
 		}), nil
 	}
 
-	base.RawQuery = params.Encode()
-	currentInf = interface{}(base.String())
-	params.Set("offset", "0")
-	firstInf := interface{}(base.String())
-
-	var nextInf interface{}
-	nextOffset := int(offset) + len(results)
-	if nextOffset < len(filtered) {
-		params.Set("offset", strconv.Itoa(nextOffset))
-		nextInf = interface{}(base.String())
-	}
-
-	var prevInf *string
-	prevOffset := int(offset) - int(limit)
-	if prevOffset >= 0 {
-		prevStr := base.String()
-		prevInf = &prevStr
+	results := make([]interface{}, 0, len(releases))
+	for _, release := range releases {
+		result, err := selectFields(release, includeFields, excludeFields)
+		if err != nil {
+			return gen.Response(http.StatusInternalServerError, GetRelease500Response{
+				Message: "Failed to fetch releases",
+				Errors:  []string{err.Error()},
+			}), nil
+		}
+		results = append(results, result)
 	}
 
-	return gen.Response(http.StatusOK, gen.GetReleases200Response{
+	// The links keep all filters of the current request
+	params := queryParams{}
+	params.set("sort_by", sortBy)
+	params.set("module", module)
+	params.set("owner", owner)
+	params.setBool("with_pdk", withPdk)
+	params.set("operatingsystem", operatingsystem)
+	params.set("operatingsystemrelease", operatingsystemrelease)
+	params.set("pe_requirement", peRequirement)
+	params.set("puppet_requirement", puppetRequirement)
+	params.set("module_groups", strings.Join(moduleGroups, ","))
+	params.setBool("show_deleted", showDeleted)
+	params.setBool("hide_deprecated", hideDeprecated)
+	params.setBool("with_html", withHtml)
+	params.set("include_fields", strings.Join(includeFields, ","))
+	params.set("exclude_fields", strings.Join(excludeFields, ","))
+	params.setBool("supported", supported)
+	links := newPaginationLinks("/v3/releases", params, limit, offset, len(results), total)
+
+	return gen.Response(http.StatusOK, GetReleases200Response{
 		Pagination: gen.GetReleases200ResponsePagination{
 			Limit:    limit,
 			Offset:   offset,
-			First:    &firstInf,
-			Previous: prevInf,
-			Current:  &currentInf,
-			Next:     &nextInf,
-			Total:    int32(len(filtered)),
+			First:    links.First,
+			Previous: links.Previous,
+			Current:  links.Current,
+			Next:     links.Next,
+			Total:    int32(total),
 		},
 		Results: results,
 	}), nil
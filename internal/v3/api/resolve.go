@@ -0,0 +1,158 @@
+package v3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dadav/gorge/internal/log"
+	customMiddleware "github.com/dadav/gorge/internal/middleware"
+	"github.com/dadav/gorge/internal/v3/resolver"
+	"github.com/dadav/gorge/internal/v3/utils"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+// maxResolveModules limits the number of modules in a single resolve request
+const maxResolveModules = 500
+
+// resolveController provides the endpoint resolving the dependencies of a set of modules
+type resolveController struct {
+	// upstreams are the fallback proxies, requests to unhealthy ones are skipped
+	upstreams []*customMiddleware.Upstream
+}
+
+func NewResolveController(upstreams []*customMiddleware.Upstream) gen.Router {
+	return &resolveController{upstreams: upstreams}
+}
+
+func (c *resolveController) Routes() gen.Routes {
+	return gen.Routes{
+		"Resolve": gen.Route{
+			Method:      http.MethodPost,
+			Pattern:     "/v3/resolve",
+			HandlerFunc: c.Resolve,
+		},
+	}
+}
+
+type ResolveRequest struct {
+	Modules []resolver.Requirement `json:"modules"`
+	// UseProxies also looks up modules unknown to this forge on the fallback proxies
+	UseProxies bool `json:"use_proxies,omitempty"`
+}
+
+type ResolvedRelease struct {
+	Slug       string   `json:"slug"`
+	Module     string   `json:"module"`
+	Version    string   `json:"version"`
+	FileUri    string   `json:"file_uri"`
+	Source     string   `json:"source"`
+	RequiredBy []string `json:"required_by"`
+}
+
+type Resolve200Response struct {
+	Releases []ResolvedRelease `json:"releases"`
+}
+
+type Resolve409Response struct {
+	Message   string              `json:"message,omitempty"`
+	Errors    []string            `json:"errors,omitempty"`
+	Conflicts []resolver.Conflict `json:"conflicts"`
+}
+
+// Resolve - Resolve the dependencies of the given modules to a consistent set of releases
+func (c *resolveController) Resolve(w http.ResponseWriter, r *http.Request) {
+	result := c.resolve(r)
+	gen.EncodeJSONResponse(result.Body, &result.Code, w)
+}
+
+func (c *resolveController) resolve(r *http.Request) gen.ImplResponse {
+	var request ResolveRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: "Cannot parse request body as JSON",
+			Errors:  []string{err.Error()},
+		})
+	}
+
+	validationErrors := []string{}
+	if len(request.Modules) == 0 {
+		validationErrors = append(validationErrors, "at least one module is required")
+	}
+	if len(request.Modules) > maxResolveModules {
+		validationErrors = append(validationErrors, fmt.Sprintf("at most %d modules can be resolved at once", maxResolveModules))
+	}
+	for _, module := range request.Modules {
+		if !utils.CheckModuleSlug(resolver.NormalizeName(module.Name)) {
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid module name %q", module.Name))
+			continue
+		}
+		if _, err := utils.ParseVersionRange(module.VersionRequirement); err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid version requirement of %s: %v", module.Name, err))
+		}
+	}
+	if len(validationErrors) > 0 {
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: "Invalid resolve request",
+			Errors:  validationErrors,
+		})
+	}
+
+	var source resolver.Source = resolver.LocalSource{}
+	if request.UseProxies && len(c.upstreams) > 0 {
+		source = resolver.FallbackSource{
+			resolver.LocalSource{},
+			resolver.NewProxySource(c.upstreams),
+		}
+	}
+
+	resolution, err := resolver.Resolve(r.Context(), source, request.Modules)
+	if err != nil {
+		var conflictErr *resolver.ConflictError
+		if errors.As(err, &conflictErr) {
+			reasons := []string{}
+			for _, conflict := range conflictErr.Conflicts {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", conflict.Module, conflict.Reason))
+			}
+			return gen.Response(http.StatusConflict, Resolve409Response{
+				Message:   "Dependencies could not be resolved",
+				Errors:    reasons,
+				Conflicts: conflictErr.Conflicts,
+			})
+		}
+
+		if errors.Is(err, resolver.ErrTooComplex) {
+			return gen.Response(http.StatusUnprocessableEntity, gen.GetFile400Response{
+				Message: "Dependencies could not be resolved",
+				Errors:  []string{err.Error()},
+			})
+		}
+
+		log.Log.Errorf("Failed to resolve dependencies: %v", err)
+		return gen.Response(http.StatusInternalServerError, GetModule500Response{
+			Message: "Failed to resolve dependencies",
+			Errors:  []string{err.Error()},
+		})
+	}
+
+	releases := make([]ResolvedRelease, 0, len(resolution.Releases))
+	for _, release := range resolution.Releases {
+		requiredBy := resolution.RequiredBy[release.Module]
+		if requiredBy == nil {
+			requiredBy = []string{}
+		}
+		releases = append(releases, ResolvedRelease{
+			Slug:       release.Slug,
+			Module:     release.Module,
+			Version:    release.Version,
+			FileUri:    release.FileUri,
+			Source:     release.Source,
+			RequiredBy: requiredBy,
+		})
+	}
+
+	return gen.Response(http.StatusOK, Resolve200Response{Releases: releases})
+}
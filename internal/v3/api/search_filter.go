@@ -5,73 +5,102 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/dadav/gorge/internal/auth"
 	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
 )
 
 type SearchFilterOperationsApi struct {
 	gen.SearchFilterOperationsAPIServicer
+	store *SearchFilterStore
 }
 
 func NewSearchFilterOperationsApi() *SearchFilterOperationsApi {
-	return &SearchFilterOperationsApi{}
+	return &SearchFilterOperationsApi{
+		store: NewSearchFilterStore(),
+	}
 }
 
-// AddSearchFilter - Create search filter
-func (s *SearchFilterOperationsApi) AddSearchFilter(ctx context.Context, searchFilterSlug string, withHtml bool, includeFields []string, excludeFields []string, ifModifiedSince string) (gen.ImplResponse, error) {
-	// TODO - update AddSearchFilter with the required logic for this service method.
-	// Add api_search_filter_operations_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
-
-	// TODO: Uncomment the next line to return response Response(200, SearchFilter{}) or use other options such as http.Ok ...
-	// return Response(200, SearchFilter{}), nil
-
-	// TODO: Uncomment the next line to return response Response(304, {}) or use other options such as http.Ok ...
-	// return Response(304, nil),nil
-
-	// TODO: Uncomment the next line to return response Response(400, GetFile400Response{}) or use other options such as http.Ok ...
-	// return Response(400, GetFile400Response{}), nil
-
-	// TODO: Uncomment the next line to return response Response(404, GetFile404Response{}) or use other options such as http.Ok ...
-	// return Response(404, GetFile404Response{}), nil
+// SearchFilterStore returns the store which holds the saved search filters
+func (s *SearchFilterOperationsApi) SearchFilterStore() *SearchFilterStore {
+	return s.store
+}
 
-	// TODO: Uncomment the next line to return response Response(409, AddSearchFilter409Response{}) or use other options such as http.Ok ...
-	// return Response(409, AddSearchFilter409Response{}), nil
+func unauthorizedSearchFilterResponse() gen.ImplResponse {
+	return gen.Response(http.StatusUnauthorized, gen.GetUserSearchFilters401Response{
+		Message: http.StatusText(http.StatusUnauthorized),
+		Errors:  []string{"search filters require an authenticated user"},
+	})
+}
 
-	return gen.Response(http.StatusNotImplemented, nil), errors.New("AddSearchFilter method not implemented")
+// AddSearchFilter - Create search filter
+func (s *SearchFilterOperationsApi) AddSearchFilter(ctx context.Context, searchFilterSlug string, withHtml bool, includeFields []string, excludeFields []string, ifModifiedSince string) (gen.ImplResponse, error) {
+	owner := auth.Subject(ctx)
+	if owner == "" {
+		return unauthorizedSearchFilterResponse(), nil
+	}
+
+	filter, err := s.store.Add(owner, searchFilterSlug)
+	if err != nil {
+		if errors.Is(err, ErrSearchFilterExists) {
+			return gen.Response(http.StatusConflict, gen.AddSearchFilter409Response{
+				Message: http.StatusText(http.StatusConflict),
+				Errors:  []string{err.Error()},
+			}), nil
+		}
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: "Failed to add search filter",
+			Errors:  []string{err.Error()},
+		}), nil
+	}
+
+	return gen.Response(http.StatusOK, filter), nil
 }
 
 // DeleteUserSearchFilter - Delete search filter by ID
 func (s *SearchFilterOperationsApi) DeleteUserSearchFilter(ctx context.Context, id int32) (gen.ImplResponse, error) {
-	// TODO - update DeleteUserSearchFilter with the required logic for this service method.
-	// Add api_search_filter_operations_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
-
-	// TODO: Uncomment the next line to return response Response(204, {}) or use other options such as http.Ok ...
-	// return Response(204, nil),nil
-
-	// TODO: Uncomment the next line to return response Response(403, DeleteUserSearchFilter403Response{}) or use other options such as http.Ok ...
-	// return Response(403, DeleteUserSearchFilter403Response{}), nil
-
-	// TODO: Uncomment the next line to return response Response(404, GetFile404Response{}) or use other options such as http.Ok ...
-	// return Response(404, GetFile404Response{}), nil
-
-	return gen.Response(http.StatusNotImplemented, nil), errors.New("DeleteUserSearchFilter method not implemented")
+	owner := auth.Subject(ctx)
+	if owner == "" {
+		return unauthorizedSearchFilterResponse(), nil
+	}
+
+	err := s.store.Delete(owner, id)
+	switch {
+	case err == nil:
+		return gen.Response(http.StatusNoContent, nil), nil
+	case errors.Is(err, ErrSearchFilterForbidden):
+		return gen.Response(http.StatusForbidden, gen.DeleteUserSearchFilter403Response{
+			Message: http.StatusText(http.StatusForbidden),
+			Errors:  []string{err.Error()},
+		}), nil
+	case errors.Is(err, ErrSearchFilterNotFound):
+		return gen.Response(http.StatusNotFound, gen.GetFile404Response{
+			Message: http.StatusText(http.StatusNotFound),
+			Errors:  []string{err.Error()},
+		}), nil
+	default:
+		return gen.Response(http.StatusInternalServerError, GetModule500Response{
+			Message: "Failed to delete search filter",
+			Errors:  []string{err.Error()},
+		}), nil
+	}
 }
 
-// GetUserSearchFilters - Get user&#39;s search filters
+// GetUserSearchFilters - Get user's search filters
 func (s *SearchFilterOperationsApi) GetUserSearchFilters(ctx context.Context) (gen.ImplResponse, error) {
-	// TODO - update GetUserSearchFilters with the required logic for this service method.
-	// Add api_search_filter_operations_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
-
-	// TODO: Uncomment the next line to return response Response(200, SearchFilterResponse{}) or use other options such as http.Ok ...
-	// return Response(200, SearchFilterResponse{}), nil
-
-	// TODO: Uncomment the next line to return response Response(400, GetFile400Response{}) or use other options such as http.Ok ...
-	// return Response(400, GetFile400Response{}), nil
-
-	// TODO: Uncomment the next line to return response Response(401, GetUserSearchFilters401Response{}) or use other options such as http.Ok ...
-	// return Response(401, GetUserSearchFilters401Response{}), nil
-
-	// TODO: Uncomment the next line to return response Response(404, GetFile404Response{}) or use other options such as http.Ok ...
-	// return Response(404, GetFile404Response{}), nil
-
-	return gen.Response(http.StatusNotImplemented, nil), errors.New("GetUserSearchFilters method not implemented")
+	owner := auth.Subject(ctx)
+	if owner == "" {
+		return unauthorizedSearchFilterResponse(), nil
+	}
+
+	filters, err := s.store.List(owner)
+	if err != nil {
+		return gen.Response(http.StatusInternalServerError, GetModule500Response{
+			Message: "Failed to fetch search filters",
+			Errors:  []string{err.Error()},
+		}), nil
+	}
+
+	return gen.Response(http.StatusOK, gen.SearchFilterResponse{
+		UserSearchFilters: filters,
+	}), nil
 }
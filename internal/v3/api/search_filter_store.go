@@ -0,0 +1,196 @@
+package v3
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dadav/gorge/internal/v3/backend"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+const searchFiltersFile = ".search_filters.json"
+
+var (
+	ErrSearchFilterNotFound  = errors.New("search filter not found")
+	ErrSearchFilterExists    = errors.New("search filter already exists")
+	ErrSearchFilterForbidden = errors.New("search filter belongs to another user")
+)
+
+// storedSearchFilter is a search filter together with the user it belongs to
+type storedSearchFilter struct {
+	gen.SearchFilter
+	Owner string `json:"owner"`
+	Query string `json:"query"`
+}
+
+type searchFilterData struct {
+	NextId  int32                `json:"next_id"`
+	Filters []storedSearchFilter `json:"filters"`
+}
+
+// SearchFilterStore persists the saved search filters of all users as a json document in the configured backend,
+// so all instances using the same storage share them
+type SearchFilterStore struct{}
+
+func NewSearchFilterStore() *SearchFilterStore {
+	return &SearchFilterStore{}
+}
+
+// parse decodes the stored filters, a missing document contains no filters
+func (s *SearchFilterStore) parse(content []byte) (*searchFilterData, error) {
+	data := &searchFilterData{NextId: 1, Filters: []storedSearchFilter{}}
+
+	if content == nil {
+		return data, nil
+	}
+
+	if err := json.Unmarshal(content, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *SearchFilterStore) load() (*searchFilterData, error) {
+	content, err := backend.ConfiguredBackend.ReadDocument(searchFiltersFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return s.parse(content)
+}
+
+// update changes the stored filters with fn, the changes are only saved if fn succeeds
+func (s *SearchFilterStore) update(fn func(data *searchFilterData) error) error {
+	return backend.ConfiguredBackend.UpdateDocument(searchFiltersFile, func(current []byte) ([]byte, error) {
+		data, err := s.parse(current)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(data); err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(data, "", "  ")
+	})
+}
+
+// ParseSearchFilterQuery converts a query like "owner=acme tag=database apache" into a filter
+// Terms without a key are joined into the keyword
+func ParseSearchFilterQuery(query string) map[string]interface{} {
+	filter := map[string]interface{}{}
+	keywords := []string{}
+
+	terms := strings.FieldsFunc(query, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+
+	for _, term := range terms {
+		if key, value, found := strings.Cut(term, "="); found && key != "" {
+			filter[strings.ToLower(key)] = value
+		} else {
+			keywords = append(keywords, term)
+		}
+	}
+
+	if len(keywords) > 0 {
+		filter["keyword"] = strings.Join(keywords, " ")
+	}
+
+	return filter
+}
+
+// Add saves a new search filter for owner, returns ErrSearchFilterExists if the owner already has an identical filter
+func (s *SearchFilterStore) Add(owner, query string) (*gen.SearchFilter, error) {
+	filter := ParseSearchFilterQuery(query)
+	if len(filter) == 0 {
+		return nil, errors.New("search filter is empty")
+	}
+
+	var stored storedSearchFilter
+	err := s.update(func(data *searchFilterData) error {
+		for _, existing := range data.Filters {
+			if existing.Owner == owner && reflect.DeepEqual(existing.Filter, filter) {
+				return ErrSearchFilterExists
+			}
+		}
+
+		stored = storedSearchFilter{
+			SearchFilter: gen.SearchFilter{
+				Id:        data.NextId,
+				Filter:    filter,
+				Active:    true,
+				CreatedAt: time.Now().UTC().Format(time.RFC3339),
+			},
+			Owner: owner,
+			Query: query,
+		}
+		data.NextId++
+		data.Filters = append(data.Filters, stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &stored.SearchFilter, nil
+}
+
+// List returns the search filters of owner
+func (s *SearchFilterStore) List(owner string) ([]gen.SearchFilter, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []gen.SearchFilter{}
+	for _, filter := range data.Filters {
+		if filter.Owner == owner {
+			result = append(result, filter.SearchFilter)
+		}
+	}
+
+	return result, nil
+}
+
+// Queries returns the original queries of the active search filters of owner
+func (s *SearchFilterStore) Queries(owner string) ([]string, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, filter := range data.Filters {
+		if filter.Active && filter.Owner == owner {
+			result = append(result, filter.Query)
+		}
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// Delete removes the search filter with the given id
+// Returns ErrSearchFilterForbidden if the filter belongs to another user
+func (s *SearchFilterStore) Delete(owner string, id int32) error {
+	return s.update(func(data *searchFilterData) error {
+		for i, filter := range data.Filters {
+			if filter.Id != id {
+				continue
+			}
+
+			if filter.Owner != owner {
+				return ErrSearchFilterForbidden
+			}
+
+			data.Filters = append(data.Filters[:i], data.Filters[i+1:]...)
+			return nil
+		}
+
+		return ErrSearchFilterNotFound
+	})
+}
@@ -0,0 +1,208 @@
+package v3
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/dadav/gorge/internal/config"
+	"github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/metrics"
+	"github.com/dadav/gorge/internal/v3/backend"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	// uploadField is the form field containing the tarball, as used by the forge and pdk release
+	uploadField = "file"
+	// multipartOverhead is the space allowed for the boundaries and the other fields of a multipart upload
+	multipartOverhead = 1024 * 1024
+)
+
+var (
+	errUploadTooLarge = errors.New("upload too large")
+	errUploadEmpty    = errors.New("upload is empty")
+)
+
+// maxUploadSize returns the maximum size of an uploaded tarball in bytes, 0 means unlimited
+func maxUploadSize() int64 {
+	return config.MaxUploadSize * 1024 * 1024
+}
+
+func uploadTooLarge(maxSize int64) gen.ImplResponse {
+	return gen.Response(http.StatusRequestEntityTooLarge, gen.GetFile400Response{
+		Message: "Upload too large",
+		Errors:  []string{fmt.Sprintf("the tarball must not be larger than %d bytes", maxSize)},
+	})
+}
+
+// isStreamedUpload reports whether the request contains the tarball as multipart form or raw body
+// Requests with other content types are handled by the generated json handler
+func isStreamedUpload(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data", "application/gzip", "application/x-gzip", "application/octet-stream":
+		return true
+	}
+	return false
+}
+
+// withStreamedUploads accepts multipart forms and raw gzip bodies besides the json body with the base64 encoded tarball
+func (c *releaseOperationsController) withStreamedUploads(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isStreamedUpload(r) {
+			limitJsonUpload(w, r)
+			next(w, r)
+			return
+		}
+
+		result := c.uploadRelease(w, r)
+		gen.EncodeJSONResponse(result.Body, &result.Code, w)
+	}
+}
+
+// countUploadFailures counts the uploads which haven't been published by the status code of the response
+func countUploadFailures(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next(ww, r)
+		if status := ww.Status(); status >= http.StatusBadRequest {
+			metrics.UploadFailures.WithLabelValues(strconv.Itoa(status)).Inc()
+		}
+	}
+}
+
+func (c *releaseOperationsController) uploadRelease(w http.ResponseWriter, r *http.Request) gen.ImplResponse {
+	maxSize := maxUploadSize()
+	if maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+	}
+
+	var body io.Reader = r.Body
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		part, err := uploadPart(r)
+		if err != nil {
+			return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+				Message: "Invalid multipart upload",
+				Errors:  []string{err.Error()},
+			})
+		}
+		defer part.Close()
+		body = part
+	}
+
+	upload, err := receiveUpload(body, maxSize)
+	if err != nil {
+		return uploadFailed(err, maxSize)
+	}
+	defer upload.Close()
+
+	result, _ := c.service.addRelease(r.Context(), upload)
+	return result
+}
+
+// uploadPart returns the part of the multipart form containing the tarball
+// The form is read as a stream, so the tarball is never held in memory completely
+func uploadPart(r *http.Request) (io.ReadCloser, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("the form field %q is missing", uploadField)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == uploadField {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// receiveUpload stores the tarball in a temporary file and calculates its checksums on the way
+// errUploadTooLarge is returned as soon as more than maxSize bytes are read
+func receiveUpload(body io.Reader, maxSize int64) (*backend.Upload, error) {
+	if maxSize > 0 {
+		body = io.LimitReader(body, maxSize+1)
+	}
+
+	upload, err := backend.NewUpload(body)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && upload.Size > maxSize {
+		upload.Close()
+		return nil, errUploadTooLarge
+	}
+	if upload.Size == 0 {
+		upload.Close()
+		return nil, errUploadEmpty
+	}
+	log.Log.Debugf("Received upload of %d bytes with sha256 %s", upload.Size, upload.Sha256)
+
+	return upload, nil
+}
+
+// uploadFailed converts the error of receiveUpload into a response
+func uploadFailed(err error, maxSize int64) gen.ImplResponse {
+	var maxBytesErr *http.MaxBytesError
+	var pathErr *fs.PathError
+	var base64Err base64.CorruptInputError
+	switch {
+	case errors.Is(err, errUploadTooLarge), errors.As(err, &maxBytesErr):
+		return uploadTooLarge(maxSize)
+	case errors.Is(err, errUploadEmpty):
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: "No file data provided",
+			Errors:  []string{"file data is required"},
+		})
+	case errors.As(err, &base64Err):
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: "Invalid base64 encoded data",
+			Errors:  []string{err.Error()},
+		})
+	case errors.As(err, &pathErr):
+		// The temporary file couldn't be written
+		log.Log.Errorf("Failed to store upload: %v", err)
+		return gen.Response(http.StatusInternalServerError, GetModule500Response{
+			Message: "Failed to store upload",
+			Errors:  []string{err.Error()},
+		})
+	default:
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: "Failed to read upload",
+			Errors:  []string{err.Error()},
+		})
+	}
+}
+
+// limitJsonUpload limits the body of json uploads, which contain the tarball base64 encoded
+// The generated handler decodes the whole body into memory, so the limit has to be enforced while it's read
+func limitJsonUpload(w http.ResponseWriter, r *http.Request) {
+	if maxSize := maxUploadSize(); maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(base64.StdEncoding.EncodedLen(int(maxSize)))+multipartOverhead)
+	}
+}
+
+// uploadErrorHandler answers json uploads exceeding the size limit with 413 instead of a parsing error
+func uploadErrorHandler(w http.ResponseWriter, r *http.Request, err error, result *gen.ImplResponse) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		tooLarge := uploadTooLarge(maxUploadSize())
+		gen.EncodeJSONResponse(tooLarge.Body, &tooLarge.Code, w)
+		return
+	}
+	gen.DefaultErrorHandler(w, r, err, result)
+}
@@ -3,8 +3,13 @@ package v3
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"sort"
+	"strings"
 
+	"github.com/dadav/gorge/internal/v3/backend"
 	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
 )
 
@@ -16,36 +21,201 @@ func NewUserOperationsApi() *UserOperationsApi {
 	return &UserOperationsApi{}
 }
 
-// GetUser - Fetch user
-func (s *UserOperationsApi) GetUser(ctx context.Context, userSlug string, withHtml bool, includeFields []string, excludeFields []string, ifModifiedSince string) (gen.ImplResponse, error) {
-	// TODO - update GetUser with the required logic for this service method.
-	// Add api_user_operations_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+// validUserSlug reports whether slug can be the slug of a user
+// The slugs are the authors of the modules (e.g. acme-corp), the listing links them as a single path segment
+func validUserSlug(slug string) bool {
+	return slug != "" && !strings.Contains(slug, "/")
+}
+
+// User extends the generated user with the total downloads of all its modules
+type User struct {
+	gen.User
+	Downloads int32 `json:"downloads"`
+}
+
+type GetUsers200Response struct {
+	Pagination gen.GetUsers200ResponsePagination `json:"pagination,omitempty"`
+	Results    []interface{}                     `json:"results"`
+}
+
+// username sorts by the name of the user and is the default
+var userSortValues = []string{"", "username", "releases", "downloads", "latest_release"}
+
+// collectUsers builds the users from the owners of all modules in the backend
+func collectUsers(ctx context.Context) ([]*User, error) {
+	modules, err := backend.Traced(ctx).GetAllModules()
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string]*User{}
+	for _, module := range modules {
+		if backend.ModuleDeleted(module) {
+			continue
+		}
+
+		owner := module.Owner
+		user, ok := users[owner.Slug]
+		if !ok {
+			user = &User{
+				User: gen.User{
+					Uri:         fmt.Sprintf("/v3/users/%s", owner.Slug),
+					Slug:        owner.Slug,
+					GravatarId:  owner.GravatarId,
+					Username:    owner.Username,
+					DisplayName: owner.Username,
+					CreatedAt:   module.CreatedAt,
+					UpdatedAt:   module.UpdatedAt,
+				},
+			}
+			users[owner.Slug] = user
+		}
 
-	// TODO: Uncomment the next line to return response Response(200, User{}) or use other options such as http.Ok ...
-	// return gen.Response(200, User{}), nil
+		user.ModuleCount++
+		for _, release := range module.Releases {
+			if release.DeletedAt == nil {
+				user.ReleaseCount++
+			}
+		}
+		user.Downloads += module.Downloads
 
-	// TODO: Uncomment the next line to return response Response(304, {}) or use other options such as http.Ok ...
-	// return gen.Response(304, nil),nil
+		if module.CreatedAt != "" && (user.CreatedAt == "" || module.CreatedAt < user.CreatedAt) {
+			user.CreatedAt = module.CreatedAt
+		}
+		if module.UpdatedAt > user.UpdatedAt {
+			user.UpdatedAt = module.UpdatedAt
+		}
+	}
 
-	// TODO: Uncomment the next line to return response Response(400, GetFile400Response{}) or use other options such as http.Ok ...
-	// return gen.Response(400, GetFile400Response{}), nil
+	result := make([]*User, 0, len(users))
+	for _, user := range users {
+		result = append(result, user)
+	}
 
-	// TODO: Uncomment the next line to return response Response(404, GetFile404Response{}) or use other options such as http.Ok ...
-	// return gen.Response(404, GetFile404Response{}), nil
+	return result, nil
+}
 
-	return gen.Response(http.StatusNotImplemented, nil), errors.New("GetUser method not implemented")
+// sortUsers sorts the users by the given sort_by value, defaults to username
+func sortUsers(users []*User, sortBy string) {
+	sort.SliceStable(users, func(i, j int) bool {
+		switch sortBy {
+		case "releases":
+			if users[i].ReleaseCount != users[j].ReleaseCount {
+				return users[i].ReleaseCount > users[j].ReleaseCount
+			}
+		case "downloads":
+			if users[i].Downloads != users[j].Downloads {
+				return users[i].Downloads > users[j].Downloads
+			}
+		case "latest_release":
+			if users[i].UpdatedAt != users[j].UpdatedAt {
+				return users[i].UpdatedAt > users[j].UpdatedAt
+			}
+		}
+		return users[i].Username < users[j].Username
+	})
+}
+
+// GetUser - Fetch user
+func (s *UserOperationsApi) GetUser(ctx context.Context, userSlug string, withHtml bool, includeFields []string, excludeFields []string, ifModifiedSince string) (gen.ImplResponse, error) {
+	if !validUserSlug(userSlug) {
+		err := errors.New("invalid user slug")
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: err.Error(),
+			Errors:  []string{err.Error()},
+		}), nil
+	}
+
+	users, err := collectUsers(ctx)
+	if err != nil {
+		return gen.Response(http.StatusInternalServerError, GetModule500Response{
+			Message: "Failed to fetch users",
+			Errors:  []string{err.Error()},
+		}), nil
+	}
+
+	for _, user := range users {
+		if user.Slug == userSlug {
+			result, err := selectFields(user, splitSpaceDelimited(includeFields), splitSpaceDelimited(excludeFields))
+			if err != nil {
+				return gen.Response(http.StatusInternalServerError, GetModule500Response{
+					Message: "Failed to fetch user",
+					Errors:  []string{err.Error()},
+				}), nil
+			}
+			return gen.Response(http.StatusOK, result), nil
+		}
+	}
+
+	return gen.Response(http.StatusNotFound, gen.GetFile404Response{
+		Message: http.StatusText(http.StatusNotFound),
+		Errors:  []string{"User could not be found"},
+	}), nil
 }
 
 // GetUsers - List users
 func (s *UserOperationsApi) GetUsers(ctx context.Context, limit int32, offset int32, sortBy string, withHtml bool, includeFields []string, excludeFields []string, ifModifiedSince string) (gen.ImplResponse, error) {
-	// TODO - update GetUsers with the required logic for this service method.
-	// Add api_user_operations_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		limit = maxLimit
+	}
+	if offset < 0 {
+		offset = defaultOffset
+	}
+	includeFields = splitSpaceDelimited(includeFields)
+	excludeFields = splitSpaceDelimited(excludeFields)
+
+	if !slices.Contains(userSortValues, sortBy) {
+		return gen.Response(http.StatusBadRequest, gen.GetFile400Response{
+			Message: "Invalid query parameters",
+			Errors:  []string{fmt.Sprintf("invalid sort_by %q, must be one of username, releases, downloads or latest_release", sortBy)},
+		}), nil
+	}
+
+	users, err := collectUsers(ctx)
+	if err != nil {
+		return gen.Response(http.StatusInternalServerError, GetModule500Response{
+			Message: "Failed to fetch users",
+			Errors:  []string{err.Error()},
+		}), nil
+	}
+
+	sortUsers(users, sortBy)
 
-	// TODO: Uncomment the next line to return response Response(200, GetUsers200Response{}) or use other options such as http.Ok ...
-	// return gen.Response(200, GetUsers200Response{}), nil
+	results := []interface{}{}
+	if int(offset) < len(users) {
+		end := min(int(offset)+int(limit), len(users))
+		for _, user := range users[offset:end] {
+			result, err := selectFields(user, includeFields, excludeFields)
+			if err != nil {
+				return gen.Response(http.StatusInternalServerError, GetModule500Response{
+					Message: "Failed to fetch users",
+					Errors:  []string{err.Error()},
+				}), nil
+			}
+			results = append(results, result)
+		}
+	}
 
-	// TODO: Uncomment the next line to return response Response(304, {}) or use other options such as http.Ok ...
-	// return gen.Response(304, nil),nil
+	// The links keep all parameters of the current request
+	params := queryParams{}
+	params.set("sort_by", sortBy)
+	params.setBool("with_html", withHtml)
+	params.set("include_fields", strings.Join(includeFields, ","))
+	params.set("exclude_fields", strings.Join(excludeFields, ","))
+	links := newPaginationLinks("/v3/users", params, limit, offset, len(results), len(users))
 
-	return gen.Response(http.StatusNotImplemented, nil), errors.New("GetUsers method not implemented")
+	return gen.Response(http.StatusOK, GetUsers200Response{
+		Pagination: gen.GetUsers200ResponsePagination{
+			Limit:    limit,
+			Offset:   offset,
+			First:    links.First,
+			Previous: links.Previous,
+			Current:  links.Current,
+			Next:     links.Next,
+			Total:    int32(len(users)),
+		},
+		Results: results,
+	}), nil
 }
@@ -0,0 +1,400 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/dadav/gorge/internal/model"
+	"github.com/dadav/gorge/internal/v3/utils"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+const (
+	changelogFile = "CHANGELOG.md"
+	referenceFile = "REFERENCE.md"
+	stringsFile   = "REFERENCE.json"
+	tasksDir      = "tasks"
+	plansDir      = "plans"
+)
+
+// ReleaseArchive contains everything gorge reads from a release tarball
+type ReleaseArchive struct {
+	Metadata  *model.ReleaseMetadata
+	Readme    string
+	Changelog string
+	Reference string
+	Docs      map[string]interface{}
+	Tasks     []gen.ReleaseTask
+	Plans     []gen.ReleasePlanAbbreviated
+	Pdk       bool
+	// topLevelDirs contains the first path component of every entry, "" for files in the root
+	topLevelDirs map[string]struct{}
+}
+
+// ArchiveLimits protects against archives which would exhaust the resources of the server (e.g. gzip bombs)
+// A limit of 0 disables the check
+type ArchiveLimits struct {
+	// MaxUncompressedSize is the maximum sum of the sizes of all entries in bytes
+	MaxUncompressedSize int64
+	// MaxEntries is the maximum number of entries
+	MaxEntries int
+	// MaxPathDepth is the maximum number of path components of an entry, including the top level directory
+	MaxPathDepth int
+}
+
+// ErrUnsafeArchive is returned if the archive violates the archive limits or contains unsafe entries
+var ErrUnsafeArchive = errors.New("unsafe archive")
+
+// ConfiguredArchiveLimits are enforced whenever a release archive is read
+var ConfiguredArchiveLimits = ArchiveLimits{
+	MaxUncompressedSize: 512 * 1024 * 1024,
+	MaxEntries:          10000,
+	MaxPathDepth:        20,
+}
+
+// taskExtensions are the file extensions puppet recognises as task executables
+var taskExtensions = map[string]bool{
+	".sh":  true,
+	".ps1": true,
+	".rb":  true,
+	".py":  true,
+	".pl":  true,
+	".pp":  true,
+	".bat": true,
+	".cmd": true,
+	".exe": true,
+}
+
+// taskFiles collects the files belonging to a single task
+type taskFiles struct {
+	metadata    map[string]interface{}
+	executables []string
+}
+
+// plan is a plan file found in the archive
+type plan struct {
+	file    string
+	private bool
+}
+
+// applyTo copies the archive contents into the release
+func (a *ReleaseArchive) applyTo(release *gen.Release) {
+	release.Readme = a.Readme
+	release.Changelog = a.Changelog
+	release.Reference = a.Reference
+	release.Docs = a.Docs
+	release.Tasks = a.Tasks
+	release.Plans = make([]gen.ReleasePlanAbbreviated, 0, len(a.Plans))
+	for _, p := range a.Plans {
+		p.Uri = fmt.Sprintf("%s/plans/%s", release.Uri, p.Name)
+		release.Plans = append(release.Plans, p)
+	}
+	release.Pdk = a.Pdk
+}
+
+// archivePath strips the top level directory (e.g. acme-foo-1.0.0/) from the path of a tar entry
+func archivePath(name string) string {
+	name = strings.TrimPrefix(path.Clean(name), "./")
+	if _, rest, found := strings.Cut(name, "/"); found {
+		return rest
+	}
+	return name
+}
+
+// checkEntry rejects entries which could harm anyone extracting the archive and records the top level directory
+func (a *ReleaseArchive) checkEntry(header *tar.Header) error {
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeDir:
+	case tar.TypeSymlink, tar.TypeLink:
+		return fmt.Errorf("%w: %s is a link", ErrUnsafeArchive, header.Name)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return fmt.Errorf("%w: %s is a device file", ErrUnsafeArchive, header.Name)
+	default:
+		return fmt.Errorf("%w: %s has the unsupported entry type %q", ErrUnsafeArchive, header.Name, header.Typeflag)
+	}
+
+	name := strings.TrimPrefix(header.Name, "./")
+	if path.IsAbs(name) {
+		return fmt.Errorf("%w: %s is an absolute path", ErrUnsafeArchive, header.Name)
+	}
+	if strings.Contains(name, "\\") {
+		return fmt.Errorf("%w: %s contains a backslash", ErrUnsafeArchive, header.Name)
+	}
+	if slices.Contains(strings.Split(name, "/"), "..") {
+		return fmt.Errorf("%w: %s leaves the archive", ErrUnsafeArchive, header.Name)
+	}
+
+	cleaned := path.Clean(name)
+
+	// Entries like ./ don't belong to any directory
+	if cleaned == "." {
+		return nil
+	}
+
+	components := strings.Split(cleaned, "/")
+	if ConfiguredArchiveLimits.MaxPathDepth > 0 && len(components) > ConfiguredArchiveLimits.MaxPathDepth {
+		return fmt.Errorf("%w: %s exceeds the maximum path depth of %d", ErrUnsafeArchive, header.Name, ConfiguredArchiveLimits.MaxPathDepth)
+	}
+
+	topLevelDir := components[0]
+	if len(components) == 1 && header.Typeflag != tar.TypeDir {
+		topLevelDir = ""
+	}
+	a.topLevelDirs[topLevelDir] = struct{}{}
+
+	return nil
+}
+
+// moduleShortName returns the name of the module without the namespace (acme-foo becomes foo)
+func moduleShortName(moduleSlug string) string {
+	if idx := strings.IndexAny(moduleSlug, "-/"); idx >= 0 {
+		return moduleSlug[idx+1:]
+	}
+	return moduleSlug
+}
+
+// ReadReleaseArchiveFromBytes extracts metadata and documentation from a gzipped tar archive
+// Parameters:
+//   - data: byte slice containing the gzipped tar archive
+//
+// Returns:
+//   - *ReleaseArchive: parsed metadata.json, README.md, CHANGELOG.md, REFERENCE.md, tasks and plans
+//   - error: any errors encountered during processing
+func ReadReleaseArchiveFromBytes(data []byte) (*ReleaseArchive, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty data provided")
+	}
+
+	return ReadReleaseArchive(bytes.NewReader(data))
+}
+
+// ReadReleaseArchive extracts metadata and documentation from a gzipped tar archive read from r
+func ReadReleaseArchive(r io.Reader) (*ReleaseArchive, error) {
+	archive := &ReleaseArchive{
+		topLevelDirs: map[string]struct{}{},
+	}
+	tasks := map[string]*taskFiles{}
+	plans := []plan{}
+	var rawMetadata map[string]interface{}
+
+	// Create readers to process the gzipped tar data
+	g, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %v", err)
+	}
+	defer g.Close()
+
+	tarReader := tar.NewReader(g)
+	var entries int
+	var uncompressedSize int64
+
+	// Iterate through all files in the archive
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// The global pax header written by e.g. git archive is not part of the module
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		entries++
+		if ConfiguredArchiveLimits.MaxEntries > 0 && entries > ConfiguredArchiveLimits.MaxEntries {
+			return nil, fmt.Errorf("%w: more than %d entries", ErrUnsafeArchive, ConfiguredArchiveLimits.MaxEntries)
+		}
+		// The tar reader never returns more data than announced in the header
+		uncompressedSize += header.Size
+		if ConfiguredArchiveLimits.MaxUncompressedSize > 0 && uncompressedSize > ConfiguredArchiveLimits.MaxUncompressedSize {
+			return nil, fmt.Errorf("%w: uncompressed size exceeds %d bytes", ErrUnsafeArchive, ConfiguredArchiveLimits.MaxUncompressedSize)
+		}
+		if err := archive.checkEntry(header); err != nil {
+			return nil, err
+		}
+
+		// Skip if not a regular file
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := archivePath(header.Name)
+		dir, file := path.Split(name)
+
+		switch {
+		case name == metadataFile:
+			// Read and parse the metadata file
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+
+			var releaseMetadata model.ReleaseMetadata
+			if err := json.Unmarshal(content, &releaseMetadata); err != nil {
+				return nil, err
+			}
+
+			// Validate the module name
+			if !utils.CheckModuleSlug(releaseMetadata.Name) {
+				return nil, errors.New("invalid module name")
+			}
+
+			if err := json.Unmarshal(content, &rawMetadata); err != nil {
+				return nil, err
+			}
+			archive.Metadata = &releaseMetadata
+		case name == readmeFile:
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			archive.Readme = string(content)
+		case name == changelogFile:
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			archive.Changelog = string(content)
+		case name == referenceFile:
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			archive.Reference = string(content)
+		case name == stringsFile:
+			// Output of `puppet strings generate --format json`
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(content, &archive.Docs); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", stringsFile, err)
+			}
+		case dir == tasksDir+"/" && (path.Ext(file) == ".json" || taskExtensions[path.Ext(file)]):
+			ext := path.Ext(file)
+			taskName := strings.TrimSuffix(file, ext)
+			task, ok := tasks[taskName]
+			if !ok {
+				task = &taskFiles{}
+				tasks[taskName] = task
+			}
+
+			if ext == ".json" {
+				content, err := io.ReadAll(tarReader)
+				if err != nil {
+					return nil, err
+				}
+				if err := json.Unmarshal(content, &task.metadata); err != nil {
+					return nil, fmt.Errorf("failed to parse task metadata %s: %w", name, err)
+				}
+			} else {
+				task.executables = append(task.executables, file)
+			}
+		case strings.HasPrefix(name, plansDir+"/") && path.Ext(name) == ".pp":
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			plans = append(plans, plan{
+				file:    name,
+				private: bytes.Contains(content, []byte("@api private")),
+			})
+		default:
+			continue
+		}
+	}
+
+	if archive.Metadata == nil {
+		return nil, fmt.Errorf("no %s found in archive", metadataFile)
+	}
+
+	// Modules created by the pdk record the used pdk version in their metadata
+	_, archive.Pdk = rawMetadata["pdk-version"]
+
+	archive.Tasks = buildTasks(tasks)
+	archive.Plans = buildPlans(moduleShortName(archive.Metadata.Name), plans)
+
+	return archive, nil
+}
+
+// buildTasks converts the collected task files into release tasks sorted by name
+// Only tasks with at least one executable are returned
+func buildTasks(tasks map[string]*taskFiles) []gen.ReleaseTask {
+	result := []gen.ReleaseTask{}
+
+	for name, files := range tasks {
+		// Tasks can reference the executables of other tasks via implementations
+		if len(files.executables) == 0 {
+			if implementations, ok := files.metadata["implementations"].([]interface{}); ok {
+				for _, impl := range implementations {
+					if implMap, ok := impl.(map[string]interface{}); ok {
+						if implName, ok := implMap["name"].(string); ok {
+							files.executables = append(files.executables, implName)
+						}
+					}
+				}
+			}
+		}
+		if len(files.executables) == 0 {
+			continue
+		}
+		sort.Strings(files.executables)
+
+		task := gen.ReleaseTask{
+			Name:        name,
+			Executable:  files.executables[0],
+			Executables: files.executables,
+			Metadata:    files.metadata,
+		}
+		if description, ok := files.metadata["description"].(string); ok {
+			task.Description = description
+		}
+
+		result = append(result, task)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// buildPlans converts plan files into abbreviated plans sorted by name
+// plans/init.pp becomes <module> and plans/foo/bar.pp becomes <module>::foo::bar
+func buildPlans(moduleName string, plans []plan) []gen.ReleasePlanAbbreviated {
+	result := []gen.ReleasePlanAbbreviated{}
+
+	for _, p := range plans {
+		relPath := strings.TrimSuffix(strings.TrimPrefix(p.file, plansDir+"/"), ".pp")
+
+		name := moduleName
+		if relPath != "init" {
+			name = fmt.Sprintf("%s::%s", moduleName, strings.ReplaceAll(relPath, "/", "::"))
+		}
+
+		result = append(result, gen.ReleasePlanAbbreviated{
+			Name:    name,
+			Private: p.private,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testMetadata = `{"name":"acme-foo","version":"1.0.0","author":"acme","license":"MIT","summary":"foo","source":"https://example.com","dependencies":[]}`
+
+// buildArchive creates a gzipped tarball containing metadata.json and the given extra entries
+func buildArchive(t *testing.T, extra ...*tar.Header) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	write := func(header *tar.Header, content []byte) {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("failed to write header %s: %v", header.Name, err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatalf("failed to write %s: %v", header.Name, err)
+		}
+	}
+
+	write(&tar.Header{Name: "acme-foo-1.0.0/", Typeflag: tar.TypeDir, Mode: 0o755}, nil)
+	write(&tar.Header{Name: "acme-foo-1.0.0/metadata.json", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(testMetadata))}, []byte(testMetadata))
+	for _, header := range extra {
+		var content []byte
+		if header.Typeflag == tar.TypeReg {
+			content = bytes.Repeat([]byte("a"), int(header.Size))
+		}
+		if header.Mode == 0 {
+			header.Mode = 0o644
+		}
+		write(header, content)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// files returns count regular files below the top level directory
+func files(count int) []*tar.Header {
+	headers := make([]*tar.Header, 0, count)
+	for i := range count {
+		headers = append(headers, &tar.Header{Name: fmt.Sprintf("acme-foo-1.0.0/files/%d.txt", i), Typeflag: tar.TypeReg})
+	}
+	return headers
+}
+
+func TestReadReleaseArchiveFromBytesLimits(t *testing.T) {
+	limits := ArchiveLimits{
+		MaxUncompressedSize: 1024,
+		MaxEntries:          10,
+		MaxPathDepth:        4,
+	}
+
+	tests := []struct {
+		name    string
+		limits  ArchiveLimits
+		entries []*tar.Header
+		wantErr bool
+	}{
+		{name: "valid archive", limits: limits},
+		{name: "entries at the limit", limits: limits, entries: files(8)},
+		{name: "too many entries", limits: limits, entries: files(9), wantErr: true},
+		{name: "unlimited entries", limits: ArchiveLimits{}, entries: files(20)},
+		{name: "size at the limit", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/big.txt", Typeflag: tar.TypeReg, Size: 1024 - int64(len(testMetadata))},
+		}},
+		{name: "size exceeded", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/big.txt", Typeflag: tar.TypeReg, Size: 1025 - int64(len(testMetadata))},
+		}, wantErr: true},
+		{name: "size exceeded by several entries", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/a.txt", Typeflag: tar.TypeReg, Size: 512},
+			{Name: "acme-foo-1.0.0/b.txt", Typeflag: tar.TypeReg, Size: 512},
+		}, wantErr: true},
+		{name: "depth at the limit", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/a/b/c.txt", Typeflag: tar.TypeReg},
+		}},
+		{name: "depth exceeded", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/a/b/c/d.txt", Typeflag: tar.TypeReg},
+		}, wantErr: true},
+		{name: "depth exceeded by a directory", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/a/b/c/d/", Typeflag: tar.TypeDir, Mode: 0o755},
+		}, wantErr: true},
+		{name: "symlink", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		}, wantErr: true},
+		{name: "hardlink", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/link", Typeflag: tar.TypeLink, Linkname: "acme-foo-1.0.0/metadata.json"},
+		}, wantErr: true},
+		{name: "character device", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+		}, wantErr: true},
+		{name: "block device", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/sda", Typeflag: tar.TypeBlock, Devmajor: 8},
+		}, wantErr: true},
+		{name: "fifo", limits: limits, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/fifo", Typeflag: tar.TypeFifo},
+		}, wantErr: true},
+		{name: "links are rejected without limits", limits: ArchiveLimits{}, entries: []*tar.Header{
+			{Name: "acme-foo-1.0.0/link", Typeflag: tar.TypeSymlink, Linkname: "metadata.json"},
+		}, wantErr: true},
+	}
+
+	defaultLimits := ConfiguredArchiveLimits
+	t.Cleanup(func() { ConfiguredArchiveLimits = defaultLimits })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ConfiguredArchiveLimits = tt.limits
+
+			archive, err := ReadReleaseArchiveFromBytes(buildArchive(t, tt.entries...))
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsafeArchive) {
+					t.Fatalf("expected ErrUnsafeArchive, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if archive.Metadata == nil || archive.Metadata.Name != "acme-foo" {
+				t.Errorf("unexpected metadata %+v", archive.Metadata)
+			}
+		})
+	}
+}
+
+func TestReadReleaseArchiveFromBytesEmpty(t *testing.T) {
+	if _, err := ReadReleaseArchiveFromBytes(nil); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("expected an error for empty data, got %v", err)
+	}
+}
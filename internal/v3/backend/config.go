@@ -1,3 +1,6 @@
 package backend
 
 var ConfiguredBackend Backend
+
+// ConfiguredValidationPolicy is applied to every release read from a tarball, nil disables the validation
+var ConfiguredValidationPolicy *ValidationPolicy
@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/dadav/gorge/internal/model"
+	"github.com/dadav/gorge/internal/v3/utils"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+	"github.com/hashicorp/go-version"
+)
+
+// Dependent is a release which depends on a module
+type Dependent struct {
+	Release            *gen.Release
+	VersionRequirement string
+	// Current is set if the release is the current release of its module
+	Current bool
+}
+
+// dependency is an entry of the reverse dependency index of the memory store
+type dependency struct {
+	release            *gen.Release
+	versionRequirement string
+}
+
+// dependencyName converts dependency names like puppetlabs/stdlib to the module slug puppetlabs-stdlib
+func dependencyName(name string) string {
+	return strings.ReplaceAll(name, "/", "-")
+}
+
+// releaseDependencies returns the dependencies listed in the metadata of the release
+func releaseDependencies(release *gen.Release) []model.ModuleDependency {
+	metadata, err := releaseMetadata(release)
+	if err != nil {
+		return nil
+	}
+	return metadata.Dependencies
+}
+
+// indexDependencies adds the dependencies of the release to the reverse dependency index
+// The caller has to hold muReleases
+func (s *memoryStore) indexDependencies(release *gen.Release) {
+	for _, dep := range releaseDependencies(release) {
+		name := dependencyName(dep.Name)
+		if name == release.Module.Slug {
+			continue
+		}
+		if s.dependents[name] == nil {
+			s.dependents[name] = map[string]*dependency{}
+		}
+		s.dependents[name][release.Slug] = &dependency{release: release, versionRequirement: dep.VersionRequirement}
+	}
+}
+
+// unindexDependencies removes the release from the reverse dependency index
+// The caller has to hold muReleases
+func (s *memoryStore) unindexDependencies(release *gen.Release) {
+	for _, dep := range releaseDependencies(release) {
+		name := dependencyName(dep.Name)
+		delete(s.dependents[name], release.Slug)
+		if len(s.dependents[name]) == 0 {
+			delete(s.dependents, name)
+		}
+	}
+}
+
+func (s *memoryStore) GetDependents(moduleSlug string) ([]*Dependent, error) {
+	s.muModules.RLock()
+	s.muReleases.RLock()
+	defer s.muModules.RUnlock()
+	defer s.muReleases.RUnlock()
+
+	result := []*Dependent{}
+	for _, dep := range s.dependents[moduleSlug] {
+		if ReleaseDeleted(dep.release) {
+			continue
+		}
+		current := false
+		if module, ok := s.Modules[dep.release.Module.Slug]; ok {
+			current = module.CurrentRelease.Slug == dep.release.Slug
+		}
+		result = append(result, &Dependent{
+			Release:            dep.release,
+			VersionRequirement: dep.versionRequirement,
+			Current:            current,
+		})
+	}
+
+	sortDependents(result)
+
+	return result, nil
+}
+
+func sortDependents(dependents []*Dependent) {
+	sort.Slice(dependents, func(i, j int) bool {
+		return dependents[i].Release.Slug < dependents[j].Release.Slug
+	})
+}
+
+// BrokenDependents returns the current releases depending on a module, which would have no matching
+// release left if the releases in removed were deleted. releases are all releases of the module.
+func BrokenDependents(dependents []*Dependent, releases []*gen.Release, removed []string) []*Dependent {
+	remaining := []*version.Version{}
+	deleting := []*version.Version{}
+	for _, release := range releases {
+		if ReleaseDeleted(release) {
+			continue
+		}
+		v, err := version.NewVersion(release.Version)
+		if err != nil {
+			continue
+		}
+		if slices.Contains(removed, release.Slug) {
+			deleting = append(deleting, v)
+		} else {
+			remaining = append(remaining, v)
+		}
+	}
+
+	broken := []*Dependent{}
+	for _, dependent := range dependents {
+		if !dependent.Current {
+			continue
+		}
+
+		versionRange, err := utils.ParseVersionRange(dependent.VersionRequirement)
+		if err != nil {
+			// Without a valid range only removing all releases breaks the dependent
+			if len(remaining) == 0 {
+				broken = append(broken, dependent)
+			}
+			continue
+		}
+
+		// Dependents which don't match any of the removed releases aren't affected
+		if !slices.ContainsFunc(deleting, versionRange.Contains) {
+			continue
+		}
+		if !slices.ContainsFunc(remaining, versionRange.Contains) {
+			broken = append(broken, dependent)
+		}
+	}
+
+	return broken
+}
@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/dadav/gorge/internal/log"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+// downloadsFile stores the download counters of the filesystem and s3 backends
+const downloadsFile = ".downloads.json"
+
+// DownloadCounter collects the downloads of the releases in memory until they are flushed to the backend
+type DownloadCounter struct {
+	mu      sync.Mutex
+	pending map[string]int32
+}
+
+func NewDownloadCounter() *DownloadCounter {
+	return &DownloadCounter{pending: map[string]int32{}}
+}
+
+// ConfiguredDownloadCounter counts the downloads of the configured backend
+var ConfiguredDownloadCounter = NewDownloadCounter()
+
+// Count records a download of the release
+func (c *DownloadCounter) Count(releaseSlug string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[releaseSlug]++
+}
+
+// Flush adds the downloads counted since the last flush to the backend
+// If the backend fails, the downloads are kept for the next flush
+func (c *DownloadCounter) Flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = map[string]int32{}
+	c.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := Traced(context.Background()).AddDownloads(pending); err != nil {
+		c.mu.Lock()
+		for slug, count := range pending {
+			c.pending[slug] += count
+		}
+		c.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// moduleDownloads sums up the downloads of all releases of a module
+func moduleDownloads(releases []*gen.Release) int32 {
+	var downloads int32
+	for _, release := range releases {
+		downloads += release.Downloads
+	}
+	return downloads
+}
+
+// readDownloads decodes the content of the downloads file, missing files contain no downloads
+func readDownloads(data []byte, err error) (map[string]int32, error) {
+	downloads := map[string]int32{}
+	if errors.Is(err, os.ErrNotExist) {
+		return downloads, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &downloads); err != nil {
+		return nil, err
+	}
+	return downloads, nil
+}
+
+// loadDownloads reads the persisted download counters with read, they are only read once
+// Afterwards the counters in memory are always up to date
+func (s *memoryStore) loadDownloads(read func() (map[string]int32, error)) {
+	s.muDownloads.Lock()
+	defer s.muDownloads.Unlock()
+
+	if s.downloadsLoaded {
+		return
+	}
+
+	downloads, err := read()
+	if err != nil {
+		log.Log.Errorf("Failed to read download counters: %v", err)
+		return
+	}
+
+	s.muModules.Lock()
+	s.muReleases.Lock()
+	defer s.muModules.Unlock()
+	defer s.muReleases.Unlock()
+
+	s.downloads = downloads
+	for moduleSlug, releases := range s.Releases {
+		for _, release := range releases {
+			release.Downloads = downloads[release.Slug]
+		}
+		s.refreshDownloads(moduleSlug)
+	}
+	s.downloadsLoaded = true
+}
+
+// addDownloads increments the download counters of the releases and their modules
+// The increments are merged into the stored counters with updateDocument, so downloads counted by
+// other instances sharing the storage are kept. The counters in memory are only changed if it succeeds
+func (s *memoryStore) addDownloads(counts map[string]int32, updateDocument func(name string, update func(current []byte) ([]byte, error)) error) error {
+	s.muDownloads.Lock()
+	defer s.muDownloads.Unlock()
+
+	var downloads map[string]int32
+	err := updateDocument(downloadsFile, func(current []byte) ([]byte, error) {
+		var err error
+		if current == nil {
+			err = os.ErrNotExist
+		}
+		stored, err := readDownloads(current, err)
+		if err != nil {
+			return nil, err
+		}
+
+		for slug, count := range counts {
+			stored[slug] += count
+		}
+		downloads = stored
+		return json.Marshal(stored)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.muModules.Lock()
+	s.muReleases.Lock()
+	defer s.muModules.Unlock()
+	defer s.muReleases.Unlock()
+
+	// The stored counters also contain the downloads of other instances
+	s.downloads = downloads
+	for moduleSlug, releases := range s.Releases {
+		for _, release := range releases {
+			release.Downloads = downloads[release.Slug]
+		}
+		s.refreshDownloads(moduleSlug)
+	}
+	s.downloadsLoaded = true
+
+	return nil
+}
+
+// refreshDownloads updates the downloads of the module and its current release
+// The caller has to hold muModules and muReleases
+func (s *memoryStore) refreshDownloads(moduleSlug string) {
+	module, ok := s.Modules[moduleSlug]
+	if !ok {
+		return
+	}
+	releases := s.Releases[moduleSlug]
+	module.Downloads = moduleDownloads(releases)
+	module.CurrentRelease = gen.ModuleCurrentRelease(*currentRelease(releases))
+}
@@ -1,10 +1,6 @@
 package backend
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
-	"crypto/md5"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
@@ -16,21 +12,44 @@ import (
 	"sync"
 	"time"
 
-	"github.com/dadav/gorge/internal/config"
 	"github.com/dadav/gorge/internal/log"
 	"github.com/dadav/gorge/internal/model"
-	"github.com/dadav/gorge/internal/v3/utils"
 	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
 	"github.com/hashicorp/go-version"
 )
 
 // FilesystemBackend implements the Backend interface for local filesystem storage
 type FilesystemBackend struct {
-	muModules  sync.RWMutex
-	Modules    map[string]*gen.Module
+	*memoryStore
 	ModulesDir string
-	muReleases sync.RWMutex
-	Releases   map[string][]*gen.Release
+	muFiles    sync.Mutex
+	// files contains the tarballs read by the last scan
+	files       map[string]scannedFile
+	muDocuments sync.Mutex
+}
+
+// fileState is used to detect tarballs which changed since they were read
+type fileState struct {
+	size  int64
+	mtime int64
+	inode uint64
+}
+
+func newFileState(info os.FileInfo) fileState {
+	return fileState{
+		size:  info.Size(),
+		mtime: info.ModTime().UnixNano(),
+		inode: fileInode(info),
+	}
+}
+
+// scannedFile is a tarball together with the release it contains
+type scannedFile struct {
+	state fileState
+	// slug is empty if the file couldn't be read or the release was already provided by another file
+	slug string
+	// conflict is the slug of the release which was already provided by another file
+	conflict string
 }
 
 var _ Backend = (*FilesystemBackend)(nil)
@@ -44,9 +63,9 @@ const (
 
 func NewFilesystemBackend(path string) *FilesystemBackend {
 	return &FilesystemBackend{
-		Modules:    map[string]*gen.Module{},
-		ModulesDir: path,
-		Releases:   map[string][]*gen.Release{},
+		memoryStore: newMemoryStore(),
+		ModulesDir:  path,
+		files:       map[string]scannedFile{},
 	}
 }
 
@@ -77,6 +96,33 @@ func findLatestVersion(releases []gen.ReleaseAbbreviated) string {
 	return latest
 }
 
+// currentRelease returns the latest release which hasn't been deleted
+// If all releases have been deleted, the latest deleted release is returned
+func currentRelease(releases []*gen.Release) *gen.Release {
+	candidates := []*gen.Release{}
+	for _, release := range releases {
+		if !ReleaseDeleted(release) {
+			candidates = append(candidates, release)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = releases
+	}
+
+	abbrReleases := make([]gen.ReleaseAbbreviated, 0, len(candidates))
+	for _, release := range candidates {
+		abbrReleases = append(abbrReleases, *ReleaseToAbbreviatedRelease(release))
+	}
+
+	latestVersion := findLatestVersion(abbrReleases)
+	for _, release := range candidates {
+		if release.Version == latestVersion {
+			return release
+		}
+	}
+	return candidates[0]
+}
+
 func ReleaseToAbbreviatedRelease(release *gen.Release) *gen.ReleaseAbbreviated {
 	return &gen.ReleaseAbbreviated{
 		Uri:       release.Uri,
@@ -90,18 +136,6 @@ func ReleaseToAbbreviatedRelease(release *gen.Release) *gen.ReleaseAbbreviated {
 	}
 }
 
-func (s *FilesystemBackend) GetAllReleases() ([]*gen.Release, error) {
-	s.muReleases.Lock()
-	defer s.muReleases.Unlock()
-	result := []*gen.Release{}
-
-	for _, v := range s.Releases {
-		result = append(result, v...)
-	}
-
-	return result, nil
-}
-
 // MetadataToRelease converts release metadata into a Release object
 func MetadataToRelease(metadata *model.ReleaseMetadata) *gen.Release {
 	var releaseMetadataInterface map[string]interface{}
@@ -136,9 +170,9 @@ func ModuleFromRelease(release *gen.Release) *gen.Module {
 		Uri:            fmt.Sprintf("/v3/modules/%s", release.Module.Name),
 		Slug:           release.Module.Slug,
 		Name:           strings.Split(release.Module.Slug, "-")[1],
-		Downloads:      0,
-		CreatedAt:      time.Now().String(),
-		UpdatedAt:      time.Now().String(),
+		Downloads:      release.Downloads,
+		CreatedAt:      release.CreatedAt,
+		UpdatedAt:      release.UpdatedAt,
 		DeprecatedAt:   nil,
 		DeprecatedFor:  nil,
 		SupersededBy:   gen.ModuleSupersededBy{},
@@ -153,208 +187,298 @@ func ModuleFromRelease(release *gen.Release) *gen.Module {
 	}
 }
 
-func (s *FilesystemBackend) AddRelease(releaseData []byte) (*gen.Release, error) {
-	s.muModules.Lock()
-	s.muReleases.Lock()
-	defer s.muModules.Unlock()
-	defer s.muReleases.Unlock()
-
-	metadata, readme, err := ReadReleaseMetadataFromBytes(releaseData)
+func (s *FilesystemBackend) AddRelease(upload *Upload) (*gen.Release, error) {
+	release, err := NewReleaseFromUpload(upload, time.Now())
 	if err != nil {
 		return nil, err
 	}
 
-	// Validate metadata.Name to ensure it does not contain path separators or parent directory references
-	if strings.Contains(metadata.Name, "/") || strings.Contains(metadata.Name, "\\") || strings.Contains(metadata.Name, "..") {
-		return nil, errors.New("invalid module name")
+	return s.addRelease(upload, release, false)
+}
+
+// addRelease records the release and stores its tarball
+// A different tarball already at its path (e.g. one the scan couldn't read) is only replaced if overwrite is set
+func (s *FilesystemBackend) addRelease(upload *Upload, release *gen.Release, overwrite bool) (*gen.Release, error) {
+	// No need to re-write releases we know of
+	existing, inserted := s.insertRelease(release)
+	if !inserted {
+		if ReleaseDeleted(existing) {
+			return nil, ErrReleaseDeleted
+		}
+		if err := checkUnchanged(existing, release); err != nil {
+			return nil, err
+		}
+		return existing, nil
 	}
 
-	releaseSlug := fmt.Sprintf("%s-%s", metadata.Name, metadata.Version)
-	if !utils.CheckReleaseSlug(releaseSlug) {
-		return nil, errors.New("invalid release slug")
+	moduleDir := filepath.Join(s.ModulesDir, release.Module.Slug)
+	releaseFilePath := filepath.Join(moduleDir, fmt.Sprintf("%s%s", release.Slug, tarGzExt))
+	if err := os.MkdirAll(moduleDir, os.ModePerm); err != nil {
+		s.removeRelease(release.Slug)
+		return nil, err
 	}
 
-	// No need to re-read releases we know of
-	for _, release := range s.Releases[metadata.Name] {
-		if release.Slug == releaseSlug {
-			return release, nil
+	sum, err := fileSha256(releaseFilePath)
+	switch {
+	case err == nil && sum == upload.Sha256:
+		// The tarball is already in place, e.g. because it wasn't scanned yet
+	case err == nil && !overwrite:
+		s.removeRelease(release.Slug)
+		return nil, fmt.Errorf("%w: the stored tarball of %s has the sha256 %s, the upload has %s", ErrReleaseExists, release.Slug, sum, upload.Sha256)
+	case err != nil && !os.IsNotExist(err):
+		s.removeRelease(release.Slug)
+		return nil, err
+	default:
+		if err := copyFileAtomic(releaseFilePath, upload.Open()); err != nil {
+			s.removeRelease(release.Slug)
+			return nil, err
 		}
 	}
-	release := MetadataToRelease(metadata)
 
-	md5Hash := md5.New()
-	sha256Hash := sha256.New()
+	// Remember the file, so the next scan doesn't have to read it again
+	if info, err := os.Stat(releaseFilePath); err == nil {
+		s.muFiles.Lock()
+		s.files[releaseFilePath] = scannedFile{state: newFileState(info), slug: release.Slug}
+		s.muFiles.Unlock()
+	}
 
-	bytesReader := bytes.NewReader(releaseData)
+	return release, nil
+}
 
-	_, err = io.Copy(md5Hash, bytesReader)
+func (s *FilesystemBackend) OverwriteRelease(upload *Upload) (*gen.Release, error) {
+	release, err := NewReleaseFromUpload(upload, time.Now())
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = bytesReader.Seek(0, 0)
+	existing, err := s.GetReleaseBySlug(release.Slug)
 	if err != nil {
+		return s.addRelease(upload, release, true)
+	}
+	if ReleaseDeleted(existing) {
+		return nil, ErrReleaseDeleted
+	}
+	prepareOverwrite(existing, release)
+
+	s.muFiles.Lock()
+	defer s.muFiles.Unlock()
+
+	releaseFilePath := s.releaseFilePath(existing)
+	if err := copyFileAtomic(releaseFilePath, upload.Open()); err != nil {
 		return nil, err
 	}
 
-	_, err = io.Copy(sha256Hash, bytesReader)
-	if err != nil {
+	if err := s.replaceRelease(release); err != nil {
 		return nil, err
 	}
 
-	md5Sum := fmt.Sprintf("%x", md5Hash.Sum(nil))
-	sha256Sum := fmt.Sprintf("%x", sha256Hash.Sum(nil))
-
-	release.FileMd5 = md5Sum
-	release.FileSha256 = sha256Sum
-	release.FileUri = fmt.Sprintf("/v3/files/%s.tar.gz", releaseSlug)
-	release.FileSize = int32(len(releaseData))
-	release.Readme = readme
-	release.License = metadata.License
-
-	var module *gen.Module
-	var ok bool
-	if module, ok = s.Modules[metadata.Name]; !ok {
-		module = ModuleFromRelease(release)
-		s.Modules[metadata.Name] = module
-	} else {
-		module.Releases = append(module.Releases, *ReleaseToAbbreviatedRelease(release))
-		if findLatestVersion(module.Releases) == release.Version {
-			module.CurrentRelease = gen.ModuleCurrentRelease(*release)
-		}
+	if info, err := os.Stat(releaseFilePath); err == nil {
+		s.files[releaseFilePath] = scannedFile{state: newFileState(info), slug: release.Slug}
 	}
-	s.Releases[metadata.Name] = append(s.Releases[metadata.Name], release)
 
-	releaseFile := fmt.Sprintf("%s.tar.gz", releaseSlug)
-	releaseFilePath := fmt.Sprintf("%s/%s/%s", config.ModulesDir, metadata.Name, releaseFile)
-	moduleDir := filepath.Join(config.ModulesDir, metadata.Name)
-	if _, err := os.Stat(moduleDir); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			err = os.MkdirAll(moduleDir, os.ModePerm)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			return nil, err
-		}
+	return release, nil
+}
+
+// releaseFilePath returns the path of the tarball belonging to the release
+func (s *FilesystemBackend) releaseFilePath(release *gen.Release) string {
+	return releasePath(s.ModulesDir, release)
+}
+
+func (s *FilesystemBackend) GetReleaseFile(slug string) (*ReleaseFile, error) {
+	release, err := s.GetReleaseBySlug(slug)
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := os.Stat(releaseFilePath); os.IsNotExist(err) {
-		// Only write if file does not exist
-		err = os.WriteFile(releaseFilePath, releaseData, 0644)
-		if err != nil {
-			return nil, err
-		}
+	// Deleted releases can't be downloaded until they are restored
+	if ReleaseDeleted(release) {
+		return nil, os.ErrNotExist
 	}
 
-	return release, nil
+	return openReleaseFile(s.releaseFilePath(release), release.FileSha256)
 }
 
-func (s *FilesystemBackend) GetAllModules() ([]*gen.Module, error) {
-	s.muModules.Lock()
-	defer s.muModules.Unlock()
+// fileSha256 calculates the sha256 checksum of the file at path
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
 
-	result := []*gen.Module{}
+// openReleaseFile opens the tarball at path
+func openReleaseFile(path, sha256 string) (*ReleaseFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, v := range s.Modules {
-		result = append(result, v)
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
 	}
 
-	return result, nil
+	return &ReleaseFile{
+		ReadSeekCloser: f,
+		Size:           info.Size(),
+		ModTime:        info.ModTime(),
+		Sha256:         sha256,
+	}, nil
 }
 
-func (s *FilesystemBackend) GetModuleBySlug(slug string) (*gen.Module, error) {
-	s.muModules.Lock()
-	defer s.muModules.Unlock()
-	if module, ok := s.Modules[slug]; !ok {
-		return nil, errors.New("module not found")
-	} else {
-		return module, nil
+func (s *FilesystemBackend) DeleteModuleBySlug(slug, reason string) error {
+	module, err := s.GetModuleBySlug(slug)
+	if err != nil || ModuleDeleted(module) {
+		return os.ErrNotExist
 	}
-}
 
-func (s *FilesystemBackend) GetReleaseBySlug(slug string) (*gen.Release, error) {
-	s.muReleases.Lock()
-	defer s.muReleases.Unlock()
-	for _, moduleReleases := range s.Releases {
-		for _, release := range moduleReleases {
-			if release.Slug == slug {
-				return release, nil
-			}
+	s.muFiles.Lock()
+	defer s.muFiles.Unlock()
+
+	deletion := NewDeletion(reason)
+	for _, abbrRelease := range module.Releases {
+		if abbrRelease.DeletedAt != nil {
+			continue
+		}
+		if err := s.trashRelease(abbrRelease.Slug, deletion); err != nil {
+			return err
 		}
 	}
-	return nil, os.ErrNotExist
+
+	return nil
 }
 
-func (s *FilesystemBackend) DeleteModuleBySlug(slug string) error {
-	s.muModules.Lock()
-	s.muReleases.Lock()
-	defer s.muModules.Unlock()
-	defer s.muReleases.Unlock()
+func (s *FilesystemBackend) DeleteReleaseBySlug(slug, reason string) error {
+	s.muFiles.Lock()
+	defer s.muFiles.Unlock()
 
-	modulePath := filepath.Join(config.ModulesDir, slug)
-	err := os.RemoveAll(modulePath)
+	return s.trashRelease(slug, NewDeletion(reason))
+}
+
+// trashRelease moves the tarball of the release into the trash, muFiles must be held
+func (s *FilesystemBackend) trashRelease(slug string, deletion *Deletion) error {
+	release, err := s.GetReleaseBySlug(slug)
 	if err != nil {
 		return err
 	}
+	if ReleaseDeleted(release) {
+		return os.ErrNotExist
+	}
+
+	path := s.releaseFilePath(release)
+	trashed := *release
+	deletion.applyTo(&trashed)
+	trashPath := s.releaseFilePath(&trashed)
+
+	if err := moveToTrash(path, trashPath, deletion); err != nil {
+		return err
+	}
 
-	delete(s.Releases, slug)
-	delete(s.Modules, slug)
+	s.moveScannedFile(path, trashPath)
+	_, err = s.setReleaseDeletion(slug, deletion)
 
-	return nil
+	return err
 }
 
-func (s *FilesystemBackend) DeleteReleaseBySlug(slug string) error {
-	s.muModules.Lock()
-	s.muReleases.Lock()
-	defer s.muModules.Unlock()
-	defer s.muReleases.Unlock()
-
-	for module, releases := range s.Releases {
-		newReleases := []*gen.Release{}
-		for _, release := range releases {
-			if release.Slug == slug {
-				releasePath := filepath.Join(config.ModulesDir, release.Module.Slug, fmt.Sprintf("%s.tar.gz", slug))
-				err := os.Remove(releasePath)
-				if err != nil {
-					return err
-				}
-			} else {
-				newReleases = append(newReleases, release)
-			}
-		}
-		s.Releases[module] = newReleases
+func (s *FilesystemBackend) RestoreReleaseBySlug(slug string) (*gen.Release, error) {
+	s.muFiles.Lock()
+	defer s.muFiles.Unlock()
 
-		newAbbrReleases := []gen.ReleaseAbbreviated{}
-		for _, abbrRelease := range s.Modules[module].Releases {
-			if abbrRelease.Slug != slug {
-				newAbbrReleases = append(newAbbrReleases, abbrRelease)
-			}
+	release, err := s.GetReleaseBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if !ReleaseDeleted(release) {
+		return nil, ErrReleaseNotDeleted
+	}
+
+	trashPath := s.releaseFilePath(release)
+	restored := *release
+	restored.DeletedAt = nil
+	path := s.releaseFilePath(&restored)
+
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+
+	if err := moveFromTrash(trashPath, path); err != nil {
+		return nil, err
+	}
+
+	s.moveScannedFile(trashPath, path)
+
+	return s.setReleaseDeletion(slug, nil)
+}
+
+// moveScannedFile updates the path of a tarball moved by gorge, so the next scan doesn't read it again
+// muFiles must be held
+func (s *FilesystemBackend) moveScannedFile(from, to string) {
+	file, ok := s.files[from]
+	if !ok {
+		return
+	}
+	delete(s.files, from)
+
+	if info, err := os.Stat(to); err == nil {
+		file.state = newFileState(info)
+		s.files[to] = file
+	}
+}
+
+func (s *FilesystemBackend) PurgeDeletedReleases(deletedBefore time.Time) (int, error) {
+	s.muFiles.Lock()
+	defer s.muFiles.Unlock()
+
+	purged := 0
+	for _, release := range s.deletedReleases(deletedBefore) {
+		trashPath := s.releaseFilePath(release)
+		if err := removeFromTrash(trashPath); err != nil {
+			return purged, err
 		}
-		s.Modules[module].Releases = newAbbrReleases
-
-		if s.Modules[module].CurrentRelease.Slug == slug {
-			latestReleaseVersion := findLatestVersion(s.Modules[module].Releases)
-			for _, modRelease := range s.Releases[module] {
-				if modRelease.Version == latestReleaseVersion {
-					s.Modules[module].CurrentRelease = gen.ModuleCurrentRelease(*modRelease)
-					break
-				}
+		delete(s.files, trashPath)
+		s.removeRelease(release.Slug)
+		purged++
+
+		// The state of modules without any releases left is dropped as well
+		if _, err := s.GetModuleBySlug(release.Module.Slug); err != nil {
+			if err := os.Remove(s.moduleStatePath(release.Module.Slug)); err != nil && !os.IsNotExist(err) {
+				return purged, err
 			}
+			s.deleteModuleState(release.Module.Slug)
 		}
 	}
 
-	return nil
+	return purged, nil
 }
 
+// LoadModules scans the modules directory
+// Only new or changed tarballs are read and releases of removed tarballs are dropped
 func (s *FilesystemBackend) LoadModules() error {
-	// Initialize maps if they haven't been created yet
-	if s.Modules == nil {
-		s.Modules = make(map[string]*gen.Module)
-	}
-	if s.Releases == nil {
-		s.Releases = make(map[string][]*gen.Release)
+	start := time.Now()
+
+	s.muFiles.Lock()
+	defer s.muFiles.Unlock()
+
+	if err := s.loadModuleStates(); err != nil {
+		return err
 	}
+	s.loadDownloads(func() (map[string]int32, error) {
+		return readDownloads(os.ReadFile(s.downloadsPath()))
+	})
+
+	seen := map[string]bool{}
+	// pending contains the new and changed tarballs in the order they were found
+	var pending []string
+	infos := map[string]os.FileInfo{}
+	added, removed, failed := 0, 0, 0
 
 	// Walk through all files in the modules directory recursively
 	err := filepath.Walk(s.ModulesDir, func(path string, info os.FileInfo, err error) error {
@@ -363,109 +487,185 @@ func (s *FilesystemBackend) LoadModules() error {
 		}
 
 		// Skip directories and non-tar.gz files
-		if info.IsDir() || !strings.HasSuffix(info.Name(), ".tar.gz") {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), tarGzExt) {
 			return nil
 		}
 
+		seen[path] = true
+		known, ok := s.files[path]
+		if ok && known.state == newFileState(info) {
+			return nil
+		}
+		pending = append(pending, path)
+		infos[path] = info
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Drop the releases of removed and replaced files before reading the new ones,
+	// so a moved tarball can provide its release again
+	freed := map[string]bool{}
+	for path, file := range s.files {
+		if seen[path] && infos[path] == nil {
+			continue
+		}
+
+		if !seen[path] {
+			delete(s.files, path)
+		}
+		if file.slug != "" {
+			s.removeRelease(file.slug)
+			freed[file.slug] = true
+			removed++
+		}
+	}
+
+	// Unchanged files which lost against a removed file provide the release now
+	for path, file := range s.files {
+		if file.conflict != "" && freed[file.conflict] && infos[path] == nil {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			pending = append(pending, path)
+			infos[path] = info
+		}
+	}
+
+	for _, path := range pending {
+		info := infos[path]
+		state := newFileState(info)
+
 		log.Log.Debugf("Reading %s\n", path)
 		// Read the release archive file
-		releaseBytes, err := os.ReadFile(path)
+		release, err := s.readReleaseFile(path, info)
 		if err != nil {
-			return err
+			// Remember the broken file, so it's only read again once it changes
+			log.Log.Errorf("Failed to read %s: %v", path, err)
+			s.files[path] = scannedFile{state: state}
+			failed++
+			continue
 		}
 
-		// Process the release archive and add it to the backend
-		// This will update both s.Modules and s.Releases maps
-		_, err = s.AddRelease(releaseBytes)
-		return err
-	})
-	if err != nil {
-		return err
+		if _, inserted := s.insertRelease(release); inserted {
+			s.files[path] = scannedFile{state: state, slug: release.Slug}
+			added++
+		} else {
+			log.Log.Warnf("Ignoring %s, release %s is already provided by another file", path, release.Slug)
+			s.files[path] = scannedFile{state: state, conflict: release.Slug}
+		}
+	}
+
+	logf := log.Log.Debugf
+	if added > 0 || removed > 0 || failed > 0 {
+		logf = log.Log.Infof
 	}
+	logf("Scanned %s in %s: %d releases added, %d removed, %d failed", s.ModulesDir, time.Since(start), added, removed, failed)
+
 	return nil
 }
 
-// ReadReleaseMetadataFromBytes extracts metadata and README from a gzipped tar archive
-// Parameters:
-//   - data: byte slice containing the gzipped tar archive
-//
-// Returns:
-//   - *model.ReleaseMetadata: parsed metadata from metadata.json
-//   - string: contents of README.md
-//   - error: any errors encountered during processing
-func ReadReleaseMetadataFromBytes(data []byte) (*model.ReleaseMetadata, string, error) {
-	if len(data) == 0 {
-		return nil, "", errors.New("empty data provided")
+// readReleaseFile reads the release from the tarball at path
+// Tarballs in the trash are marked as deleted
+func (s *FilesystemBackend) readReleaseFile(path string, info os.FileInfo) (*gen.Release, error) {
+	releaseBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
 
-	var jsonData bytes.Buffer
-	var releaseMetadata model.ReleaseMetadata
-	readme := new(strings.Builder)
-
-	// Create readers to process the gzipped tar data
-	f := bytes.NewReader(data)
-	g, err := gzip.NewReader(f)
+	release, err := NewReleaseFromBytes(releaseBytes, info.ModTime())
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create gzip reader: %v", err)
+		return nil, err
 	}
-	defer g.Close()
 
-	tarReader := tar.NewReader(g)
+	if inTrash(s.ModulesDir, path) {
+		readDeletion(path, info.ModTime()).applyTo(release)
+	}
+
+	return release, nil
+}
+
+// moduleStatePath returns the path of the file containing the state of the module
+func (s *FilesystemBackend) moduleStatePath(slug string) string {
+	return filepath.Join(s.ModulesDir, slug+moduleStateExt)
+}
+
+// loadModuleStates reads the <slug>.json files next to the module directories
+func (s *FilesystemBackend) loadModuleStates() error {
+	entries, err := os.ReadDir(s.ModulesDir)
+	if err != nil {
+		return err
+	}
 
-	// Iterate through all files in the archive
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
+	states := map[string]*ModuleState{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, moduleStateExt) {
+			continue
 		}
 
+		data, err := os.ReadFile(filepath.Join(s.ModulesDir, name))
 		if err != nil {
-			return nil, readme.String(), err
+			return err
 		}
 
-		// Skip if not a regular file
-		if header.Typeflag != tar.TypeReg {
+		var state ModuleState
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Log.Errorf("Ignoring invalid module state %s: %v", name, err)
 			continue
 		}
+		states[strings.TrimSuffix(name, moduleStateExt)] = &state
+	}
 
-		// Process only metadata.json and README.md files
-		switch filepath.Base(header.Name) {
-		case "metadata.json":
-			// Read and parse the metadata file
-			_, err = io.Copy(&jsonData, tarReader)
-			if err != nil {
-				return nil, readme.String(), err
-			}
+	s.replaceModuleStates(states)
 
-			if err := json.Unmarshal(jsonData.Bytes(), &releaseMetadata); err != nil {
-				return nil, readme.String(), err
-			}
+	return nil
+}
 
-			// Validate the module name
-			if !utils.CheckModuleSlug(releaseMetadata.Name) {
-				return nil, readme.String(), errors.New("invalid module name")
-			}
-		case "README.md":
-			// Read the README contents
-			_, err = io.Copy(readme, tarReader)
-			if err != nil {
-				return nil, readme.String(), err
-			}
-		default:
-			continue
-		}
+func (s *FilesystemBackend) UpdateModule(module *gen.Module) error {
+	state := ModuleStateOf(module)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(s.moduleStatePath(module.Slug), data, 0644); err != nil {
+		return err
 	}
-	return &releaseMetadata, readme.String(), nil
+
+	s.setModuleState(module.Slug, state)
+
+	return nil
+}
+
+func (s *FilesystemBackend) downloadsPath() string {
+	return filepath.Join(s.ModulesDir, downloadsFile)
+}
+
+func (s *FilesystemBackend) ReadDocument(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.ModulesDir, name))
 }
 
-func (b *FilesystemBackend) UpdateModule(module *gen.Module) error {
-	// Convert module to JSON
-	data, err := json.Marshal(module)
+func (s *FilesystemBackend) UpdateDocument(name string, update func(current []byte) ([]byte, error)) error {
+	s.muDocuments.Lock()
+	defer s.muDocuments.Unlock()
+
+	path := filepath.Join(s.ModulesDir, name)
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	data, err := update(current)
 	if err != nil {
 		return err
 	}
+	return writeFileAtomic(path, data)
+}
 
-	// Write to file
-	filename := filepath.Join(b.ModulesDir, module.Slug+".json")
-	return os.WriteFile(filename, data, 0644)
+func (s *FilesystemBackend) AddDownloads(counts map[string]int32) error {
+	return s.addDownloads(counts, s.UpdateDocument)
 }
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package backend
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode of the file, so replaced files are detected even if size and mtime match
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package backend
+
+import (
+	"os"
+)
+
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
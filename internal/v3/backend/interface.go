@@ -1,6 +1,20 @@
 package backend
 
-import gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+import (
+	"io"
+	"time"
+
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+// ReleaseFile is an opened release tarball
+type ReleaseFile struct {
+	io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+	// Sha256 is the checksum of the tarball, used as etag
+	Sha256 string
+}
 
 type Backend interface {
 	// LoadModules loads modules into memory
@@ -18,15 +32,49 @@ type Backend interface {
 	// GetReleaseBySlug returns a release by slug
 	GetReleaseBySlug(slug string) (*gen.Release, error)
 
+	// QueryModules returns the modules matching the query and the total number of matches
+	QueryModules(query *ModuleQuery) ([]*gen.Module, int, error)
+
+	// QueryReleases returns the releases matching the query and the total number of matches
+	QueryReleases(query *ReleaseQuery) ([]*gen.Release, int, error)
+
+	// GetReleaseFile opens the tarball of the release with the given slug
+	// The caller has to close the returned file
+	GetReleaseFile(slug string) (*ReleaseFile, error)
+
 	// AddRelease adds a new release
-	AddRelease(data []byte) (*gen.Release, error)
+	AddRelease(upload *Upload) (*gen.Release, error)
+
+	// OverwriteRelease replaces the tarball of a known release, unknown releases are added
+	OverwriteRelease(upload *Upload) (*gen.Release, error)
 
-	// DeleteModuleBySlug deletes a module
-	DeleteModuleBySlug(slug string) error
+	// AddDownloads increments the persisted download counters of the releases, keyed by release slug
+	AddDownloads(counts map[string]int32) error
 
-	// DeleteReleaseBySlug deletes a release by slug
-	DeleteReleaseBySlug(slug string) error
+	// DeleteModuleBySlug moves all releases of the module into the trash
+	DeleteModuleBySlug(slug, reason string) error
+
+	// DeleteReleaseBySlug moves the release into the trash
+	DeleteReleaseBySlug(slug, reason string) error
+
+	// RestoreReleaseBySlug moves a deleted release back from the trash
+	RestoreReleaseBySlug(slug string) (*gen.Release, error)
+
+	// GetDependents returns the releases which aren't deleted and depend on the module
+	GetDependents(moduleSlug string) ([]*Dependent, error)
+
+	// PurgeDeletedReleases permanently removes the releases deleted before the given time
+	// It returns the number of purged releases
+	PurgeDeletedReleases(deletedBefore time.Time) (int, error)
 
 	// UpdateModule updates a module
 	UpdateModule(module *gen.Module) error
+
+	// ReadDocument returns the content of a small json document kept in the storage of the backend
+	// Returns os.ErrNotExist if the document doesn't exist
+	ReadDocument(name string) ([]byte, error)
+
+	// UpdateDocument replaces the document with the result of update, which receives the current content or nil
+	// Updates of other instances sharing the storage are never lost, errors of update are returned unchanged
+	UpdateDocument(name string, update func(current []byte) ([]byte, error)) error
 }
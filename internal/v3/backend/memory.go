@@ -0,0 +1,358 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/v3/utils"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+// memoryStore keeps modules and releases in memory
+// It is embedded by the backends which only differ in how the release files are stored
+type memoryStore struct {
+	muModules  sync.RWMutex
+	Modules    map[string]*gen.Module
+	muReleases sync.RWMutex
+	Releases   map[string][]*gen.Release
+	// states are kept even if a module has no releases left, so they survive rescans
+	states map[string]*ModuleState
+	// dependents is the reverse dependency index, it maps module slugs to the releases depending on them
+	dependents map[string]map[string]*dependency
+	// downloads are the persisted download counters by release slug, kept for releases which are rescanned
+	downloads       map[string]int32
+	muDownloads     sync.Mutex
+	downloadsLoaded bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		Modules:    map[string]*gen.Module{},
+		Releases:   map[string][]*gen.Release{},
+		states:     map[string]*ModuleState{},
+		dependents: map[string]map[string]*dependency{},
+		downloads:  map[string]int32{},
+	}
+}
+
+// NewReleaseFromBytes reads a scanned release archive and creates the release with its checksums
+// Violations of the validation policy are only logged, unless the policy is enforced on scans
+// createdAt is recorded as the creation time of the release
+func NewReleaseFromBytes(releaseData []byte, createdAt time.Time) (*gen.Release, error) {
+	enforce := ConfiguredValidationPolicy != nil && ConfiguredValidationPolicy.EnforceOnScan
+	return newRelease(NewUploadFromBytes(releaseData), createdAt, enforce)
+}
+
+// NewReleaseFromUpload reads an uploaded release archive, applies the validation policy and creates the release
+// The checksums calculated while the tarball was uploaded are used
+func NewReleaseFromUpload(upload *Upload, createdAt time.Time) (*gen.Release, error) {
+	return newRelease(upload, createdAt, true)
+}
+
+// newRelease creates the release, violations of the validation policy are returned if enforce is set
+func newRelease(upload *Upload, createdAt time.Time, enforce bool) (*gen.Release, error) {
+	if upload.Size == 0 {
+		return nil, errors.New("empty data provided")
+	}
+
+	var violations error
+	if ConfiguredValidationPolicy != nil {
+		violations = ConfiguredValidationPolicy.CheckSize(upload.Size)
+		if violations != nil && enforce {
+			return nil, violations
+		}
+	}
+
+	archive, err := upload.Archive()
+	if err != nil {
+		return nil, err
+	}
+	metadata := archive.Metadata
+
+	if ConfiguredValidationPolicy != nil && violations == nil {
+		violations = ConfiguredValidationPolicy.Validate(archive)
+		if violations != nil && enforce {
+			return nil, violations
+		}
+	}
+
+	// Validate metadata.Name to ensure it does not contain path separators or parent directory references
+	if strings.Contains(metadata.Name, "/") || strings.Contains(metadata.Name, "\\") || strings.Contains(metadata.Name, "..") {
+		return nil, errors.New("invalid module name")
+	}
+
+	releaseSlug := fmt.Sprintf("%s-%s", metadata.Name, metadata.Version)
+	if !utils.CheckReleaseSlug(releaseSlug) {
+		return nil, errors.New("invalid release slug")
+	}
+
+	if violations != nil {
+		log.Log.Warnf("Publishing %s although it violates the validation policy: %v", releaseSlug, violations)
+	}
+
+	release := MetadataToRelease(metadata)
+	release.FileMd5 = upload.Md5
+	release.FileSha256 = upload.Sha256
+	release.FileUri = fmt.Sprintf("/v3/files/%s%s", releaseSlug, tarGzExt)
+	release.FileSize = int32(upload.Size)
+	release.License = metadata.License
+	archive.applyTo(release)
+	release.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	release.UpdatedAt = release.CreatedAt
+
+	return release, nil
+}
+
+// insertRelease adds the release to its module, creating the module if needed
+// If a release with the same slug is already known, it is returned instead and inserted is false
+func (s *memoryStore) insertRelease(release *gen.Release) (*gen.Release, bool) {
+	s.muModules.Lock()
+	s.muReleases.Lock()
+	defer s.muModules.Unlock()
+	defer s.muReleases.Unlock()
+
+	moduleSlug := release.Module.Slug
+	for _, existing := range s.Releases[moduleSlug] {
+		if existing.Slug == release.Slug {
+			return existing, false
+		}
+	}
+
+	release.Downloads = s.downloads[release.Slug]
+	s.Releases[moduleSlug] = append(s.Releases[moduleSlug], release)
+	s.indexDependencies(release)
+	if module, ok := s.Modules[moduleSlug]; !ok {
+		module = ModuleFromRelease(release)
+		if state, ok := s.states[moduleSlug]; ok {
+			state.applyTo(module)
+		}
+		s.Modules[moduleSlug] = module
+	} else {
+		module.Releases = append(module.Releases, *ReleaseToAbbreviatedRelease(release))
+		module.Downloads += release.Downloads
+		if release.CreatedAt < module.CreatedAt {
+			module.CreatedAt = release.CreatedAt
+		}
+		if release.CreatedAt > module.UpdatedAt {
+			module.UpdatedAt = release.CreatedAt
+		}
+		module.CurrentRelease = gen.ModuleCurrentRelease(*currentRelease(s.Releases[moduleSlug]))
+	}
+
+	return release, true
+}
+
+// removeRelease drops the release from memory and updates the current release of its module
+// Modules without any releases left are removed as well
+func (s *memoryStore) removeRelease(slug string) (*gen.Release, bool) {
+	s.muModules.Lock()
+	s.muReleases.Lock()
+	defer s.muModules.Unlock()
+	defer s.muReleases.Unlock()
+
+	var removed *gen.Release
+	for moduleSlug, releases := range s.Releases {
+		newReleases := []*gen.Release{}
+		for _, release := range releases {
+			if release.Slug == slug {
+				removed = release
+			} else {
+				newReleases = append(newReleases, release)
+			}
+		}
+
+		if removed == nil {
+			continue
+		}
+		s.unindexDependencies(removed)
+
+		module := s.Modules[moduleSlug]
+		if len(newReleases) == 0 {
+			delete(s.Releases, moduleSlug)
+			delete(s.Modules, moduleSlug)
+			return removed, true
+		}
+		s.Releases[moduleSlug] = newReleases
+
+		newAbbrReleases := []gen.ReleaseAbbreviated{}
+		for _, abbrRelease := range module.Releases {
+			if abbrRelease.Slug != slug {
+				newAbbrReleases = append(newAbbrReleases, abbrRelease)
+			}
+		}
+		module.Releases = newAbbrReleases
+		module.Downloads = moduleDownloads(newReleases)
+
+		module.CreatedAt = newReleases[0].CreatedAt
+		module.UpdatedAt = newReleases[0].CreatedAt
+		for _, modRelease := range newReleases[1:] {
+			if modRelease.CreatedAt < module.CreatedAt {
+				module.CreatedAt = modRelease.CreatedAt
+			}
+			if modRelease.CreatedAt > module.UpdatedAt {
+				module.UpdatedAt = modRelease.CreatedAt
+			}
+		}
+
+		module.CurrentRelease = gen.ModuleCurrentRelease(*currentRelease(newReleases))
+
+		return removed, true
+	}
+
+	return nil, false
+}
+
+// setReleaseDeletion marks the release as deleted, a nil deletion restores it
+// The current release of its module is updated accordingly
+func (s *memoryStore) setReleaseDeletion(slug string, deletion *Deletion) (*gen.Release, error) {
+	s.muModules.Lock()
+	s.muReleases.Lock()
+	defer s.muModules.Unlock()
+	defer s.muReleases.Unlock()
+
+	for moduleSlug, releases := range s.Releases {
+		for _, release := range releases {
+			if release.Slug != slug {
+				continue
+			}
+
+			deletion.applyTo(release)
+
+			module := s.Modules[moduleSlug]
+			for i := range module.Releases {
+				if module.Releases[i].Slug == slug {
+					module.Releases[i].DeletedAt = release.DeletedAt
+				}
+			}
+			module.CurrentRelease = gen.ModuleCurrentRelease(*currentRelease(releases))
+
+			return release, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// deletedReleases returns the releases which have been deleted before t
+func (s *memoryStore) deletedReleases(t time.Time) []*gen.Release {
+	s.muReleases.RLock()
+	defer s.muReleases.RUnlock()
+
+	result := []*gen.Release{}
+	for _, releases := range s.Releases {
+		for _, release := range releases {
+			if deletedBefore(release, t) {
+				result = append(result, release)
+			}
+		}
+	}
+
+	return result
+}
+
+// removeModule drops the module and all its releases from memory
+func (s *memoryStore) removeModule(slug string) {
+	s.muModules.Lock()
+	s.muReleases.Lock()
+	defer s.muModules.Unlock()
+	defer s.muReleases.Unlock()
+
+	for _, release := range s.Releases[slug] {
+		s.unindexDependencies(release)
+	}
+	delete(s.Releases, slug)
+	delete(s.Modules, slug)
+}
+
+// setModuleState stores the state and applies it to the module if it exists
+func (s *memoryStore) setModuleState(slug string, state *ModuleState) {
+	s.muModules.Lock()
+	defer s.muModules.Unlock()
+
+	s.states[slug] = state
+	if module, ok := s.Modules[slug]; ok {
+		state.applyTo(module)
+	}
+}
+
+// replaceModuleStates replaces all states, modules whose state is gone are reset
+func (s *memoryStore) replaceModuleStates(states map[string]*ModuleState) {
+	s.muModules.Lock()
+	defer s.muModules.Unlock()
+
+	for slug := range s.states {
+		if _, ok := states[slug]; !ok {
+			if module, ok := s.Modules[slug]; ok {
+				(&ModuleState{}).applyTo(module)
+			}
+		}
+	}
+
+	s.states = states
+	for slug, state := range states {
+		if module, ok := s.Modules[slug]; ok {
+			state.applyTo(module)
+		}
+	}
+}
+
+// deleteModuleState forgets the state of the module
+func (s *memoryStore) deleteModuleState(slug string) {
+	s.muModules.Lock()
+	defer s.muModules.Unlock()
+
+	delete(s.states, slug)
+}
+
+func (s *memoryStore) GetAllReleases() ([]*gen.Release, error) {
+	s.muReleases.RLock()
+	defer s.muReleases.RUnlock()
+	result := []*gen.Release{}
+
+	for _, v := range s.Releases {
+		result = append(result, v...)
+	}
+
+	return result, nil
+}
+
+func (s *memoryStore) GetAllModules() ([]*gen.Module, error) {
+	s.muModules.RLock()
+	defer s.muModules.RUnlock()
+
+	result := []*gen.Module{}
+
+	for _, v := range s.Modules {
+		result = append(result, v)
+	}
+
+	return result, nil
+}
+
+func (s *memoryStore) GetModuleBySlug(slug string) (*gen.Module, error) {
+	s.muModules.RLock()
+	defer s.muModules.RUnlock()
+	if module, ok := s.Modules[slug]; !ok {
+		return nil, errors.New("module not found")
+	} else {
+		return module, nil
+	}
+}
+
+func (s *memoryStore) GetReleaseBySlug(slug string) (*gen.Release, error) {
+	s.muReleases.RLock()
+	defer s.muReleases.RUnlock()
+	for _, moduleReleases := range s.Releases {
+		for _, release := range moduleReleases {
+			if release.Slug == slug {
+				return release, nil
+			}
+		}
+	}
+	return nil, os.ErrNotExist
+}
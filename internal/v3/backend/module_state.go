@@ -0,0 +1,42 @@
+package backend
+
+import (
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+const moduleStateExt = ".json"
+
+// ModuleState contains the module fields which can't be derived from its releases
+// It is persisted by the backends and merged into the module whenever it's (re)created
+type ModuleState struct {
+	DeprecatedAt  *string                `json:"deprecated_at,omitempty"`
+	DeprecatedFor *string                `json:"deprecated_for,omitempty"`
+	SupersededBy  gen.ModuleSupersededBy `json:"superseded_by,omitempty"`
+	Endorsement   *string                `json:"endorsement,omitempty"`
+	ModuleGroup   string                 `json:"module_group,omitempty"`
+	Premium       bool                   `json:"premium,omitempty"`
+}
+
+// ModuleStateOf returns the state of the module
+func ModuleStateOf(module *gen.Module) *ModuleState {
+	return &ModuleState{
+		DeprecatedAt:  module.DeprecatedAt,
+		DeprecatedFor: module.DeprecatedFor,
+		SupersededBy:  module.SupersededBy,
+		Endorsement:   module.Endorsement,
+		ModuleGroup:   module.ModuleGroup,
+		Premium:       module.Premium,
+	}
+}
+
+// applyTo copies the state into the module
+func (st *ModuleState) applyTo(module *gen.Module) {
+	module.DeprecatedAt = st.DeprecatedAt
+	module.DeprecatedFor = st.DeprecatedFor
+	module.SupersededBy = st.SupersededBy
+	module.Endorsement = st.Endorsement
+	if st.ModuleGroup != "" {
+		module.ModuleGroup = st.ModuleGroup
+	}
+	module.Premium = st.Premium
+}
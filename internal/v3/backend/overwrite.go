@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+// ErrReleaseExists is returned if a release is uploaded again with a different content
+var ErrReleaseExists = errors.New("release already exists with a different checksum")
+
+// checkUnchanged returns ErrReleaseExists if the uploaded release differs from the known one
+// Uploading the same tarball again is allowed
+func checkUnchanged(existing, uploaded *gen.Release) error {
+	if existing.FileSha256 != uploaded.FileSha256 {
+		return fmt.Errorf("%w: %s has the sha256 %s, the upload has %s", ErrReleaseExists, existing.Slug, existing.FileSha256, uploaded.FileSha256)
+	}
+	return nil
+}
+
+// prepareOverwrite carries over the fields which don't depend on the tarball
+func prepareOverwrite(existing, uploaded *gen.Release) {
+	uploaded.CreatedAt = existing.CreatedAt
+	uploaded.Downloads = existing.Downloads
+}
+
+// writeFileAtomic replaces the file at path, readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	return copyFileAtomic(path, bytes.NewReader(data))
+}
+
+// copyFileAtomic replaces the file at path with the content of r, readers never see a partially written file
+func copyFileAtomic(path string, r io.Reader) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// replaceRelease swaps the known release with the same slug against the given one
+func (s *memoryStore) replaceRelease(release *gen.Release) error {
+	s.muModules.Lock()
+	s.muReleases.Lock()
+	defer s.muModules.Unlock()
+	defer s.muReleases.Unlock()
+
+	moduleSlug := release.Module.Slug
+	releases := s.Releases[moduleSlug]
+	for i, existing := range releases {
+		if existing.Slug != release.Slug {
+			continue
+		}
+
+		s.unindexDependencies(existing)
+		releases[i] = release
+		s.indexDependencies(release)
+
+		module := s.Modules[moduleSlug]
+		for j := range module.Releases {
+			if module.Releases[j].Slug == release.Slug {
+				module.Releases[j] = *ReleaseToAbbreviatedRelease(release)
+			}
+		}
+		module.CurrentRelease = gen.ModuleCurrentRelease(*currentRelease(releases))
+
+		return nil
+	}
+
+	return os.ErrNotExist
+}
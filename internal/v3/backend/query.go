@@ -0,0 +1,467 @@
+package backend
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dadav/gorge/internal/model"
+	"github.com/dadav/gorge/internal/v3/utils"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+	"github.com/hashicorp/go-version"
+)
+
+const (
+	// ModuleGroupBase contains all modules which aren't limited to puppet enterprise
+	ModuleGroupBase = "base"
+	// ModuleGroupPeOnly contains modules which only work with puppet enterprise
+	ModuleGroupPeOnly = "pe_only"
+)
+
+// ModuleQuery contains the filters, sorting and pagination used to search modules
+type ModuleQuery struct {
+	Limit          int
+	Offset         int
+	SortBy         string
+	Query          string
+	Tag            string
+	Owner          string
+	WithTasks      bool
+	WithPlans      bool
+	WithPdk        bool
+	Premium        bool
+	ExcludePremium bool
+	Endorsements   []string
+	// ShowDeleted includes modules whose releases have all been deleted
+	ShowDeleted    bool
+	HideDeprecated bool
+	Supported      bool
+	ModuleGroups   []string
+	Slugs          []string
+	StartsWith     string
+
+	// The following filters are checked against the metadata of the releases
+	// If OnlyLatest is set, only the latest non pre-release is considered, otherwise any release
+	Operatingsystem        string
+	OperatingsystemRelease string
+	PeRequirement          *utils.VersionRange
+	PuppetRequirement      *utils.VersionRange
+	WithMinimumScore       int
+	OnlyLatest             bool
+	// WithReleaseSince only includes modules with at least one release created since then
+	WithReleaseSince time.Time
+}
+
+// ReleaseQuery contains the filters, sorting and pagination used to search releases
+type ReleaseQuery struct {
+	Limit  int
+	Offset int
+	SortBy string
+	Module string
+	Owner  string
+	// ShowDeleted includes deleted releases
+	ShowDeleted bool
+	WithPdk     bool
+
+	// The following filters are checked against the metadata of the release
+	Operatingsystem        string
+	OperatingsystemRelease string
+	PeRequirement          *utils.VersionRange
+	PuppetRequirement      *utils.VersionRange
+
+	// The following filters are checked against the module of the release
+	HideDeprecated bool
+	Supported      bool
+	ModuleGroups   []string
+}
+
+// matches reports whether the module passes all filters of the query
+func (q *ModuleQuery) matches(m *gen.Module) bool {
+	if !q.ShowDeleted && ModuleDeleted(m) {
+		return false
+	}
+	if q.Query != "" && !strings.Contains(strings.ToLower(m.Slug), strings.ToLower(q.Query)) &&
+		!strings.Contains(strings.ToLower(m.Owner.Slug), strings.ToLower(q.Query)) {
+		return false
+	}
+	if q.Tag != "" && !slices.Contains(m.CurrentRelease.Tags, q.Tag) {
+		return false
+	}
+	if q.Owner != "" && m.Owner.Username != q.Owner {
+		return false
+	}
+	if q.WithTasks && len(m.CurrentRelease.Tasks) == 0 {
+		return false
+	}
+	if q.WithPlans && len(m.CurrentRelease.Plans) == 0 {
+		return false
+	}
+	if q.WithPdk && !m.CurrentRelease.Pdk {
+		return false
+	}
+	if q.Premium && !m.Premium {
+		return false
+	}
+	if q.ExcludePremium && m.Premium {
+		return false
+	}
+	if len(q.Endorsements) > 0 && (m.Endorsement == nil || !slices.Contains(q.Endorsements, *m.Endorsement)) {
+		return false
+	}
+	if q.HideDeprecated && m.DeprecatedAt != nil {
+		return false
+	}
+	if q.Supported && !m.Supported && (m.Endorsement == nil || *m.Endorsement != "supported") {
+		return false
+	}
+	if len(q.ModuleGroups) > 0 && !slices.Contains(q.ModuleGroups, moduleGroup(m)) {
+		return false
+	}
+	if len(q.Slugs) > 0 && !slices.Contains(q.Slugs, m.Slug) {
+		return false
+	}
+	if q.StartsWith != "" && !strings.HasPrefix(strings.ToLower(m.Slug), strings.ToLower(q.StartsWith)) {
+		return false
+	}
+	return true
+}
+
+// moduleGroup returns the module group used by the forge, every module which isn't pe_only is part of base
+func moduleGroup(m *gen.Module) string {
+	if m.ModuleGroup == ModuleGroupPeOnly {
+		return ModuleGroupPeOnly
+	}
+	return ModuleGroupBase
+}
+
+// hasReleaseFilters reports whether the query uses filters which need all releases of a module
+func (q *ModuleQuery) hasReleaseFilters() bool {
+	return q.Operatingsystem != "" || q.PeRequirement != nil || q.PuppetRequirement != nil ||
+		q.WithMinimumScore > 0 || !q.WithReleaseSince.IsZero()
+}
+
+// matchesReleases reports whether the releases of a module pass the release based filters
+func (q *ModuleQuery) matchesReleases(releases []*gen.Release) bool {
+	available := make([]*gen.Release, 0, len(releases))
+	for _, release := range releases {
+		if !ReleaseDeleted(release) {
+			available = append(available, release)
+		}
+	}
+
+	if !q.WithReleaseSince.IsZero() {
+		since := q.WithReleaseSince.UTC().Format(time.RFC3339)
+		if !slices.ContainsFunc(available, func(r *gen.Release) bool { return r.CreatedAt >= since }) {
+			return false
+		}
+	}
+
+	if q.Operatingsystem == "" && q.PeRequirement == nil && q.PuppetRequirement == nil && q.WithMinimumScore <= 0 {
+		return true
+	}
+
+	candidates := available
+	if q.OnlyLatest {
+		latest := latestStableRelease(available)
+		if latest == nil {
+			return false
+		}
+		candidates = []*gen.Release{latest}
+	}
+
+	// A single release has to satisfy all of the compatibility filters
+	return slices.ContainsFunc(candidates, q.matchesRelease)
+}
+
+// matchesRelease checks the compatibility filters against the metadata of the release
+func (q *ModuleQuery) matchesRelease(release *gen.Release) bool {
+	if q.WithMinimumScore > 0 && int(release.ValidationScore) < q.WithMinimumScore {
+		return false
+	}
+
+	if q.Operatingsystem == "" && q.PeRequirement == nil && q.PuppetRequirement == nil {
+		return true
+	}
+
+	metadata, err := releaseMetadata(release)
+	if err != nil {
+		return false
+	}
+
+	if q.Operatingsystem != "" && !supportsOperatingsystem(metadata, q.Operatingsystem, q.OperatingsystemRelease) {
+		return false
+	}
+	if q.PeRequirement != nil && !requirementIntersects(metadata, "pe", q.PeRequirement) {
+		return false
+	}
+	if q.PuppetRequirement != nil && !requirementIntersects(metadata, "puppet", q.PuppetRequirement) {
+		return false
+	}
+
+	return true
+}
+
+// supportsOperatingsystem checks if the metadata explicitly lists the operating system (and release)
+func supportsOperatingsystem(metadata *model.ReleaseMetadata, operatingsystem, release string) bool {
+	for _, supported := range metadata.OperatingsystemSupport {
+		if !strings.EqualFold(supported.Name, operatingsystem) {
+			continue
+		}
+		if release == "" {
+			return true
+		}
+		for _, osRelease := range supported.Releases {
+			if strings.EqualFold(osRelease, release) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requirementIntersects checks if the metadata lists a requirement with the given name in a range intersecting r
+func requirementIntersects(metadata *model.ReleaseMetadata, name string, r *utils.VersionRange) bool {
+	for _, requirement := range metadata.Requirements {
+		if !strings.EqualFold(requirement.Name, name) {
+			continue
+		}
+		requirementRange, err := utils.ParseVersionRange(requirement.VersionRequirement)
+		if err != nil {
+			continue
+		}
+		if requirementRange.Intersects(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// latestStableRelease returns the release with the highest version which isn't a pre-release
+// If only pre-releases exist, the highest of them is returned
+func latestStableRelease(releases []*gen.Release) *gen.Release {
+	var latest, latestStable *gen.Release
+	var latestVersion, latestStableVersion *version.Version
+
+	for _, release := range releases {
+		v, err := version.NewVersion(release.Version)
+		if err != nil {
+			continue
+		}
+		if latestVersion == nil || v.GreaterThan(latestVersion) {
+			latest, latestVersion = release, v
+		}
+		if v.Prerelease() == "" && (latestStableVersion == nil || v.GreaterThan(latestStableVersion)) {
+			latestStable, latestStableVersion = release, v
+		}
+	}
+
+	if latestStable != nil {
+		return latestStable
+	}
+	return latest
+}
+
+// matches reports whether the release passes all filters of the query
+func (q *ReleaseQuery) matches(r *gen.Release) bool {
+	if !q.ShowDeleted && ReleaseDeleted(r) {
+		return false
+	}
+	if q.Module != "" && r.Module.Slug != q.Module {
+		return false
+	}
+	if q.Owner != "" && r.Module.Owner.Slug != q.Owner {
+		return false
+	}
+	if q.WithPdk && !r.Pdk {
+		return false
+	}
+	if q.Operatingsystem == "" && q.PeRequirement == nil && q.PuppetRequirement == nil {
+		return true
+	}
+
+	metadata, err := releaseMetadata(r)
+	if err != nil {
+		return false
+	}
+	if q.Operatingsystem != "" && !supportsOperatingsystem(metadata, q.Operatingsystem, q.OperatingsystemRelease) {
+		return false
+	}
+	if q.PeRequirement != nil && !requirementIntersects(metadata, "pe", q.PeRequirement) {
+		return false
+	}
+	if q.PuppetRequirement != nil && !requirementIntersects(metadata, "puppet", q.PuppetRequirement) {
+		return false
+	}
+	return true
+}
+
+// hasModuleFilters reports whether the query uses filters which need the module of a release
+func (q *ReleaseQuery) hasModuleFilters() bool {
+	return q.HideDeprecated || q.Supported || len(q.ModuleGroups) > 0
+}
+
+// matchesModule reports whether the module of a release passes the module based filters
+func (q *ReleaseQuery) matchesModule(m *gen.Module) bool {
+	if m == nil {
+		return !q.Supported && (len(q.ModuleGroups) == 0 || slices.Contains(q.ModuleGroups, ModuleGroupBase))
+	}
+	if q.HideDeprecated && m.DeprecatedAt != nil {
+		return false
+	}
+	if q.Supported && !m.Supported && (m.Endorsement == nil || *m.Endorsement != "supported") {
+		return false
+	}
+	if len(q.ModuleGroups) > 0 && !slices.Contains(q.ModuleGroups, moduleGroup(m)) {
+		return false
+	}
+	return true
+}
+
+// sortModules sorts the modules by the given sort_by value
+// rank is the default, it prefers modules whose name matches the search query and then ranks by downloads
+func sortModules(modules []*gen.Module, sortBy, query string) {
+	sort.SliceStable(modules, func(i, j int) bool {
+		a, b := modules[i], modules[j]
+		switch sortBy {
+		case "latest_release":
+			if a.UpdatedAt != b.UpdatedAt {
+				return a.UpdatedAt > b.UpdatedAt
+			}
+		case "downloads":
+			if a.Downloads != b.Downloads {
+				return a.Downloads > b.Downloads
+			}
+		default:
+			if rankA, rankB := searchRank(a, query), searchRank(b, query); rankA != rankB {
+				return rankA < rankB
+			}
+			if a.Downloads != b.Downloads {
+				return a.Downloads > b.Downloads
+			}
+		}
+		return a.Slug < b.Slug
+	})
+}
+
+// searchRank returns 0 if the module name equals the query, 1 if it starts with the query and 2 otherwise
+func searchRank(m *gen.Module, query string) int {
+	if query == "" {
+		return 0
+	}
+	name := strings.ToLower(moduleShortName(m.Slug))
+	query = strings.ToLower(query)
+	switch {
+	case name == query:
+		return 0
+	case strings.HasPrefix(name, query):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// sortReleases sorts the releases by the given sort_by value
+func sortReleases(releases []*gen.Release, sortBy string) {
+	sort.SliceStable(releases, func(i, j int) bool {
+		a, b := releases[i], releases[j]
+		switch sortBy {
+		case "downloads":
+			if a.Downloads != b.Downloads {
+				return a.Downloads > b.Downloads
+			}
+		case "release_date":
+			if a.CreatedAt != b.CreatedAt {
+				return a.CreatedAt > b.CreatedAt
+			}
+		case "module":
+			if a.Module.Slug != b.Module.Slug {
+				return a.Module.Slug < b.Module.Slug
+			}
+			if c := compareVersions(a.Version, b.Version); c != 0 {
+				return c > 0
+			}
+		default:
+			if c := compareVersions(a.Version, b.Version); c != 0 {
+				return c > 0
+			}
+		}
+		return a.Slug < b.Slug
+	})
+}
+
+// compareVersions compares two semantic versions, invalid versions are lower than valid ones
+func compareVersions(a, b string) int {
+	versionA, errA := version.NewVersion(a)
+	versionB, errB := version.NewVersion(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	return versionA.Compare(versionB)
+}
+
+// versionKey returns a key sorting the versions like compareVersions, invalid versions are sorted by their string
+func versionKey(raw string) string {
+	v, err := version.NewVersion(raw)
+	if err != nil {
+		// The space is lower than the digits valid keys start with
+		return " " + raw
+	}
+	return utils.VersionKey(v)
+}
+
+// paginate returns the part of items selected by offset and limit
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (s *memoryStore) QueryModules(query *ModuleQuery) ([]*gen.Module, int, error) {
+	s.muModules.RLock()
+	s.muReleases.RLock()
+	filtered := []*gen.Module{}
+	for _, m := range s.Modules {
+		if query.matches(m) && (!query.hasReleaseFilters() || query.matchesReleases(s.Releases[m.Slug])) {
+			filtered = append(filtered, m)
+		}
+	}
+	s.muReleases.RUnlock()
+	s.muModules.RUnlock()
+
+	sortModules(filtered, query.SortBy, query.Query)
+
+	return paginate(filtered, query.Offset, query.Limit), len(filtered), nil
+}
+
+func (s *memoryStore) QueryReleases(query *ReleaseQuery) ([]*gen.Release, int, error) {
+	s.muModules.RLock()
+	s.muReleases.RLock()
+	filtered := []*gen.Release{}
+	for moduleSlug, releases := range s.Releases {
+		if query.hasModuleFilters() && !query.matchesModule(s.Modules[moduleSlug]) {
+			continue
+		}
+		for _, r := range releases {
+			if query.matches(r) {
+				filtered = append(filtered, r)
+			}
+		}
+	}
+	s.muReleases.RUnlock()
+	s.muModules.RUnlock()
+
+	sortReleases(filtered, query.SortBy)
+
+	return paginate(filtered, query.Offset, query.Limit), len(filtered), nil
+}
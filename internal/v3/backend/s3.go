@@ -0,0 +1,763 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dadav/gorge/internal/log"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	s3IndexFile = "index.json"
+	s3Timeout   = 5 * time.Minute
+	// s3UpdateRetries is the number of times a document update is retried after another instance changed it
+	s3UpdateRetries = 10
+	s3UpdateBackoff = 50 * time.Millisecond
+)
+
+// S3Config contains the settings to connect to a s3 compatible object storage
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Insecure  bool
+}
+
+// s3IndexEntry caches the parsed release of a tarball, so it doesn't have to be downloaded on every scan
+type s3IndexEntry struct {
+	ETag    string       `json:"etag"`
+	Release *gen.Release `json:"release"`
+}
+
+// S3Backend implements the Backend interface for s3 compatible object storages
+// Tarballs are stored as <prefix>/<module>/<release>.tar.gz next to an index
+// containing the metadata of all releases
+type S3Backend struct {
+	*memoryStore
+	client  *minio.Client
+	bucket  string
+	prefix  string
+	muIndex sync.Mutex
+	index   map[string]*s3IndexEntry
+	// muUpload serializes uploads, so checking for a known release and storing the tarball is atomic
+	muUpload sync.Mutex
+}
+
+var _ Backend = (*S3Backend)(nil)
+
+func NewS3Backend(cfg S3Config) (*S3Backend, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("s3 endpoint is required")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		log.Log.Infof("Creating bucket %s", cfg.Bucket)
+		// Another instance may create the bucket at the same time
+		err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region})
+		if err != nil && minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", cfg.Bucket, err)
+		}
+	}
+
+	return &S3Backend{
+		memoryStore: newMemoryStore(),
+		client:      client,
+		bucket:      cfg.Bucket,
+		prefix:      strings.Trim(cfg.Prefix, "/"),
+		index:       map[string]*s3IndexEntry{},
+	}, nil
+}
+
+// key returns the object key for the given path elements below the prefix
+func (s *S3Backend) key(elem ...string) string {
+	return path.Join(append([]string{s.prefix}, elem...)...)
+}
+
+// releaseKey returns the object key of the tarball belonging to the release
+// Deleted releases are stored in the trash
+func (s *S3Backend) releaseKey(release *gen.Release) string {
+	if ReleaseDeleted(release) {
+		return s.key(trashDir, release.Module.Slug, fmt.Sprintf("%s%s", release.Slug, tarGzExt))
+	}
+	return s.key(release.Module.Slug, fmt.Sprintf("%s%s", release.Slug, tarGzExt))
+}
+
+// inTrash reports whether the key belongs to the trash
+func (s *S3Backend) inTrash(key string) bool {
+	return strings.HasPrefix(key, s.key(trashDir)+"/")
+}
+
+// loadIndex reads the index from the bucket, a missing index is not an error
+func (s *S3Backend) loadIndex(ctx context.Context) (map[string]*s3IndexEntry, error) {
+	index := map[string]*s3IndexEntry{}
+
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(s3IndexFile), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return index, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		log.Log.Warnf("Ignoring invalid s3 index: %v", err)
+		return map[string]*s3IndexEntry{}, nil
+	}
+
+	return index, nil
+}
+
+// saveIndex writes the index to the bucket, muIndex must be held
+func (s *S3Backend) saveIndex(ctx context.Context) error {
+	data, err := json.Marshal(s.index)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutObject(ctx, s.bucket, s.key(s3IndexFile), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	return err
+}
+
+func (s *S3Backend) AddDownloads(counts map[string]int32) error {
+	return s.addDownloads(counts, s.UpdateDocument)
+}
+
+func (s *S3Backend) ReadDocument(name string) ([]byte, error) {
+	data, _, err := s.readDocument(name)
+	if err == nil && data == nil {
+		return nil, os.ErrNotExist
+	}
+	return data, err
+}
+
+// readDocument returns the content and the etag of the document, both are empty if it doesn't exist
+func (s *S3Backend) readDocument(name string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer obj.Close()
+
+	// Reading first makes the etag belong to the returned content
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+
+	info, err := obj.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	return data, info.ETag, nil
+}
+
+// UpdateDocument writes the document only if it wasn't changed since it was read, otherwise the update is repeated
+func (s *S3Backend) UpdateDocument(name string, update func(current []byte) ([]byte, error)) error {
+	for attempt := 0; ; attempt++ {
+		current, etag, err := s.readDocument(name)
+		if err != nil {
+			return err
+		}
+
+		data, err := update(current)
+		if err != nil {
+			return err
+		}
+
+		opts := minio.PutObjectOptions{ContentType: "application/json"}
+		if etag == "" {
+			opts.SetMatchETagExcept("*")
+		} else {
+			opts.SetMatchETag(etag)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+		_, err = s.client.PutObject(ctx, s.bucket, s.key(name), bytes.NewReader(data), int64(len(data)), opts)
+		cancel()
+		if !s3Conflict(err) || attempt >= s3UpdateRetries {
+			return err
+		}
+		log.Log.Debugf("Document %s was changed concurrently, updating it again", name)
+		// The random delay keeps concurrent writers from colliding again
+		time.Sleep(rand.N(time.Duration(attempt+1) * s3UpdateBackoff))
+	}
+}
+
+// s3Conflict reports whether a conditional write failed because the object was changed
+func s3Conflict(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := minio.ToErrorResponse(err).Code
+	return code == "PreconditionFailed" || code == "ConditionalRequestConflict"
+}
+
+func (s *S3Backend) LoadModules() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	s.muIndex.Lock()
+	defer s.muIndex.Unlock()
+
+	index, err := s.loadIndex(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load s3 index: %w", err)
+	}
+	s.loadDownloads(func() (map[string]int32, error) {
+		return readDownloads(s.ReadDocument(downloadsFile))
+	})
+
+	newIndex := map[string]*s3IndexEntry{}
+	stateKeys := map[string]string{}
+	// reread contains the releases which changed since the last scan, e.g. because another instance overwrote them
+	reread := map[string]bool{}
+	changed := false
+
+	listPrefix := s.prefix
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    listPrefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+
+		if stateSlug, ok := s.moduleStateSlug(obj.Key); ok {
+			stateKeys[stateSlug] = obj.Key
+			continue
+		}
+
+		if !strings.HasSuffix(obj.Key, tarGzExt) {
+			continue
+		}
+
+		// The cached release contains the deletion record, the record is only read if the tarball changed
+
+		if entry, ok := index[obj.Key]; ok && entry.ETag == obj.ETag && entry.Release != nil {
+			newIndex[obj.Key] = entry
+			// Another instance may have overwritten the tarball and updated the index already
+			if seen, ok := s.index[obj.Key]; ok && seen.ETag != obj.ETag {
+				reread[entry.Release.Slug] = true
+			}
+			continue
+		}
+
+		log.Log.Debugf("Reading s3://%s/%s\n", s.bucket, obj.Key)
+		release, err := s.readRelease(ctx, obj)
+		if err != nil {
+			log.Log.Errorf("Failed to read s3://%s/%s: %v", s.bucket, obj.Key, err)
+			continue
+		}
+
+		if s.inTrash(obj.Key) {
+			s.readDeletion(ctx, obj).applyTo(release)
+		}
+
+		newIndex[obj.Key] = &s3IndexEntry{ETag: obj.ETag, Release: release}
+		reread[release.Slug] = true
+		changed = true
+	}
+
+	if len(newIndex) != len(index) {
+		changed = true
+	}
+
+	states := map[string]*ModuleState{}
+	for slug, key := range stateKeys {
+		state, err := s.readModuleState(ctx, key)
+		if err != nil {
+			log.Log.Errorf("Ignoring invalid module state s3://%s/%s: %v", s.bucket, key, err)
+			continue
+		}
+		states[slug] = state
+	}
+	s.replaceModuleStates(states)
+
+	// Drop releases which have been removed from the bucket or changed, the changed ones are inserted again
+	known := map[string]bool{}
+	for _, entry := range newIndex {
+		known[entry.Release.Slug] = true
+	}
+	releases, _ := s.GetAllReleases()
+	for _, release := range releases {
+		if !known[release.Slug] || reread[release.Slug] {
+			s.removeRelease(release.Slug)
+		}
+	}
+
+	for _, entry := range newIndex {
+		s.insertRelease(entry.Release)
+	}
+
+	s.index = newIndex
+	if changed {
+		if err := s.saveIndex(ctx); err != nil {
+			return fmt.Errorf("failed to save s3 index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// readRelease downloads and parses the tarball
+func (s *S3Backend) readRelease(ctx context.Context, info minio.ObjectInfo) (*gen.Release, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, info.Key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReleaseFromBytes(data, info.LastModified)
+}
+
+func (s *S3Backend) AddRelease(upload *Upload) (*gen.Release, error) {
+	release, err := NewReleaseFromUpload(upload, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	s.muUpload.Lock()
+	defer s.muUpload.Unlock()
+
+	return s.addRelease(release, upload)
+}
+
+// addRelease uploads the tarball unless the release is known already, muUpload must be held
+func (s *S3Backend) addRelease(release *gen.Release, upload *Upload) (*gen.Release, error) {
+	// No need to re-upload releases we know of
+	if existing, err := s.GetReleaseBySlug(release.Slug); err == nil {
+		if ReleaseDeleted(existing) {
+			return nil, ErrReleaseDeleted
+		}
+		if err := checkUnchanged(existing, release); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	// Other instances using the bucket may upload the same release concurrently, so the object is only created if it's missing
+	key := s.releaseKey(release)
+	opts := minio.PutObjectOptions{ContentType: "application/gzip"}
+	opts.SetMatchETagExcept("*")
+	info, err := s.client.PutObject(ctx, s.bucket, key, upload.Open(), upload.Size, opts)
+	if s3Conflict(err) {
+		return s.adoptRelease(ctx, key, release)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload release: %w", err)
+	}
+
+	release, _ = s.insertRelease(release)
+
+	s.muIndex.Lock()
+	defer s.muIndex.Unlock()
+	s.index[key] = &s3IndexEntry{ETag: info.ETag, Release: release}
+	if err := s.saveIndex(ctx); err != nil {
+		log.Log.Errorf("Failed to save s3 index: %v", err)
+	}
+
+	return release, nil
+}
+
+// adoptRelease reads the tarball another instance uploaded while the release was added
+// The upload is accepted if it's the same tarball, otherwise ErrReleaseExists is returned
+func (s *S3Backend) adoptRelease(ctx context.Context, key string, uploaded *gen.Release) (*gen.Release, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.readRelease(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, _ = s.insertRelease(existing)
+
+	s.muIndex.Lock()
+	s.index[key] = &s3IndexEntry{ETag: info.ETag, Release: existing}
+	s.muIndex.Unlock()
+
+	if err := checkUnchanged(existing, uploaded); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (s *S3Backend) OverwriteRelease(upload *Upload) (*gen.Release, error) {
+	release, err := NewReleaseFromUpload(upload, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	s.muUpload.Lock()
+	defer s.muUpload.Unlock()
+
+	existing, err := s.GetReleaseBySlug(release.Slug)
+	if err != nil {
+		return s.addRelease(release, upload)
+	}
+	if ReleaseDeleted(existing) {
+		return nil, ErrReleaseDeleted
+	}
+	prepareOverwrite(existing, release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	// PutObject replaces the object atomically
+	key := s.releaseKey(existing)
+	info, err := s.client.PutObject(ctx, s.bucket, key, upload.Open(), upload.Size, minio.PutObjectOptions{
+		ContentType: "application/gzip",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload release: %w", err)
+	}
+
+	if err := s.replaceRelease(release); err != nil {
+		return nil, err
+	}
+
+	s.muIndex.Lock()
+	defer s.muIndex.Unlock()
+	s.index[key] = &s3IndexEntry{ETag: info.ETag, Release: release}
+	if err := s.saveIndex(ctx); err != nil {
+		log.Log.Errorf("Failed to save s3 index: %v", err)
+	}
+
+	return release, nil
+}
+
+func (s *S3Backend) GetReleaseFile(slug string) (*ReleaseFile, error) {
+	release, err := s.GetReleaseBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleted releases can't be downloaded until they are restored
+	if ReleaseDeleted(release) {
+		return nil, os.ErrNotExist
+	}
+
+	// The object is read lazily, so the timeout can't be bound to this call
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.releaseKey(release), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	return &ReleaseFile{
+		ReadSeekCloser: obj,
+		Size:           info.Size,
+		ModTime:        info.LastModified,
+		Sha256:         release.FileSha256,
+	}, nil
+}
+
+func (s *S3Backend) DeleteModuleBySlug(slug, reason string) error {
+	module, err := s.GetModuleBySlug(slug)
+	if err != nil || ModuleDeleted(module) {
+		return os.ErrNotExist
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	s.muIndex.Lock()
+	defer s.muIndex.Unlock()
+
+	deletion := NewDeletion(reason)
+	for _, abbrRelease := range module.Releases {
+		if abbrRelease.DeletedAt != nil {
+			continue
+		}
+		if err := s.moveRelease(ctx, abbrRelease.Slug, deletion); err != nil {
+			return err
+		}
+	}
+
+	return s.saveIndex(ctx)
+}
+
+func (s *S3Backend) DeleteReleaseBySlug(slug, reason string) error {
+	release, err := s.GetReleaseBySlug(slug)
+	if err != nil {
+		return err
+	}
+	if ReleaseDeleted(release) {
+		return os.ErrNotExist
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	s.muIndex.Lock()
+	defer s.muIndex.Unlock()
+
+	if err := s.moveRelease(ctx, slug, NewDeletion(reason)); err != nil {
+		return err
+	}
+
+	return s.saveIndex(ctx)
+}
+
+func (s *S3Backend) RestoreReleaseBySlug(slug string) (*gen.Release, error) {
+	release, err := s.GetReleaseBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if !ReleaseDeleted(release) {
+		return nil, ErrReleaseNotDeleted
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	s.muIndex.Lock()
+	defer s.muIndex.Unlock()
+
+	if err := s.moveRelease(ctx, slug, nil); err != nil {
+		return nil, err
+	}
+
+	return release, s.saveIndex(ctx)
+}
+
+// moveRelease copies the tarball of the release into the trash, a nil deletion restores it
+// muIndex must be held, the index has to be saved by the caller
+func (s *S3Backend) moveRelease(ctx context.Context, slug string, deletion *Deletion) error {
+	release, err := s.GetReleaseBySlug(slug)
+	if err != nil {
+		return err
+	}
+
+	from := s.releaseKey(release)
+	moved := *release
+	deletion.applyTo(&moved)
+	to := s.releaseKey(&moved)
+
+	if deletion != nil {
+		data, err := json.Marshal(deletion)
+		if err != nil {
+			return err
+		}
+		_, err = s.client.PutObject(ctx, s.bucket, deletionPath(to), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+			ContentType: "application/json",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	info, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: to},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: from},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", from, to, err)
+	}
+
+	if err := s.client.RemoveObject(ctx, s.bucket, from, minio.RemoveObjectOptions{}); err != nil {
+		return err
+	}
+	if deletion == nil {
+		if err := s.client.RemoveObject(ctx, s.bucket, deletionPath(from), minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+
+	release, err = s.setReleaseDeletion(slug, deletion)
+	if err != nil {
+		return err
+	}
+
+	delete(s.index, from)
+	s.index[to] = &s3IndexEntry{ETag: info.ETag, Release: release}
+
+	return nil
+}
+
+func (s *S3Backend) PurgeDeletedReleases(deletedBefore time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	s.muIndex.Lock()
+	defer s.muIndex.Unlock()
+
+	purged := 0
+	for _, release := range s.deletedReleases(deletedBefore) {
+		key := s.releaseKey(release)
+		if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			return purged, err
+		}
+		if err := s.client.RemoveObject(ctx, s.bucket, deletionPath(key), minio.RemoveObjectOptions{}); err != nil {
+			return purged, err
+		}
+		delete(s.index, key)
+		s.removeRelease(release.Slug)
+		purged++
+
+		// The state of modules without any releases left is dropped as well
+		if _, err := s.GetModuleBySlug(release.Module.Slug); err != nil {
+			if err := s.client.RemoveObject(ctx, s.bucket, s.key(release.Module.Slug+moduleStateExt), minio.RemoveObjectOptions{}); err != nil {
+				return purged, err
+			}
+			s.deleteModuleState(release.Module.Slug)
+		}
+	}
+
+	if purged == 0 {
+		return 0, nil
+	}
+
+	return purged, s.saveIndex(ctx)
+}
+
+// readDeletion downloads the deletion record of the trashed tarball
+// Without a readable record the release counts as deleted at the time the tarball was modified
+func (s *S3Backend) readDeletion(ctx context.Context, info minio.ObjectInfo) *Deletion {
+	deletion := &Deletion{DeletedAt: info.LastModified.UTC().Format(time.RFC3339)}
+
+	obj, err := s.client.GetObject(ctx, s.bucket, deletionPath(info.Key), minio.GetObjectOptions{})
+	if err != nil {
+		return deletion
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			log.Log.Errorf("Failed to read deletion record of s3://%s/%s: %v", s.bucket, info.Key, err)
+		}
+		return deletion
+	}
+
+	if err := json.Unmarshal(data, deletion); err != nil {
+		log.Log.Errorf("Ignoring invalid deletion record of s3://%s/%s: %v", s.bucket, info.Key, err)
+		return &Deletion{DeletedAt: info.LastModified.UTC().Format(time.RFC3339)}
+	}
+
+	return deletion
+}
+
+// moduleStateSlug returns the module slug if key is the state of a module (<prefix>/<slug>.json)
+// Dot-files like the downloads or search filters are documents, not module states
+func (s *S3Backend) moduleStateSlug(key string) (string, bool) {
+	dir, name := path.Split(key)
+	if strings.TrimSuffix(dir, "/") != s.prefix || name == s3IndexFile || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, moduleStateExt) {
+		return "", false
+	}
+	return strings.TrimSuffix(name, moduleStateExt), true
+}
+
+// readModuleState downloads the state of a module
+func (s *S3Backend) readModuleState(ctx context.Context, key string) (*ModuleState, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var state ModuleState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func (s *S3Backend) UpdateModule(module *gen.Module) error {
+	state := ModuleStateOf(module)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	_, err = s.client.PutObject(ctx, s.bucket, s.key(module.Slug+moduleStateExt), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return err
+	}
+
+	s.setModuleState(module.Slug, state)
+
+	return nil
+}
@@ -0,0 +1,494 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeS3Object struct {
+	data     []byte
+	etag     string
+	modified time.Time
+}
+
+// fakeS3 is an in-memory s3 server implementing the requests of the minio client used by the S3Backend
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]*fakeS3Object
+	// beforePut is called before an object is written, e.g. to simulate a concurrent writer
+	beforePut func(key string)
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	t.Helper()
+
+	fake := &fakeS3{buckets: map[string]map[string]*fakeS3Object{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+// put stores the object without any conditions
+func (f *fakeS3) put(bucket, key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.buckets[bucket] == nil {
+		f.buckets[bucket] = map[string]*fakeS3Object{}
+	}
+	f.buckets[bucket][key] = &fakeS3Object{
+		data:     data,
+		etag:     fmt.Sprintf("%x", md5.Sum(data)),
+		modified: time.Now().UTC(),
+	}
+}
+
+// get returns the content of the object, nil if it doesn't exist
+func (f *fakeS3) get(bucket, key string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if obj, ok := f.buckets[bucket][key]; ok {
+		return obj.data
+	}
+	return nil
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if key == "" {
+		f.serveBucket(w, r, bucket)
+		return
+	}
+
+	// The content is read before locking, because the hook may write objects itself
+	var data []byte
+	if r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") == "" {
+		var err error
+		data, err = readS3Payload(r)
+		if err != nil {
+			f.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		if f.beforePut != nil {
+			f.beforePut(key)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects, ok := f.buckets[bucket]
+	if !ok {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	obj := objects[key]
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if obj == nil {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", strconv.Quote(obj.etag))
+		w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			source, _ = url.PathUnescape(source)
+			srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+			src, ok := f.buckets[srcBucket][srcKey]
+			if !ok {
+				f.error(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			copied := *src
+			copied.modified = time.Now().UTC()
+			objects[key] = &copied
+			fmt.Fprintf(w, "<CopyObjectResult><ETag>%q</ETag><LastModified>%s</LastModified></CopyObjectResult>",
+				copied.etag, copied.modified.Format("2006-01-02T15:04:05.000Z"))
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == "*" && obj != nil {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && (obj == nil || strings.Trim(match, `"`) != obj.etag) {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+
+		obj = &fakeS3Object{data: data, etag: fmt.Sprintf("%x", md5.Sum(data)), modified: time.Now().UTC()}
+		objects[key] = obj
+		w.Header().Set("ETag", strconv.Quote(obj.etag))
+
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		f.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+type fakeS3Contents struct {
+	Key          string
+	ETag         string
+	Size         int
+	LastModified string
+}
+
+type fakeS3ListResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	IsTruncated bool
+	Contents    []fakeS3Contents
+}
+
+func (f *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects, exists := f.buckets[bucket]
+
+	switch r.Method {
+	case http.MethodHead:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+	case http.MethodPut:
+		if exists {
+			f.error(w, http.StatusConflict, "BucketAlreadyOwnedByYou")
+			return
+		}
+		f.buckets[bucket] = map[string]*fakeS3Object{}
+
+	case http.MethodGet:
+		if !exists {
+			f.error(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+
+		// All objects are returned on a single page
+		prefix := r.URL.Query().Get("prefix")
+		result := fakeS3ListResult{Name: bucket, Prefix: prefix}
+		for key, obj := range objects {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			result.Contents = append(result.Contents, fakeS3Contents{
+				Key:          key,
+				ETag:         strconv.Quote(obj.etag),
+				Size:         len(obj.data),
+				LastModified: obj.modified.Format("2006-01-02T15:04:05.000Z"),
+			})
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		result.KeyCount = len(result.Contents)
+
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+
+	default:
+		f.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// readS3Payload returns the body of the request, decoding the chunks of streaming signatures
+func readS3Payload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	// Each chunk is sent as "<hex size>;chunk-signature=<signature>\r\n<data>\r\n", the last one is empty
+	var data []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+const testBucket = "gorge"
+
+func newTestS3Backend(t *testing.T, server *httptest.Server, prefix string) *S3Backend {
+	t.Helper()
+
+	s, err := NewS3Backend(S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Bucket:    testBucket,
+		Prefix:    prefix,
+		Region:    "us-east-1",
+		AccessKey: "test",
+		SecretKey: "test",
+		Insecure:  true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create the s3 backend: %v", err)
+	}
+	return s
+}
+
+func TestS3BackendAddRelease(t *testing.T) {
+	fake, server := newFakeS3(t)
+	s := newTestS3Backend(t, server, "modules")
+
+	tarball := releaseTarball(t, "acme-foo", "1.0.0")
+	release, err := s.AddRelease(NewUploadFromBytes(tarball))
+	if err != nil {
+		t.Fatalf("failed to add release: %v", err)
+	}
+	if release.Slug != "acme-foo-1.0.0" {
+		t.Errorf("expected the release acme-foo-1.0.0, got %s", release.Slug)
+	}
+
+	if stored := fake.get(testBucket, "modules/acme-foo/acme-foo-1.0.0.tar.gz"); !bytes.Equal(stored, tarball) {
+		t.Error("expected the tarball to be stored below the prefix")
+	}
+	index := map[string]*s3IndexEntry{}
+	if err := json.Unmarshal(fake.get(testBucket, "modules/"+s3IndexFile), &index); err != nil {
+		t.Fatalf("failed to read the index: %v", err)
+	}
+	if entry, ok := index["modules/acme-foo/acme-foo-1.0.0.tar.gz"]; !ok || entry.Release.Slug != release.Slug {
+		t.Errorf("expected the release in the index, got %+v", index)
+	}
+
+	// The same tarball is accepted again, a different one is refused
+	if _, err := s.AddRelease(NewUploadFromBytes(tarball)); err != nil {
+		t.Errorf("expected the same tarball to be accepted, got %v", err)
+	}
+	changed := releaseTarballWithSummary(t, "acme-foo", "1.0.0", "changed")
+	if _, err := s.AddRelease(NewUploadFromBytes(changed)); !errors.Is(err, ErrReleaseExists) {
+		t.Errorf("expected ErrReleaseExists, got %v", err)
+	}
+	if stored := fake.get(testBucket, "modules/acme-foo/acme-foo-1.0.0.tar.gz"); !bytes.Equal(stored, tarball) {
+		t.Error("expected the stored tarball to be unchanged")
+	}
+}
+
+func TestS3BackendLoadModules(t *testing.T) {
+	fake, server := newFakeS3(t)
+	s := newTestS3Backend(t, server, "")
+
+	fake.put(testBucket, "acme-foo/acme-foo-1.0.0.tar.gz", releaseTarball(t, "acme-foo", "1.0.0"))
+	fake.put(testBucket, "acme-foo/acme-foo-1.2.0.tar.gz", releaseTarball(t, "acme-foo", "1.2.0"))
+	fake.put(testBucket, ".trash/acme-bar/acme-bar-0.1.0.tar.gz", releaseTarball(t, "acme-bar", "0.1.0"))
+	fake.put(testBucket, ".trash/acme-bar/acme-bar-0.1.0.json", []byte(`{"deleted_at":"2024-01-01T00:00:00Z","deleted_for":"broken"}`))
+	fake.put(testBucket, "acme-foo.json", []byte(`{"module_group":"pe_only"}`))
+	fake.put(testBucket, ".downloads.json", []byte(`{"acme-foo-1.0.0":5}`))
+	fake.put(testBucket, ".search_filters.json", []byte(`{"next_id":1,"filters":[]}`))
+	fake.put(testBucket, "acme-foo/broken.tar.gz", []byte("broken"))
+
+	if err := s.LoadModules(); err != nil {
+		t.Fatalf("failed to load modules: %v", err)
+	}
+
+	releases, err := s.GetAllReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := releaseSlugs(releases), []string{"acme-bar-0.1.0", "acme-foo-1.0.0", "acme-foo-1.2.0"}; !slices.Equal(got, want) {
+		t.Errorf("expected releases %v, got %v", want, got)
+	}
+
+	deleted, err := s.GetReleaseBySlug("acme-bar-0.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ReleaseDeleted(deleted) || deleted.DeletedFor == nil || *deleted.DeletedFor != "broken" {
+		t.Errorf("expected the release of the trash to be deleted for broken, got %+v", deleted)
+	}
+
+	release, err := s.GetReleaseBySlug("acme-foo-1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Downloads != 5 {
+		t.Errorf("expected 5 downloads, got %d", release.Downloads)
+	}
+
+	module, err := s.GetModuleBySlug("acme-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ModuleStateOf(module).ModuleGroup != "pe_only" {
+		t.Errorf("expected the module group of the module state, got %+v", ModuleStateOf(module))
+	}
+	if _, err := s.GetModuleBySlug(".search_filters"); err == nil {
+		t.Error("expected documents not to be read as module states")
+	}
+
+	// Another instance replaces a tarball, the next scan reads it again
+	changed := releaseTarballWithSummary(t, "acme-foo", "1.2.0", "changed")
+	fake.put(testBucket, "acme-foo/acme-foo-1.2.0.tar.gz", changed)
+	if err := s.LoadModules(); err != nil {
+		t.Fatalf("failed to load modules: %v", err)
+	}
+	release, err = s.GetReleaseBySlug("acme-foo-1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.FileSha256 != NewUploadFromBytes(changed).Sha256 {
+		t.Error("expected the replaced tarball to be read again")
+	}
+
+	// A new instance uses the index of the first one
+	other := newTestS3Backend(t, server, "")
+	if err := other.LoadModules(); err != nil {
+		t.Fatalf("failed to load modules: %v", err)
+	}
+	releases, err = other.GetAllReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := releaseSlugs(releases), []string{"acme-bar-0.1.0", "acme-foo-1.0.0", "acme-foo-1.2.0"}; !slices.Equal(got, want) {
+		t.Errorf("expected releases %v, got %v", want, got)
+	}
+}
+
+func TestS3BackendUpdateDocumentConflict(t *testing.T) {
+	fake, server := newFakeS3(t)
+	s := newTestS3Backend(t, server, "")
+
+	fake.put(testBucket, "counter.json", []byte("1"))
+
+	// Another instance changes the document once between reading and writing it
+	var once sync.Once
+	fake.beforePut = func(key string) {
+		if key == "counter.json" {
+			once.Do(func() { fake.put(testBucket, key, []byte("10")) })
+		}
+	}
+
+	calls := 0
+	err := s.UpdateDocument("counter.json", func(current []byte) ([]byte, error) {
+		calls++
+		value, err := strconv.Atoi(string(current))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(value + 1)), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update the document: %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("expected the update to be repeated once, got %d calls", calls)
+	}
+	if got := string(fake.get(testBucket, "counter.json")); got != "11" {
+		t.Errorf("expected the concurrent change to be kept, got %s", got)
+	}
+
+	// Missing documents are only created if nobody else created them meanwhile
+	once = sync.Once{}
+	fake.beforePut = func(key string) {
+		if key == "new.json" {
+			once.Do(func() { fake.put(testBucket, key, []byte("a")) })
+		}
+	}
+	err = s.UpdateDocument("new.json", func(current []byte) ([]byte, error) {
+		return append(current, 'b'), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update the document: %v", err)
+	}
+	if got := string(fake.get(testBucket, "new.json")); got != "ab" {
+		t.Errorf("expected the concurrently created document to be updated, got %s", got)
+	}
+
+	// Failed updates don't write anything
+	updateErr := errors.New("invalid")
+	err = s.UpdateDocument("counter.json", func([]byte) ([]byte, error) { return nil, updateErr })
+	if !errors.Is(err, updateErr) {
+		t.Errorf("expected the error of the update, got %v", err)
+	}
+	if got := string(fake.get(testBucket, "counter.json")); got != "11" {
+		t.Errorf("expected the document to be unchanged, got %s", got)
+	}
+}
+
+func TestS3BackendAdoptRelease(t *testing.T) {
+	_, server := newFakeS3(t)
+
+	tarball := releaseTarball(t, "acme-foo", "1.0.0")
+	first := newTestS3Backend(t, server, "")
+	if _, err := first.AddRelease(NewUploadFromBytes(tarball)); err != nil {
+		t.Fatalf("failed to add release: %v", err)
+	}
+
+	// The second instance didn't scan the bucket yet, so it only notices the release while uploading it
+	second := newTestS3Backend(t, server, "")
+	release, err := second.AddRelease(NewUploadFromBytes(tarball))
+	if err != nil {
+		t.Fatalf("expected the same tarball to be adopted, got %v", err)
+	}
+	if release.FileSha256 != NewUploadFromBytes(tarball).Sha256 {
+		t.Errorf("expected the adopted release to describe the stored tarball")
+	}
+	if _, err := second.GetReleaseBySlug("acme-foo-1.0.0"); err != nil {
+		t.Errorf("expected the adopted release to be known, got %v", err)
+	}
+
+	third := newTestS3Backend(t, server, "")
+	changed := releaseTarballWithSummary(t, "acme-foo", "1.0.0", "changed")
+	if _, err := third.AddRelease(NewUploadFromBytes(changed)); !errors.Is(err, ErrReleaseExists) {
+		t.Errorf("expected ErrReleaseExists, got %v", err)
+	}
+	// The stored release is known afterwards, even though the upload was refused
+	existing, err := third.GetReleaseBySlug("acme-foo-1.0.0")
+	if err != nil {
+		t.Fatalf("expected the stored release to be known, got %v", err)
+	}
+	if existing.FileSha256 != NewUploadFromBytes(tarball).Sha256 {
+		t.Errorf("expected the stored release, got the sha256 %s", existing.FileSha256)
+	}
+}
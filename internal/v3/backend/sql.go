@@ -0,0 +1,1434 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/model"
+	"github.com/dadav/gorge/internal/v3/utils"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	SQLDriverSqlite   = "sqlite"
+	SQLDriverPostgres = "postgres"
+
+	sqliteDatabaseFile = ".gorge.db"
+)
+
+// sqlSchema creates the tables of the metadata index
+// Only types understood by both sqlite and postgres are used
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS modules (
+		slug TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		owner TEXT NOT NULL,
+		current_release TEXT NOT NULL,
+		latest_release TEXT NOT NULL DEFAULT '',
+		downloads BIGINT NOT NULL DEFAULT 0,
+		premium BOOLEAN NOT NULL DEFAULT FALSE,
+		endorsement TEXT,
+		supported BOOLEAN NOT NULL DEFAULT FALSE,
+		deprecated BOOLEAN NOT NULL DEFAULT FALSE,
+		module_group TEXT NOT NULL DEFAULT 'base',
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS modules_owner ON modules (owner)`,
+	`CREATE INDEX IF NOT EXISTS modules_updated_at ON modules (updated_at)`,
+	`CREATE INDEX IF NOT EXISTS modules_downloads ON modules (downloads)`,
+	`CREATE TABLE IF NOT EXISTS releases (
+		slug TEXT PRIMARY KEY,
+		module TEXT NOT NULL,
+		owner TEXT NOT NULL,
+		version TEXT NOT NULL,
+		version_key TEXT NOT NULL,
+		file_path TEXT NOT NULL,
+		file_size BIGINT NOT NULL,
+		file_mtime BIGINT NOT NULL,
+		file_md5 TEXT NOT NULL,
+		file_sha256 TEXT NOT NULL,
+		downloads BIGINT NOT NULL DEFAULT 0,
+		has_tasks BOOLEAN NOT NULL,
+		has_plans BOOLEAN NOT NULL,
+		pdk BOOLEAN NOT NULL,
+		validation_score BIGINT NOT NULL DEFAULT 0,
+		created_at TEXT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS releases_module ON releases (module)`,
+	`CREATE INDEX IF NOT EXISTS releases_owner ON releases (owner)`,
+	`CREATE INDEX IF NOT EXISTS releases_created_at ON releases (created_at)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS releases_file_path ON releases (file_path)`,
+	`CREATE TABLE IF NOT EXISTS release_tags (
+		release_slug TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (release_slug, tag)
+	)`,
+	`CREATE INDEX IF NOT EXISTS release_tags_tag ON release_tags (tag)`,
+	`CREATE TABLE IF NOT EXISTS release_dependencies (
+		release_slug TEXT NOT NULL,
+		name TEXT NOT NULL,
+		version_requirement TEXT NOT NULL,
+		PRIMARY KEY (release_slug, name)
+	)`,
+	`CREATE INDEX IF NOT EXISTS release_dependencies_name ON release_dependencies (name)`,
+	`CREATE TABLE IF NOT EXISTS release_operatingsystems (
+		release_slug TEXT NOT NULL,
+		operatingsystem TEXT NOT NULL,
+		operatingsystemrelease TEXT NOT NULL,
+		PRIMARY KEY (release_slug, operatingsystem, operatingsystemrelease)
+	)`,
+	`CREATE INDEX IF NOT EXISTS release_operatingsystems_os ON release_operatingsystems (operatingsystem)`,
+	// Every interval of a requirement range is a row, a NULL key is unbounded
+	`CREATE TABLE IF NOT EXISTS release_requirements (
+		release_slug TEXT NOT NULL,
+		name TEXT NOT NULL,
+		lower_key TEXT,
+		lower_inclusive BOOLEAN NOT NULL,
+		upper_key TEXT,
+		upper_inclusive BOOLEAN NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS release_requirements_release_slug ON release_requirements (release_slug)`,
+	`CREATE TABLE IF NOT EXISTS module_states (
+		slug TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS release_deletions (
+		release_slug TEXT PRIMARY KEY,
+		deleted_at TEXT NOT NULL
+	)`,
+	// Download counters survive rescans of the tarballs, so they aren't a child table of releases
+	`CREATE TABLE IF NOT EXISTS release_downloads (
+		release_slug TEXT PRIMARY KEY,
+		downloads BIGINT NOT NULL
+	)`,
+	// Small json documents shared by all instances, like the saved search filters
+	`CREATE TABLE IF NOT EXISTS documents (
+		name TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+}
+
+// releaseChildTables contain rows referencing a release by its slug
+var releaseChildTables = []string{"release_tags", "release_dependencies", "release_operatingsystems", "release_requirements", "release_deletions"}
+
+// SQLConfig contains the settings of the database used by the sql backend
+type SQLConfig struct {
+	Driver     string
+	DSN        string
+	ModulesDir string
+}
+
+// SQLBackend implements the Backend interface with the metadata of all releases indexed
+// in a sqlite or postgres database. The tarballs stay on disk in ModulesDir, using the
+// same layout as the filesystem backend.
+type SQLBackend struct {
+	db         *sql.DB
+	postgres   bool
+	ModulesDir string
+	// mu serializes writes, so modules are rebuilt from a consistent set of releases
+	mu sync.Mutex
+	// muScan serializes scans
+	muScan sync.Mutex
+	// ignored contains the tarballs which couldn't be read or whose release is provided by another file,
+	// they are only read again once they change or the other file is gone
+	ignored map[string]scannedFile
+}
+
+var _ Backend = (*SQLBackend)(nil)
+
+func NewSQLBackend(cfg SQLConfig) (*SQLBackend, error) {
+	var driverName, dsn string
+
+	switch cfg.Driver {
+	case SQLDriverSqlite:
+		driverName = "sqlite"
+		dsn = cfg.DSN
+		if dsn == "" {
+			dsn = filepath.Join(cfg.ModulesDir, sqliteDatabaseFile)
+		}
+		if !strings.Contains(dsn, "?") {
+			dsn += "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+		}
+	case SQLDriverPostgres:
+		driverName = "pgx"
+		dsn = cfg.DSN
+		if dsn == "" {
+			return nil, errors.New("a dsn is required for postgres")
+		}
+	default:
+		return nil, fmt.Errorf("invalid database driver: %s", cfg.Driver)
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if cfg.Driver == SQLDriverSqlite {
+		// sqlite only allows a single writer
+		db.SetMaxOpenConns(1)
+	}
+
+	for _, stmt := range sqlSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create database schema: %w", err)
+		}
+	}
+
+	return &SQLBackend{
+		db:         db,
+		postgres:   cfg.Driver == SQLDriverPostgres,
+		ModulesDir: cfg.ModulesDir,
+		ignored:    map[string]scannedFile{},
+	}, nil
+}
+
+// binary makes the database compare the column by bytes instead of the collation of the database
+func (s *SQLBackend) binary(column string) string {
+	if s.postgres {
+		return column + ` COLLATE "C"`
+	}
+	return column
+}
+
+// rebind replaces the ? placeholders with $n if postgres is used
+func (s *SQLBackend) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// withTx runs fn in a transaction which is committed if fn returns no error
+func (s *SQLBackend) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// releaseFilePath returns the path of the tarball belonging to the release
+func (s *SQLBackend) releaseFilePath(release *gen.Release) string {
+	return releasePath(s.ModulesDir, release)
+}
+
+// releaseMetadata decodes the metadata.json stored in the release
+func releaseMetadata(release *gen.Release) (*model.ReleaseMetadata, error) {
+	data, err := json.Marshal(release.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata model.ReleaseMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+// deleteRelease removes the release and all rows referencing it
+func (s *SQLBackend) deleteRelease(tx *sql.Tx, slug string) error {
+	for _, table := range releaseChildTables {
+		if _, err := tx.Exec(s.rebind(fmt.Sprintf("DELETE FROM %s WHERE release_slug = ?", table)), slug); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(s.rebind("DELETE FROM releases WHERE slug = ?"), slug)
+	return err
+}
+
+// upsertRelease writes the release together with its tags, dependencies and supported operating systems
+func (s *SQLBackend) upsertRelease(tx *sql.Tx, release *gen.Release, path string, state fileState) error {
+	if err := s.deleteRelease(tx, release.Slug); err != nil {
+		return err
+	}
+
+	err := tx.QueryRow(s.rebind("SELECT downloads FROM release_downloads WHERE release_slug = ?"), release.Slug).Scan(&release.Downloads)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	data, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.rebind(`INSERT INTO releases
+		(slug, module, owner, version, file_path, file_size, file_mtime, file_md5, file_sha256, downloads, has_tasks, has_plans, pdk, created_at, data,
+		version_key, validation_score)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		release.Slug, release.Module.Slug, release.Module.Owner.Slug, release.Version,
+		path, state.size, state.mtime, release.FileMd5, release.FileSha256, release.Downloads,
+		len(release.Tasks) > 0, len(release.Plans) > 0, release.Pdk, release.CreatedAt, string(data),
+		versionKey(release.Version), release.ValidationScore,
+	)
+	if err != nil {
+		return err
+	}
+
+	if release.DeletedAt != nil {
+		if _, err := tx.Exec(s.rebind("INSERT INTO release_deletions (release_slug, deleted_at) VALUES (?, ?)"), release.Slug, *release.DeletedAt); err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range release.Tags {
+		if _, err := tx.Exec(s.rebind(`INSERT INTO release_tags (release_slug, tag) VALUES (?, ?)
+			ON CONFLICT DO NOTHING`), release.Slug, tag); err != nil {
+			return err
+		}
+	}
+
+	metadata, err := releaseMetadata(release)
+	if err != nil {
+		return err
+	}
+
+	for _, dep := range metadata.Dependencies {
+		if _, err := tx.Exec(s.rebind(`INSERT INTO release_dependencies (release_slug, name, version_requirement) VALUES (?, ?, ?)
+			ON CONFLICT DO NOTHING`), release.Slug, strings.ReplaceAll(dep.Name, "/", "-"), dep.VersionRequirement); err != nil {
+			return err
+		}
+	}
+
+	for _, requirement := range metadata.Requirements {
+		// Invalid ranges never match, like in the other backends
+		requirementRange, err := utils.ParseVersionRange(requirement.VersionRequirement)
+		if err != nil {
+			continue
+		}
+		for _, interval := range requirementRange.Intervals() {
+			var lowerKey, upperKey *string
+			var lowerInclusive, upperInclusive bool
+			if interval.Lower != nil {
+				key := utils.VersionKey(interval.Lower.Version)
+				lowerKey, lowerInclusive = &key, interval.Lower.Inclusive
+			}
+			if interval.Upper != nil {
+				key := utils.VersionKey(interval.Upper.Version)
+				upperKey, upperInclusive = &key, interval.Upper.Inclusive
+			}
+			if _, err := tx.Exec(s.rebind(`INSERT INTO release_requirements
+				(release_slug, name, lower_key, lower_inclusive, upper_key, upper_inclusive) VALUES (?, ?, ?, ?, ?, ?)`),
+				release.Slug, strings.ToLower(requirement.Name), lowerKey, lowerInclusive, upperKey, upperInclusive); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, supported := range metadata.OperatingsystemSupport {
+		osReleases := supported.Releases
+		if len(osReleases) == 0 {
+			osReleases = []string{""}
+		}
+		for _, osRelease := range osReleases {
+			if _, err := tx.Exec(s.rebind(`INSERT INTO release_operatingsystems (release_slug, operatingsystem, operatingsystemrelease) VALUES (?, ?, ?)
+				ON CONFLICT DO NOTHING`), release.Slug, supported.Name, osRelease); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebuildModule recomputes the module from its releases
+// Fields set via UpdateModule are kept, the module is removed if it has no releases left
+func (s *SQLBackend) rebuildModule(tx *sql.Tx, slug string) error {
+	releases, err := s.queryReleases(tx, "SELECT data FROM releases WHERE module = ? ORDER BY created_at, slug", slug)
+	if err != nil {
+		return err
+	}
+
+	if len(releases) == 0 {
+		_, err := tx.Exec(s.rebind("DELETE FROM modules WHERE slug = ?"), slug)
+		return err
+	}
+
+	var module *gen.Module
+	modules, err := s.queryModules(tx, "SELECT data FROM modules WHERE slug = ?", slug)
+	if err != nil {
+		return err
+	}
+	if len(modules) > 0 {
+		module = modules[0]
+	} else {
+		module = ModuleFromRelease(releases[0])
+
+		var data string
+		err := tx.QueryRow(s.rebind("SELECT data FROM module_states WHERE slug = ?"), slug).Scan(&data)
+		switch {
+		case err == nil:
+			var state ModuleState
+			if err := json.Unmarshal([]byte(data), &state); err != nil {
+				return err
+			}
+			state.applyTo(module)
+		case !errors.Is(err, sql.ErrNoRows):
+			return err
+		}
+	}
+
+	module.Releases = make([]gen.ReleaseAbbreviated, 0, len(releases))
+	module.CreatedAt = releases[0].CreatedAt
+	module.UpdatedAt = releases[0].CreatedAt
+	for _, release := range releases {
+		module.Releases = append(module.Releases, *ReleaseToAbbreviatedRelease(release))
+		if release.CreatedAt < module.CreatedAt {
+			module.CreatedAt = release.CreatedAt
+		}
+		if release.CreatedAt > module.UpdatedAt {
+			module.UpdatedAt = release.CreatedAt
+		}
+	}
+
+	module.CurrentRelease = gen.ModuleCurrentRelease(*currentRelease(releases))
+	module.Downloads = moduleDownloads(releases)
+
+	if err := s.upsertModule(tx, module); err != nil {
+		return err
+	}
+
+	// The latest release is used by the only_latest filter, it's kept when the module is updated
+	available := slices.DeleteFunc(releases, ReleaseDeleted)
+	latest := ""
+	if release := latestStableRelease(available); release != nil {
+		latest = release.Slug
+	}
+	_, err = tx.Exec(s.rebind("UPDATE modules SET latest_release = ? WHERE slug = ?"), latest, slug)
+	return err
+}
+
+// upsertModule writes the module row
+func (s *SQLBackend) upsertModule(tx *sql.Tx, module *gen.Module) error {
+	data, err := json.Marshal(module)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.rebind(`INSERT INTO modules
+		(slug, owner, current_release, downloads, premium, endorsement, created_at, updated_at, data,
+		name, deprecated, supported, module_group)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (slug) DO UPDATE SET
+			owner = excluded.owner,
+			current_release = excluded.current_release,
+			downloads = excluded.downloads,
+			premium = excluded.premium,
+			endorsement = excluded.endorsement,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			data = excluded.data,
+			name = excluded.name,
+			deprecated = excluded.deprecated,
+			supported = excluded.supported,
+			module_group = excluded.module_group`),
+		module.Slug, module.Owner.Slug, module.CurrentRelease.Slug, module.Downloads, module.Premium,
+		module.Endorsement, module.CreatedAt, module.UpdatedAt, string(data),
+		strings.ToLower(moduleShortName(module.Slug)), module.DeprecatedAt != nil,
+		module.Supported || module.Endorsement != nil && *module.Endorsement == "supported", moduleGroup(module),
+	)
+	return err
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// queryModules runs a query selecting the data column of modules
+func (s *SQLBackend) queryModules(q queryer, query string, args ...any) ([]*gen.Module, error) {
+	rows, err := q.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*gen.Module{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var module gen.Module
+		if err := json.Unmarshal([]byte(data), &module); err != nil {
+			return nil, err
+		}
+		result = append(result, &module)
+	}
+
+	return result, rows.Err()
+}
+
+// queryReleases runs a query selecting the data column of releases
+func (s *SQLBackend) queryReleases(q queryer, query string, args ...any) ([]*gen.Release, error) {
+	rows, err := q.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*gen.Release{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var release gen.Release
+		if err := json.Unmarshal([]byte(data), &release); err != nil {
+			return nil, err
+		}
+		result = append(result, &release)
+	}
+
+	return result, rows.Err()
+}
+
+// count runs a query selecting a single number
+func (s *SQLBackend) count(query string, args ...any) (int, error) {
+	var n int
+	err := s.db.QueryRow(s.rebind(query), args...).Scan(&n)
+	return n, err
+}
+
+// pagination returns the LIMIT/OFFSET clause, a limit <= 0 means no limit
+func (s *SQLBackend) pagination(limit, offset int) (string, []any) {
+	if limit > 0 {
+		return " LIMIT ? OFFSET ?", []any{limit, offset}
+	}
+	if s.postgres {
+		return " OFFSET ?", []any{offset}
+	}
+	return " LIMIT -1 OFFSET ?", []any{offset}
+}
+
+// likePattern escapes the value for the use in a LIKE '%value%' condition
+func likePattern(value string) string {
+	return "%" + escapeLike(value) + "%"
+}
+
+// likePrefix escapes the value for the use in a LIKE 'value%' condition
+func likePrefix(value string) string {
+	return escapeLike(value) + "%"
+}
+
+func escapeLike(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(value)
+}
+
+func (s *SQLBackend) LoadModules() error {
+	start := time.Now()
+
+	s.muScan.Lock()
+	defer s.muScan.Unlock()
+
+	rows, err := s.db.Query("SELECT slug, file_path, file_size, file_mtime FROM releases")
+	if err != nil {
+		return err
+	}
+	indexed := map[string]scannedFile{}
+	for rows.Next() {
+		var slug, path string
+		var state fileState
+		if err := rows.Scan(&slug, &path, &state.size, &state.mtime); err != nil {
+			rows.Close()
+			return err
+		}
+		indexed[path] = scannedFile{state: state, slug: slug}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	// pending contains the new and changed tarballs in the order they were found
+	var pending []string
+	infos := map[string]os.FileInfo{}
+
+	err = filepath.Walk(s.ModulesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories and non-tar.gz files
+		if info.IsDir() || !strings.HasSuffix(info.Name(), tarGzExt) {
+			return nil
+		}
+
+		seen[path] = true
+		// Inodes aren't stored in the database
+		state := fileState{size: info.Size(), mtime: info.ModTime().UnixNano()}
+		if file, ok := indexed[path]; ok && file.state == state {
+			return nil
+		}
+		if file, ok := s.ignored[path]; ok && file.state == state {
+			return nil
+		}
+		pending = append(pending, path)
+		infos[path] = info
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The releases of removed and changed files are dropped before the new ones are indexed
+	freed := map[string]bool{}
+	for path, file := range indexed {
+		if !seen[path] || infos[path] != nil {
+			freed[file.slug] = true
+		}
+	}
+
+	// Unchanged files which lost against a removed file provide the release now
+	for path, file := range s.ignored {
+		if !seen[path] {
+			delete(s.ignored, path)
+			continue
+		}
+		if file.conflict != "" && freed[file.conflict] && infos[path] == nil {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			pending = append(pending, path)
+			infos[path] = info
+		}
+	}
+
+	type changedRelease struct {
+		release *gen.Release
+		path    string
+		state   fileState
+	}
+	changed := []changedRelease{}
+	failed := 0
+
+	for _, path := range pending {
+		info := infos[path]
+		state := fileState{size: info.Size(), mtime: info.ModTime().UnixNano()}
+
+		log.Log.Debugf("Reading %s\n", path)
+		releaseBytes, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		release, err := NewReleaseFromBytes(releaseBytes, info.ModTime())
+		if err != nil {
+			// Remember the broken file, so it's only read again once it changes
+			log.Log.Errorf("Failed to index %s: %v", path, err)
+			s.ignored[path] = scannedFile{state: state}
+			failed++
+			continue
+		}
+
+		if inTrash(s.ModulesDir, path) {
+			readDeletion(path, info.ModTime()).applyTo(release)
+		}
+
+		changed = append(changed, changedRelease{release: release, path: path, state: state})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added, removed := 0, 0
+	conflicts := map[string]scannedFile{}
+	err = s.withTx(func(tx *sql.Tx) error {
+		affected := map[string]bool{}
+
+		read := map[string]bool{}
+		for _, c := range changed {
+			read[c.path] = true
+		}
+
+		for path := range indexed {
+			if seen[path] && infos[path] == nil {
+				continue
+			}
+			releases, err := s.queryReleases(tx, "SELECT data FROM releases WHERE file_path = ?", path)
+			if err != nil {
+				return err
+			}
+			for _, release := range releases {
+				if err := s.deleteRelease(tx, release.Slug); err != nil {
+					return err
+				}
+				affected[release.Module.Slug] = true
+				// Changed files are indexed again below
+				if !read[path] {
+					removed++
+				}
+			}
+		}
+
+		for _, c := range changed {
+			// Another file provides the release already, e.g. a live and a deleted copy of a release
+			var path string
+			err := tx.QueryRow(s.rebind("SELECT file_path FROM releases WHERE slug = ?"), c.release.Slug).Scan(&path)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if err == nil && path != c.path {
+				log.Log.Warnf("Ignoring %s, release %s is already provided by another file", c.path, c.release.Slug)
+				conflicts[c.path] = scannedFile{state: c.state, conflict: c.release.Slug}
+				continue
+			}
+
+			if err := s.upsertRelease(tx, c.release, c.path, c.state); err != nil {
+				return err
+			}
+			affected[c.release.Module.Slug] = true
+			added++
+		}
+
+		for slug := range affected {
+			if err := s.rebuildModule(tx, slug); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range changed {
+		delete(s.ignored, c.path)
+	}
+	for path, file := range conflicts {
+		s.ignored[path] = file
+	}
+
+	logf := log.Log.Debugf
+	if added > 0 || removed > 0 || failed > 0 {
+		logf = log.Log.Infof
+	}
+	logf("Indexed %d new or changed releases, removed %d and failed to read %d in %s", added, removed, failed, time.Since(start))
+
+	return nil
+}
+
+func (s *SQLBackend) GetAllModules() ([]*gen.Module, error) {
+	return s.queryModules(s.db, "SELECT data FROM modules ORDER BY slug")
+}
+
+func (s *SQLBackend) GetModuleBySlug(slug string) (*gen.Module, error) {
+	modules, err := s.queryModules(s.db, "SELECT data FROM modules WHERE slug = ?", slug)
+	if err != nil {
+		return nil, err
+	}
+	if len(modules) == 0 {
+		return nil, errors.New("module not found")
+	}
+	return modules[0], nil
+}
+
+func (s *SQLBackend) GetAllReleases() ([]*gen.Release, error) {
+	return s.queryReleases(s.db, "SELECT data FROM releases ORDER BY slug")
+}
+
+func (s *SQLBackend) GetReleaseBySlug(slug string) (*gen.Release, error) {
+	releases, err := s.queryReleases(s.db, "SELECT data FROM releases WHERE slug = ?", slug)
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return releases[0], nil
+}
+
+func (s *SQLBackend) QueryModules(query *ModuleQuery) ([]*gen.Module, int, error) {
+	conditions := []string{}
+	args := []any{}
+
+	if !query.ShowDeleted {
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM release_deletions d WHERE d.release_slug = m.current_release)")
+	}
+	if query.Query != "" {
+		conditions = append(conditions, `(LOWER(m.slug) LIKE LOWER(?) ESCAPE '\' OR LOWER(m.owner) LIKE LOWER(?) ESCAPE '\')`)
+		args = append(args, likePattern(query.Query), likePattern(query.Query))
+	}
+	if query.StartsWith != "" {
+		conditions = append(conditions, `LOWER(m.slug) LIKE LOWER(?) ESCAPE '\'`)
+		args = append(args, likePrefix(query.StartsWith))
+	}
+	if len(query.Slugs) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(query.Slugs)), ", ")
+		conditions = append(conditions, fmt.Sprintf("m.slug IN (%s)", placeholders))
+		for _, slug := range query.Slugs {
+			args = append(args, slug)
+		}
+	}
+	if query.Tag != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM release_tags t WHERE t.release_slug = m.current_release AND t.tag = ?)")
+		args = append(args, query.Tag)
+	}
+	if query.Owner != "" {
+		conditions = append(conditions, "m.owner = ?")
+		args = append(args, query.Owner)
+	}
+	if query.WithTasks {
+		conditions = append(conditions, "r.has_tasks = ?")
+		args = append(args, true)
+	}
+	if query.WithPlans {
+		conditions = append(conditions, "r.has_plans = ?")
+		args = append(args, true)
+	}
+	if query.WithPdk {
+		conditions = append(conditions, "r.pdk = ?")
+		args = append(args, true)
+	}
+	if query.Premium {
+		conditions = append(conditions, "m.premium = ?")
+		args = append(args, true)
+	}
+	if query.ExcludePremium {
+		conditions = append(conditions, "m.premium = ?")
+		args = append(args, false)
+	}
+	if len(query.Endorsements) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(query.Endorsements)), ", ")
+		conditions = append(conditions, fmt.Sprintf("m.endorsement IN (%s)", placeholders))
+		for _, e := range query.Endorsements {
+			args = append(args, e)
+		}
+	}
+	if query.HideDeprecated {
+		conditions = append(conditions, "m.deprecated = ?")
+		args = append(args, false)
+	}
+	if query.Supported {
+		conditions = append(conditions, "m.supported = ?")
+		args = append(args, true)
+	}
+	if len(query.ModuleGroups) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(query.ModuleGroups)), ", ")
+		conditions = append(conditions, fmt.Sprintf("m.module_group IN (%s)", placeholders))
+		for _, group := range query.ModuleGroups {
+			args = append(args, group)
+		}
+	}
+	if !query.WithReleaseSince.IsZero() {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM releases c WHERE c.module = m.slug AND c.created_at >= ?
+			AND NOT EXISTS (SELECT 1 FROM release_deletions d WHERE d.release_slug = c.slug))`)
+		args = append(args, query.WithReleaseSince.UTC().Format(time.RFC3339))
+	}
+	if query.Operatingsystem != "" || query.PeRequirement != nil || query.PuppetRequirement != nil || query.WithMinimumScore > 0 {
+		// A single release has to satisfy all of the compatibility filters
+		candidate := []string{"c.module = m.slug", "NOT EXISTS (SELECT 1 FROM release_deletions d WHERE d.release_slug = c.slug)"}
+		if query.OnlyLatest {
+			candidate = append(candidate, "c.slug = m.latest_release")
+		}
+		if query.WithMinimumScore > 0 {
+			candidate = append(candidate, "c.validation_score >= ?")
+			args = append(args, query.WithMinimumScore)
+		}
+		if query.Operatingsystem != "" {
+			condition, conditionArgs := operatingsystemCondition("c", query.Operatingsystem, query.OperatingsystemRelease)
+			candidate = append(candidate, condition)
+			args = append(args, conditionArgs...)
+		}
+		if query.PeRequirement != nil {
+			condition, conditionArgs := s.requirementCondition("c", "pe", query.PeRequirement)
+			candidate = append(candidate, condition)
+			args = append(args, conditionArgs...)
+		}
+		if query.PuppetRequirement != nil {
+			condition, conditionArgs := s.requirementCondition("c", "puppet", query.PuppetRequirement)
+			candidate = append(candidate, condition)
+			args = append(args, conditionArgs...)
+		}
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM releases c WHERE "+strings.Join(candidate, " AND ")+")")
+	}
+
+	from := " FROM modules m JOIN releases r ON r.slug = m.current_release"
+	if len(conditions) > 0 {
+		from += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	orderBy := " ORDER BY m.downloads DESC, m.slug"
+	orderArgs := []any{}
+	switch query.SortBy {
+	case "latest_release":
+		orderBy = " ORDER BY m.updated_at DESC, m.slug"
+	case "downloads":
+	default:
+		if query.Query != "" {
+			// Modules named like the query come first, followed by the ones whose name starts with it
+			orderBy = ` ORDER BY CASE WHEN m.name = ? THEN 0 WHEN m.name LIKE ? ESCAPE '\' THEN 1 ELSE 2 END,
+				m.downloads DESC, m.slug`
+			name := strings.ToLower(query.Query)
+			orderArgs = append(orderArgs, name, likePrefix(name))
+		}
+	}
+
+	total, err := s.count("SELECT COUNT(*)"+from, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	limit, limitArgs := s.pagination(query.Limit, query.Offset)
+	args = append(append(args, orderArgs...), limitArgs...)
+	modules, err := s.queryModules(s.db, "SELECT m.data"+from+orderBy+limit, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return modules, total, nil
+}
+
+// operatingsystemCondition returns a condition matching releases which support the operating system (and release)
+func operatingsystemCondition(alias, operatingsystem, release string) (string, []any) {
+	if release != "" {
+		return fmt.Sprintf(`EXISTS (SELECT 1 FROM release_operatingsystems o WHERE o.release_slug = %s.slug
+			AND LOWER(o.operatingsystem) = LOWER(?) AND LOWER(o.operatingsystemrelease) = LOWER(?))`, alias), []any{operatingsystem, release}
+	}
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM release_operatingsystems o WHERE o.release_slug = %s.slug AND LOWER(o.operatingsystem) = LOWER(?))", alias),
+		[]any{operatingsystem}
+}
+
+// requirementCondition returns a condition matching releases with a requirement named name in a range intersecting r
+// Two intervals intersect if each of them starts before the other one ends
+func (s *SQLBackend) requirementCondition(alias, name string, r *utils.VersionRange) (string, []any) {
+	intervals := []string{}
+	args := []any{name}
+	for _, interval := range r.Intervals() {
+		bounds := []string{}
+		if interval.Lower != nil {
+			key := utils.VersionKey(interval.Lower.Version)
+			if interval.Lower.Inclusive {
+				bounds = append(bounds, fmt.Sprintf("(x.upper_key IS NULL OR %s > ? OR (x.upper_key = ? AND x.upper_inclusive = ?))", s.binary("x.upper_key")))
+				args = append(args, key, key, true)
+			} else {
+				bounds = append(bounds, fmt.Sprintf("(x.upper_key IS NULL OR %s > ?)", s.binary("x.upper_key")))
+				args = append(args, key)
+			}
+		}
+		if interval.Upper != nil {
+			key := utils.VersionKey(interval.Upper.Version)
+			if interval.Upper.Inclusive {
+				bounds = append(bounds, fmt.Sprintf("(x.lower_key IS NULL OR %s < ? OR (x.lower_key = ? AND x.lower_inclusive = ?))", s.binary("x.lower_key")))
+				args = append(args, key, key, true)
+			} else {
+				bounds = append(bounds, fmt.Sprintf("(x.lower_key IS NULL OR %s < ?)", s.binary("x.lower_key")))
+				args = append(args, key)
+			}
+		}
+		if len(bounds) == 0 {
+			bounds = append(bounds, "1 = 1")
+		}
+		intervals = append(intervals, "("+strings.Join(bounds, " AND ")+")")
+	}
+	if len(intervals) == 0 {
+		intervals = append(intervals, "1 = 0")
+	}
+
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM release_requirements x WHERE x.release_slug = %s.slug AND x.name = ? AND (%s))",
+		alias, strings.Join(intervals, " OR ")), args
+}
+
+func (s *SQLBackend) QueryReleases(query *ReleaseQuery) ([]*gen.Release, int, error) {
+	conditions := []string{}
+	args := []any{}
+
+	if !query.ShowDeleted {
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM release_deletions d WHERE d.release_slug = r.slug)")
+	}
+	if query.Module != "" {
+		conditions = append(conditions, "r.module = ?")
+		args = append(args, query.Module)
+	}
+	if query.Owner != "" {
+		conditions = append(conditions, "r.owner = ?")
+		args = append(args, query.Owner)
+	}
+	if query.WithPdk {
+		conditions = append(conditions, "r.pdk = ?")
+		args = append(args, true)
+	}
+	if query.Operatingsystem != "" {
+		condition, conditionArgs := operatingsystemCondition("r", query.Operatingsystem, query.OperatingsystemRelease)
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+	if query.PeRequirement != nil {
+		condition, conditionArgs := s.requirementCondition("r", "pe", query.PeRequirement)
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+	if query.PuppetRequirement != nil {
+		condition, conditionArgs := s.requirementCondition("r", "puppet", query.PuppetRequirement)
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+	// Releases without module are treated like releases of a base module which isn't deprecated nor supported
+	if query.HideDeprecated {
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM modules m WHERE m.slug = r.module AND m.deprecated = ?)")
+		args = append(args, true)
+	}
+	if query.Supported {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM modules m WHERE m.slug = r.module AND m.supported = ?)")
+		args = append(args, true)
+	}
+	if len(query.ModuleGroups) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(query.ModuleGroups)), ", ")
+		conditions = append(conditions, fmt.Sprintf("COALESCE((SELECT m.module_group FROM modules m WHERE m.slug = r.module), ?) IN (%s)", placeholders))
+		args = append(args, ModuleGroupBase)
+		for _, group := range query.ModuleGroups {
+			args = append(args, group)
+		}
+	}
+
+	from := " FROM releases r"
+	if len(conditions) > 0 {
+		from += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	total, err := s.count("SELECT COUNT(*)"+from, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var orderBy string
+	switch query.SortBy {
+	case "downloads":
+		orderBy = " ORDER BY r.downloads DESC, r.slug"
+	case "release_date":
+		orderBy = " ORDER BY r.created_at DESC, r.slug"
+	case "module":
+		orderBy = " ORDER BY r.module, " + s.binary("r.version_key") + " DESC, r.slug"
+	default:
+		orderBy = " ORDER BY " + s.binary("r.version_key") + " DESC, r.slug"
+	}
+
+	limit, limitArgs := s.pagination(query.Limit, query.Offset)
+	releases, err := s.queryReleases(s.db, "SELECT r.data"+from+orderBy+limit, append(args, limitArgs...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return releases, total, nil
+}
+
+func (s *SQLBackend) GetReleaseFile(slug string) (*ReleaseFile, error) {
+	var path, sha256 string
+	// Deleted releases can't be downloaded until they are restored
+	err := s.db.QueryRow(s.rebind(`SELECT file_path, file_sha256 FROM releases r WHERE slug = ?
+		AND NOT EXISTS (SELECT 1 FROM release_deletions d WHERE d.release_slug = r.slug)`), slug).Scan(&path, &sha256)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	return openReleaseFile(path, sha256)
+}
+
+func (s *SQLBackend) AddRelease(upload *Upload) (*gen.Release, error) {
+	release, err := NewReleaseFromUpload(upload, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addRelease(release, upload)
+}
+
+// addRelease stores the tarball and indexes the release unless it's known already, mu must be held
+func (s *SQLBackend) addRelease(release *gen.Release, upload *Upload) (*gen.Release, error) {
+	// No need to re-write releases we know of
+	if existing, err := s.GetReleaseBySlug(release.Slug); err == nil {
+		if ReleaseDeleted(existing) {
+			return nil, ErrReleaseDeleted
+		}
+		if err := checkUnchanged(existing, release); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	path := s.releaseFilePath(release)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// Only write if file does not exist
+		if err := copyFileAtomic(path, upload.Open()); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.withTx(func(tx *sql.Tx) error {
+		state := fileState{size: info.Size(), mtime: info.ModTime().UnixNano()}
+		if err := s.upsertRelease(tx, release, path, state); err != nil {
+			return err
+		}
+		return s.rebuildModule(tx, release.Module.Slug)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return release, nil
+}
+
+func (s *SQLBackend) OverwriteRelease(upload *Upload) (*gen.Release, error) {
+	release, err := NewReleaseFromUpload(upload, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.GetReleaseBySlug(release.Slug)
+	if err != nil {
+		return s.addRelease(release, upload)
+	}
+	if ReleaseDeleted(existing) {
+		return nil, ErrReleaseDeleted
+	}
+	prepareOverwrite(existing, release)
+
+	path := s.releaseFilePath(existing)
+	if err := copyFileAtomic(path, upload.Open()); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.withTx(func(tx *sql.Tx) error {
+		state := fileState{size: info.Size(), mtime: info.ModTime().UnixNano()}
+		if err := s.upsertRelease(tx, release, path, state); err != nil {
+			return err
+		}
+		return s.rebuildModule(tx, release.Module.Slug)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return release, nil
+}
+
+func (s *SQLBackend) AddDownloads(counts map[string]int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.withTx(func(tx *sql.Tx) error {
+		modules := map[string]bool{}
+		for slug, count := range counts {
+			_, err := tx.Exec(s.rebind(`INSERT INTO release_downloads (release_slug, downloads) VALUES (?, ?)
+				ON CONFLICT (release_slug) DO UPDATE SET downloads = release_downloads.downloads + excluded.downloads`), slug, count)
+			if err != nil {
+				return err
+			}
+
+			releases, err := s.queryReleases(tx, "SELECT data FROM releases WHERE slug = ?", slug)
+			if err != nil {
+				return err
+			}
+			if len(releases) == 0 {
+				continue
+			}
+			release := releases[0]
+
+			if err := tx.QueryRow(s.rebind("SELECT downloads FROM release_downloads WHERE release_slug = ?"), slug).Scan(&release.Downloads); err != nil {
+				return err
+			}
+			data, err := json.Marshal(release)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(s.rebind("UPDATE releases SET downloads = ?, data = ? WHERE slug = ?"), release.Downloads, string(data), slug); err != nil {
+				return err
+			}
+			modules[release.Module.Slug] = true
+		}
+
+		for module := range modules {
+			if err := s.rebuildModule(tx, module); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLBackend) DeleteModuleBySlug(slug, reason string) error {
+	releases, err := s.queryReleases(s.db, `SELECT data FROM releases r WHERE module = ?
+		AND NOT EXISTS (SELECT 1 FROM release_deletions d WHERE d.release_slug = r.slug)`, slug)
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 {
+		return os.ErrNotExist
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deletion := NewDeletion(reason)
+	for _, release := range releases {
+		if err := s.moveRelease(release, deletion); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLBackend) DeleteReleaseBySlug(slug, reason string) error {
+	release, err := s.GetReleaseBySlug(slug)
+	if err != nil {
+		return err
+	}
+	if ReleaseDeleted(release) {
+		return os.ErrNotExist
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.moveRelease(release, NewDeletion(reason))
+}
+
+func (s *SQLBackend) RestoreReleaseBySlug(slug string) (*gen.Release, error) {
+	release, err := s.GetReleaseBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if !ReleaseDeleted(release) {
+		return nil, ErrReleaseNotDeleted
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.moveRelease(release, nil); err != nil {
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// moveRelease moves the tarball of the release into the trash, a nil deletion restores it
+// The index is updated to point to the new location, mu must be held
+func (s *SQLBackend) moveRelease(release *gen.Release, deletion *Deletion) error {
+	from := s.releaseFilePath(release)
+	deletion.applyTo(release)
+	to := s.releaseFilePath(release)
+
+	var err error
+	if deletion != nil {
+		err = moveToTrash(from, to, deletion)
+	} else {
+		if _, statErr := os.Stat(to); statErr == nil {
+			return fmt.Errorf("%s already exists", to)
+		}
+		err = moveFromTrash(from, to)
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(to)
+	if err != nil {
+		return err
+	}
+
+	return s.withTx(func(tx *sql.Tx) error {
+		state := fileState{size: info.Size(), mtime: info.ModTime().UnixNano()}
+		if err := s.upsertRelease(tx, release, to, state); err != nil {
+			return err
+		}
+		return s.rebuildModule(tx, release.Module.Slug)
+	})
+}
+
+func (s *SQLBackend) GetDependents(moduleSlug string) ([]*Dependent, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT r.data, d.version_requirement, m.current_release
+		FROM release_dependencies d
+		JOIN releases r ON r.slug = d.release_slug
+		JOIN modules m ON m.slug = r.module
+		WHERE d.name = ? AND r.module <> ?
+		AND NOT EXISTS (SELECT 1 FROM release_deletions x WHERE x.release_slug = r.slug)
+		ORDER BY r.slug`), moduleSlug, moduleSlug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*Dependent{}
+	for rows.Next() {
+		var data, versionRequirement, currentRelease string
+		if err := rows.Scan(&data, &versionRequirement, &currentRelease); err != nil {
+			return nil, err
+		}
+		var release gen.Release
+		if err := json.Unmarshal([]byte(data), &release); err != nil {
+			return nil, err
+		}
+		result = append(result, &Dependent{
+			Release:            &release,
+			VersionRequirement: versionRequirement,
+			Current:            release.Slug == currentRelease,
+		})
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLBackend) PurgeDeletedReleases(deletedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	releases, err := s.queryReleases(s.db, `SELECT r.data FROM releases r
+		JOIN release_deletions d ON d.release_slug = r.slug WHERE d.deleted_at < ?`, deletedBefore.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, release := range releases {
+		if err := removeFromTrash(s.releaseFilePath(release)); err != nil {
+			return purged, err
+		}
+
+		err := s.withTx(func(tx *sql.Tx) error {
+			if err := s.deleteRelease(tx, release.Slug); err != nil {
+				return err
+			}
+			if err := s.rebuildModule(tx, release.Module.Slug); err != nil {
+				return err
+			}
+
+			// The state of modules without any releases left is dropped as well
+			_, err := tx.Exec(s.rebind(`DELETE FROM module_states WHERE slug = ?
+				AND NOT EXISTS (SELECT 1 FROM releases WHERE module = ?)`), release.Module.Slug, release.Module.Slug)
+			return err
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+func (s *SQLBackend) UpdateModule(module *gen.Module) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(ModuleStateOf(module))
+	if err != nil {
+		return err
+	}
+
+	return s.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.rebind(`INSERT INTO module_states (slug, data) VALUES (?, ?)
+			ON CONFLICT (slug) DO UPDATE SET data = excluded.data`), module.Slug, string(data))
+		if err != nil {
+			return err
+		}
+		return s.upsertModule(tx, module)
+	})
+}
+
+func (s *SQLBackend) ReadDocument(name string) ([]byte, error) {
+	var data string
+	err := s.db.QueryRow(s.rebind("SELECT data FROM documents WHERE name = ?"), name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && data == "") {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (s *SQLBackend) UpdateDocument(name string, update func(current []byte) ([]byte, error)) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		// An empty row is created first, so there is always a row which can be locked
+		if _, err := tx.Exec(s.rebind("INSERT INTO documents (name, data) VALUES (?, '') ON CONFLICT (name) DO NOTHING"), name); err != nil {
+			return err
+		}
+
+		query := "SELECT data FROM documents WHERE name = ?"
+		if s.postgres {
+			query += " FOR UPDATE"
+		}
+		var current string
+		if err := tx.QueryRow(s.rebind(query), name).Scan(&current); err != nil {
+			return err
+		}
+
+		var content []byte
+		if current != "" {
+			content = []byte(current)
+		}
+		data, err := update(content)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(s.rebind("UPDATE documents SET data = ? WHERE name = ?"), string(data), name)
+		return err
+	})
+}
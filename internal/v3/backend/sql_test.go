@@ -0,0 +1,330 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dadav/gorge/internal/log"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Log = zap.NewNop().Sugar()
+	os.Exit(m.Run())
+}
+
+// releaseTarball creates a gzipped tarball of the module name (owner-name) with the given version
+func releaseTarball(t *testing.T, name, version string) []byte {
+	t.Helper()
+	return releaseTarballWithSummary(t, name, version, fmt.Sprintf("%s module", name))
+}
+
+// releaseTarballWithSummary creates a tarball like releaseTarball, different summaries result in different checksums
+func releaseTarballWithSummary(t *testing.T, name, version, summary string) []byte {
+	t.Helper()
+
+	owner, _, _ := strings.Cut(name, "-")
+	metadata, err := json.Marshal(map[string]any{
+		"name":         name,
+		"version":      version,
+		"author":       owner,
+		"license":      "MIT",
+		"summary":      summary,
+		"source":       "https://example.com",
+		"dependencies": []any{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	header := &tar.Header{Name: fmt.Sprintf("%s-%s/metadata.json", name, version), Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(metadata))}
+	if err := tw.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeRelease writes the tarball of the release below dir, using the layout of the modules directory
+func writeRelease(t *testing.T, dir, name, version string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name, fmt.Sprintf("%s-%s%s", name, version, tarGzExt))
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, releaseTarball(t, name, version), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestSQLBackend(t *testing.T, dir string) *SQLBackend {
+	t.Helper()
+
+	s, err := NewSQLBackend(SQLConfig{Driver: SQLDriverSqlite, ModulesDir: dir})
+	if err != nil {
+		t.Fatalf("failed to create the sql backend: %v", err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+func releaseSlugs(releases []*gen.Release) []string {
+	slugs := []string{}
+	for _, release := range releases {
+		slugs = append(slugs, release.Slug)
+	}
+	slices.Sort(slugs)
+	return slugs
+}
+
+func moduleSlugs(modules []*gen.Module) []string {
+	slugs := []string{}
+	for _, module := range modules {
+		slugs = append(slugs, module.Slug)
+	}
+	return slugs
+}
+
+func TestSQLBackendLoadModules(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSQLBackend(t, dir)
+
+	writeRelease(t, dir, "acme-foo", "1.0.0")
+	writeRelease(t, dir, "acme-foo", "1.2.0")
+	bar := writeRelease(t, dir, "acme-bar", "0.1.0")
+
+	if err := s.LoadModules(); err != nil {
+		t.Fatalf("failed to load modules: %v", err)
+	}
+
+	releases, err := s.GetAllReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := releaseSlugs(releases), []string{"acme-bar-0.1.0", "acme-foo-1.0.0", "acme-foo-1.2.0"}; !slices.Equal(got, want) {
+		t.Errorf("expected releases %v, got %v", want, got)
+	}
+
+	module, err := s.GetModuleBySlug("acme-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if module.CurrentRelease.Version != "1.2.0" {
+		t.Errorf("expected the current release 1.2.0, got %s", module.CurrentRelease.Version)
+	}
+
+	// Removed files drop their releases and modules
+	if err := os.Remove(bar); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadModules(); err != nil {
+		t.Fatalf("failed to load modules: %v", err)
+	}
+	if _, err := s.GetReleaseBySlug("acme-bar-0.1.0"); err == nil {
+		t.Error("expected the release of the removed file to be gone")
+	}
+	if _, err := s.GetModuleBySlug("acme-bar"); err == nil {
+		t.Error("expected the module without releases to be gone")
+	}
+
+	// Broken files are skipped
+	if err := os.WriteFile(filepath.Join(dir, "acme-foo", "acme-foo-2.0.0.tar.gz"), []byte("broken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadModules(); err != nil {
+		t.Fatalf("failed to load modules: %v", err)
+	}
+	if _, ok := s.ignored[filepath.Join(dir, "acme-foo", "acme-foo-2.0.0.tar.gz")]; !ok {
+		t.Error("expected the broken file to be ignored")
+	}
+	releases, err = s.GetAllReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := releaseSlugs(releases), []string{"acme-foo-1.0.0", "acme-foo-1.2.0"}; !slices.Equal(got, want) {
+		t.Errorf("expected releases %v, got %v", want, got)
+	}
+}
+
+func TestSQLBackendLoadModulesConflict(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSQLBackend(t, dir)
+
+	// The same release is deleted and live, the trash is found first
+	live := writeRelease(t, dir, "acme-foo", "1.0.0")
+	deleted := writeRelease(t, filepath.Join(dir, trashDir), "acme-foo", "1.0.0")
+
+	var provider string
+	for range 3 {
+		if err := s.LoadModules(); err != nil {
+			t.Fatalf("failed to load modules: %v", err)
+		}
+		if err := s.db.QueryRow("SELECT file_path FROM releases WHERE slug = ?", "acme-foo-1.0.0").Scan(&provider); err != nil {
+			t.Fatal(err)
+		}
+		// Repeated scans must neither flip the release between the files nor read them again
+		if provider != deleted {
+			t.Fatalf("expected the release to be provided by %s, got %s", deleted, provider)
+		}
+		if file, ok := s.ignored[live]; !ok || file.conflict != "acme-foo-1.0.0" {
+			t.Fatalf("expected %s to be ignored as conflict, got %+v", live, file)
+		}
+	}
+
+	release, err := s.GetReleaseBySlug("acme-foo-1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ReleaseDeleted(release) {
+		t.Error("expected the release of the trash to be deleted")
+	}
+
+	// Once the deleted copy is gone, the live file provides the release
+	if err := os.Remove(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadModules(); err != nil {
+		t.Fatalf("failed to load modules: %v", err)
+	}
+	if err := s.db.QueryRow("SELECT file_path FROM releases WHERE slug = ?", "acme-foo-1.0.0").Scan(&provider); err != nil {
+		t.Fatal(err)
+	}
+	if provider != live {
+		t.Errorf("expected the release to be provided by %s, got %s", live, provider)
+	}
+	if _, ok := s.ignored[live]; ok {
+		t.Error("expected the live file not to be ignored anymore")
+	}
+	release, err = s.GetReleaseBySlug("acme-foo-1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ReleaseDeleted(release) {
+		t.Error("expected the live release not to be deleted")
+	}
+}
+
+func loadTestReleases(t *testing.T) *SQLBackend {
+	t.Helper()
+
+	dir := t.TempDir()
+	s := newTestSQLBackend(t, dir)
+
+	writeRelease(t, dir, "acme-foo", "1.0.0")
+	writeRelease(t, dir, "acme-foo", "1.10.0")
+	writeRelease(t, dir, "acme-foo", "1.2.0")
+	writeRelease(t, dir, "acme-bar", "2.0.0")
+	writeRelease(t, dir, "other-baz", "0.1.0")
+	writeRelease(t, filepath.Join(dir, trashDir), "other-gone", "1.0.0")
+
+	if err := s.LoadModules(); err != nil {
+		t.Fatalf("failed to load modules: %v", err)
+	}
+	return s
+}
+
+func TestSQLBackendQueryModules(t *testing.T) {
+	s := loadTestReleases(t)
+
+	tests := []struct {
+		name      string
+		query     ModuleQuery
+		want      []string
+		wantTotal int
+	}{
+		{name: "all", query: ModuleQuery{}, want: []string{"acme-bar", "acme-foo", "other-baz"}, wantTotal: 3},
+		{name: "deleted modules", query: ModuleQuery{ShowDeleted: true}, want: []string{"acme-bar", "acme-foo", "other-baz", "other-gone"}, wantTotal: 4},
+		{name: "owner", query: ModuleQuery{Owner: "acme"}, want: []string{"acme-bar", "acme-foo"}, wantTotal: 2},
+		{name: "search", query: ModuleQuery{Query: "FOO"}, want: []string{"acme-foo"}, wantTotal: 1},
+		{name: "starts with", query: ModuleQuery{StartsWith: "other"}, want: []string{"other-baz"}, wantTotal: 1},
+		{name: "slugs", query: ModuleQuery{Slugs: []string{"acme-bar", "other-baz", "acme-missing"}}, want: []string{"acme-bar", "other-baz"}, wantTotal: 2},
+		{name: "pagination", query: ModuleQuery{Limit: 1, Offset: 1}, want: []string{"acme-foo"}, wantTotal: 3},
+		{name: "no match", query: ModuleQuery{Query: "missing"}, want: []string{}, wantTotal: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modules, total, err := s.QueryModules(&tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := moduleSlugs(modules)
+			if tt.query.Limit == 0 {
+				slices.Sort(got)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("expected a total of %d, got %d", tt.wantTotal, total)
+			}
+		})
+	}
+}
+
+func TestSQLBackendQueryReleases(t *testing.T) {
+	s := loadTestReleases(t)
+
+	tests := []struct {
+		name      string
+		query     ReleaseQuery
+		want      []string
+		wantTotal int
+	}{
+		{name: "all", query: ReleaseQuery{}, want: []string{"acme-bar-2.0.0", "acme-foo-1.0.0", "acme-foo-1.10.0", "acme-foo-1.2.0", "other-baz-0.1.0"}, wantTotal: 5},
+		{name: "deleted releases", query: ReleaseQuery{ShowDeleted: true, Owner: "other"}, want: []string{"other-baz-0.1.0", "other-gone-1.0.0"}, wantTotal: 2},
+		{name: "module", query: ReleaseQuery{Module: "acme-foo"}, want: []string{"acme-foo-1.0.0", "acme-foo-1.10.0", "acme-foo-1.2.0"}, wantTotal: 3},
+		{name: "owner", query: ReleaseQuery{Owner: "other"}, want: []string{"other-baz-0.1.0"}, wantTotal: 1},
+		{name: "unknown module", query: ReleaseQuery{Module: "acme-missing"}, want: []string{}, wantTotal: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			releases, total, err := s.QueryReleases(&tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := releaseSlugs(releases); !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("expected a total of %d, got %d", tt.wantTotal, total)
+			}
+		})
+	}
+
+	// The releases of a module are sorted by version, not by string
+	releases, _, err := s.QueryReleases(&ReleaseQuery{Module: "acme-foo", SortBy: "module"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := []string{}
+	for _, release := range releases {
+		versions = append(versions, release.Version)
+	}
+	if want := []string{"1.10.0", "1.2.0", "1.0.0"}; !slices.Equal(versions, want) {
+		t.Errorf("expected the versions %v, got %v", want, versions)
+	}
+}
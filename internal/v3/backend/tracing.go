@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/dadav/gorge/internal/tracing"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedBackend records a span for every call of the configured backend
+type tracedBackend struct {
+	Backend
+	ctx context.Context
+}
+
+// Traced returns the configured backend, its calls are traced as children of the span in ctx
+// The time spent waiting for the locks of the backend, e.g. while the modules are loaded, is part of the spans
+func Traced(ctx context.Context) Backend {
+	return &tracedBackend{Backend: ConfiguredBackend, ctx: ctx}
+}
+
+func (b *tracedBackend) start(method string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := tracing.Start(b.ctx, "backend."+method, attrs...)
+	return span
+}
+
+func slugAttr(slug string) attribute.KeyValue {
+	return attribute.String("gorge.slug", slug)
+}
+
+func (b *tracedBackend) LoadModules() error {
+	span := b.start("LoadModules")
+	return tracing.End(span, b.Backend.LoadModules())
+}
+
+func (b *tracedBackend) GetAllModules() ([]*gen.Module, error) {
+	span := b.start("GetAllModules")
+	modules, err := b.Backend.GetAllModules()
+	return modules, tracing.End(span, err)
+}
+
+func (b *tracedBackend) GetModuleBySlug(slug string) (*gen.Module, error) {
+	span := b.start("GetModuleBySlug", slugAttr(slug))
+	module, err := b.Backend.GetModuleBySlug(slug)
+	return module, tracing.End(span, err)
+}
+
+func (b *tracedBackend) GetAllReleases() ([]*gen.Release, error) {
+	span := b.start("GetAllReleases")
+	releases, err := b.Backend.GetAllReleases()
+	return releases, tracing.End(span, err)
+}
+
+func (b *tracedBackend) GetReleaseBySlug(slug string) (*gen.Release, error) {
+	span := b.start("GetReleaseBySlug", slugAttr(slug))
+	release, err := b.Backend.GetReleaseBySlug(slug)
+	return release, tracing.End(span, err)
+}
+
+func (b *tracedBackend) QueryModules(query *ModuleQuery) ([]*gen.Module, int, error) {
+	span := b.start("QueryModules")
+	modules, total, err := b.Backend.QueryModules(query)
+	span.SetAttributes(attribute.Int("gorge.total", total))
+	return modules, total, tracing.End(span, err)
+}
+
+func (b *tracedBackend) QueryReleases(query *ReleaseQuery) ([]*gen.Release, int, error) {
+	span := b.start("QueryReleases", attribute.String("gorge.module", query.Module))
+	releases, total, err := b.Backend.QueryReleases(query)
+	span.SetAttributes(attribute.Int("gorge.total", total))
+	return releases, total, tracing.End(span, err)
+}
+
+func (b *tracedBackend) GetReleaseFile(slug string) (*ReleaseFile, error) {
+	span := b.start("GetReleaseFile", slugAttr(slug))
+	file, err := b.Backend.GetReleaseFile(slug)
+	return file, tracing.End(span, err)
+}
+
+func (b *tracedBackend) AddRelease(upload *Upload) (*gen.Release, error) {
+	span := b.start("AddRelease", attribute.Int64("gorge.size", upload.Size))
+	release, err := b.Backend.AddRelease(upload)
+	return release, tracing.End(span, err)
+}
+
+func (b *tracedBackend) OverwriteRelease(upload *Upload) (*gen.Release, error) {
+	span := b.start("OverwriteRelease", attribute.Int64("gorge.size", upload.Size))
+	release, err := b.Backend.OverwriteRelease(upload)
+	return release, tracing.End(span, err)
+}
+
+func (b *tracedBackend) AddDownloads(counts map[string]int32) error {
+	span := b.start("AddDownloads", attribute.Int("gorge.releases", len(counts)))
+	return tracing.End(span, b.Backend.AddDownloads(counts))
+}
+
+func (b *tracedBackend) DeleteModuleBySlug(slug, reason string) error {
+	span := b.start("DeleteModuleBySlug", slugAttr(slug))
+	return tracing.End(span, b.Backend.DeleteModuleBySlug(slug, reason))
+}
+
+func (b *tracedBackend) DeleteReleaseBySlug(slug, reason string) error {
+	span := b.start("DeleteReleaseBySlug", slugAttr(slug))
+	return tracing.End(span, b.Backend.DeleteReleaseBySlug(slug, reason))
+}
+
+func (b *tracedBackend) RestoreReleaseBySlug(slug string) (*gen.Release, error) {
+	span := b.start("RestoreReleaseBySlug", slugAttr(slug))
+	release, err := b.Backend.RestoreReleaseBySlug(slug)
+	return release, tracing.End(span, err)
+}
+
+func (b *tracedBackend) GetDependents(moduleSlug string) ([]*Dependent, error) {
+	span := b.start("GetDependents", slugAttr(moduleSlug))
+	dependents, err := b.Backend.GetDependents(moduleSlug)
+	return dependents, tracing.End(span, err)
+}
+
+func (b *tracedBackend) PurgeDeletedReleases(deletedBefore time.Time) (int, error) {
+	span := b.start("PurgeDeletedReleases")
+	purged, err := b.Backend.PurgeDeletedReleases(deletedBefore)
+	return purged, tracing.End(span, err)
+}
+
+func (b *tracedBackend) UpdateModule(module *gen.Module) error {
+	span := b.start("UpdateModule", slugAttr(module.Slug))
+	return tracing.End(span, b.Backend.UpdateModule(module))
+}
+
+func (b *tracedBackend) ReadDocument(name string) ([]byte, error) {
+	span := b.start("ReadDocument", attribute.String("gorge.document", name))
+	data, err := b.Backend.ReadDocument(name)
+	return data, tracing.End(span, err)
+}
+
+func (b *tracedBackend) UpdateDocument(name string, update func(current []byte) ([]byte, error)) error {
+	span := b.start("UpdateDocument", attribute.String("gorge.document", name))
+	return tracing.End(span, b.Backend.UpdateDocument(name, update))
+}
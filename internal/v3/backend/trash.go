@@ -0,0 +1,173 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dadav/gorge/internal/log"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+const (
+	// trashDir contains the tarballs of deleted releases, using the same layout as the modules
+	trashDir    = ".trash"
+	deletionExt = ".json"
+)
+
+var (
+	// ErrReleaseDeleted is returned if a release is added again while it's still in the trash
+	ErrReleaseDeleted = errors.New("release has been deleted, restore it instead")
+	// ErrReleaseNotDeleted is returned if a release which hasn't been deleted is restored
+	ErrReleaseNotDeleted = errors.New("release has not been deleted")
+)
+
+// Deletion records when and why a release has been deleted
+// It's stored next to the tarball in the trash
+type Deletion struct {
+	DeletedAt  string  `json:"deleted_at"`
+	DeletedFor *string `json:"deleted_for,omitempty"`
+}
+
+func NewDeletion(reason string) *Deletion {
+	deletion := &Deletion{DeletedAt: time.Now().UTC().Format(time.RFC3339)}
+	if reason != "" {
+		deletion.DeletedFor = &reason
+	}
+	return deletion
+}
+
+// applyTo marks the release as deleted, a nil deletion restores it
+func (d *Deletion) applyTo(release *gen.Release) {
+	if d == nil {
+		release.DeletedAt = nil
+		release.DeletedFor = nil
+		return
+	}
+	deletedAt := d.DeletedAt
+	release.DeletedAt = &deletedAt
+	release.DeletedFor = d.DeletedFor
+}
+
+// ReleaseDeleted reports whether the release is in the trash
+func ReleaseDeleted(release *gen.Release) bool {
+	return release.DeletedAt != nil
+}
+
+// ModuleDeleted reports whether all releases of the module are in the trash
+// The current release is only a deleted one if no other release is left
+func ModuleDeleted(module *gen.Module) bool {
+	return module.CurrentRelease.DeletedAt != nil
+}
+
+// deletedBefore reports whether the release has been deleted before t
+func deletedBefore(release *gen.Release, t time.Time) bool {
+	return release.DeletedAt != nil && *release.DeletedAt < t.UTC().Format(time.RFC3339)
+}
+
+// releasePath returns the path of the tarball below root, deleted releases are stored in the trash
+func releasePath(root string, release *gen.Release) string {
+	if ReleaseDeleted(release) {
+		return filepath.Join(root, trashDir, release.Module.Slug, fmt.Sprintf("%s%s", release.Slug, tarGzExt))
+	}
+	return filepath.Join(root, release.Module.Slug, fmt.Sprintf("%s%s", release.Slug, tarGzExt))
+}
+
+// inTrash reports whether path is located in the trash of root
+func inTrash(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(rel, trashDir+string(filepath.Separator))
+}
+
+// deletionPath returns the path of the deletion record belonging to the trashed tarball
+func deletionPath(tarball string) string {
+	return strings.TrimSuffix(tarball, tarGzExt) + deletionExt
+}
+
+// readDeletion reads the deletion record of the trashed tarball
+// Without a readable record the release counts as deleted at modTime
+func readDeletion(tarball string, modTime time.Time) *Deletion {
+	deletion := &Deletion{DeletedAt: modTime.UTC().Format(time.RFC3339)}
+
+	data, err := os.ReadFile(deletionPath(tarball))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Log.Errorf("Failed to read deletion record of %s: %v", tarball, err)
+		}
+		return deletion
+	}
+
+	if err := json.Unmarshal(data, deletion); err != nil {
+		log.Log.Errorf("Ignoring invalid deletion record of %s: %v", tarball, err)
+		return &Deletion{DeletedAt: modTime.UTC().Format(time.RFC3339)}
+	}
+
+	return deletion
+}
+
+// moveToTrash writes the deletion record and moves the tarball from path to trashPath
+func moveToTrash(path, trashPath string, deletion *Deletion) error {
+	if err := os.MkdirAll(filepath.Dir(trashPath), os.ModePerm); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(deletion)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(deletionPath(trashPath), data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, trashPath); err != nil {
+		os.Remove(deletionPath(trashPath))
+		return err
+	}
+
+	// Only succeeds if the module has no releases left
+	os.Remove(filepath.Dir(path))
+
+	return nil
+}
+
+// moveFromTrash moves the tarball back from trashPath to path and removes the deletion record
+func moveFromTrash(trashPath, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(trashPath, path); err != nil {
+		return err
+	}
+
+	if err := os.Remove(deletionPath(trashPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	os.Remove(filepath.Dir(trashPath))
+
+	return nil
+}
+
+// removeFromTrash permanently removes the trashed tarball and its deletion record
+func removeFromTrash(trashPath string) error {
+	if err := os.Remove(trashPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Remove(deletionPath(trashPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	os.Remove(filepath.Dir(trashPath))
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Upload is a release tarball together with the checksums calculated while it was received
+// Large uploads are kept in a temporary file, so they are never held in memory completely
+type Upload struct {
+	content io.ReaderAt
+	// path is the temporary file containing the tarball, empty if it's kept in memory
+	path   string
+	Size   int64
+	Md5    string
+	Sha256 string
+	// archive caches the parsed tarball, so it's only read once per upload
+	archive    *ReleaseArchive
+	archiveErr error
+}
+
+// NewUpload copies the tarball into a temporary file and calculates its checksums on the way
+// The caller has to close the upload to remove the file
+func NewUpload(r io.Reader) (*Upload, error) {
+	f, err := os.CreateTemp("", "gorge-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, md5Hash, sha256Hash), r)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &Upload{
+		content: f,
+		path:    f.Name(),
+		Size:    size,
+		Md5:     fmt.Sprintf("%x", md5Hash.Sum(nil)),
+		Sha256:  fmt.Sprintf("%x", sha256Hash.Sum(nil)),
+	}, nil
+}
+
+// NewUploadFromBytes creates an upload of a tarball which is already in memory
+func NewUploadFromBytes(data []byte) *Upload {
+	return &Upload{
+		content: bytes.NewReader(data),
+		Size:    int64(len(data)),
+		Md5:     fmt.Sprintf("%x", md5.Sum(data)),
+		Sha256:  fmt.Sprintf("%x", sha256.Sum256(data)),
+	}
+}
+
+// Open returns a reader starting at the beginning of the tarball, it can be called multiple times
+func (u *Upload) Open() io.Reader {
+	return io.NewSectionReader(u.content, 0, u.Size)
+}
+
+// Archive parses the tarball on the first call and returns the cached result afterwards
+func (u *Upload) Archive() (*ReleaseArchive, error) {
+	if u.archive == nil && u.archiveErr == nil {
+		u.archive, u.archiveErr = ReadReleaseArchive(u.Open())
+	}
+	return u.archive, u.archiveErr
+}
+
+// Close removes the temporary file of the upload
+func (u *Upload) Close() error {
+	if u.path == "" {
+		return nil
+	}
+	if f, ok := u.content.(*os.File); ok {
+		f.Close()
+	}
+	return os.Remove(u.path)
+}
@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/dadav/gorge/internal/v3/utils"
+)
+
+// ValidationPolicy contains the checks a release has to pass before it's published
+// It's applied on upload and when the modules are scanned
+type ValidationPolicy struct {
+	// LicenseAllowlist contains the allowed licenses (e.g. Apache-2.0), an empty list allows all licenses
+	LicenseAllowlist []string
+	// MaxArchiveSize is the maximum size of the compressed archive in bytes, 0 means unlimited
+	MaxArchiveSize int64
+	// EnforceOnScan skips scanned tarballs violating the policy, otherwise the violations are only logged
+	// Uploads are always rejected
+	EnforceOnScan bool
+}
+
+// ValidationError lists all violations of the validation policy
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("release validation failed: %s", strings.Join(e.Violations, "; "))
+}
+
+// CheckSize validates the size of the archive before it's read
+func (p *ValidationPolicy) CheckSize(size int64) error {
+	if p.MaxArchiveSize > 0 && size > p.MaxArchiveSize {
+		return &ValidationError{Violations: []string{
+			fmt.Sprintf("archive size of %d bytes exceeds the maximum of %d bytes", size, p.MaxArchiveSize),
+		}}
+	}
+	return nil
+}
+
+// Validate checks the archive against the policy and returns a *ValidationError with all violations
+// The size is checked separately by CheckSize, so oversized archives don't need to be read
+func (p *ValidationPolicy) Validate(archive *ReleaseArchive) error {
+	violations := []string{}
+	metadata := archive.Metadata
+
+	if !utils.CheckSemver(metadata.Version) {
+		violations = append(violations, fmt.Sprintf("version %q is not a valid semantic version", metadata.Version))
+	}
+
+	// Tarballs built by puppet module build contain a single directory named <name>-<version>
+	expectedDir := fmt.Sprintf("%s-%s", dependencyName(metadata.Name), metadata.Version)
+	topLevelDirs := make([]string, 0, len(archive.topLevelDirs))
+	for dir := range archive.topLevelDirs {
+		topLevelDirs = append(topLevelDirs, dir)
+	}
+	sort.Strings(topLevelDirs)
+	if len(topLevelDirs) != 1 || topLevelDirs[0] != expectedDir {
+		for i, dir := range topLevelDirs {
+			if dir == "" {
+				topLevelDirs[i] = "/"
+			}
+		}
+		violations = append(violations, fmt.Sprintf("archive must only contain the directory %s, found %s", expectedDir, strings.Join(topLevelDirs, ", ")))
+	}
+
+	if len(p.LicenseAllowlist) > 0 {
+		allowed := slices.ContainsFunc(p.LicenseAllowlist, func(license string) bool {
+			return strings.EqualFold(strings.TrimSpace(license), strings.TrimSpace(metadata.License))
+		})
+		if metadata.License == "" {
+			violations = append(violations, "license is missing")
+		} else if !allowed {
+			violations = append(violations, fmt.Sprintf("license %q is not allowed, allowed licenses are %s", metadata.License, strings.Join(p.LicenseAllowlist, ", ")))
+		}
+	}
+
+	for _, dep := range metadata.Dependencies {
+		if !utils.CheckModuleSlug(dependencyName(dep.Name)) {
+			violations = append(violations, fmt.Sprintf("dependency %q has an invalid name", dep.Name))
+			continue
+		}
+		if _, err := utils.ParseVersionRange(dep.VersionRequirement); err != nil {
+			violations = append(violations, fmt.Sprintf("dependency %s: %v", dep.Name, err))
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/dadav/gorge/internal/model"
+	"github.com/dadav/gorge/internal/v3/utils"
+	"github.com/hashicorp/go-version"
+)
+
+// maxSteps limits the number of releases tried before the resolution is aborted
+const maxSteps = 10000
+
+// ErrTooComplex is returned if the dependency graph can't be resolved within maxSteps
+var ErrTooComplex = errors.New("dependency resolution aborted, the dependency graph is too complex")
+
+// Requirement is a module with a version range, as given in a Puppetfile or the dependencies of a release
+type Requirement struct {
+	Name               string `json:"name"`
+	VersionRequirement string `json:"version_requirement,omitempty"`
+}
+
+// Candidate is a release which can be selected for a module
+type Candidate struct {
+	Slug         string
+	Module       string
+	Version      string
+	FileUri      string
+	Dependencies []model.ModuleDependency
+	// Source is "local" or the url of the proxy the release was found on
+	Source string
+
+	version *version.Version
+}
+
+// Source returns the available releases of a module, an empty list means the module is unknown
+type Source interface {
+	Releases(ctx context.Context, module string) ([]*Candidate, error)
+}
+
+// Constraint is a version range put on a module by the root requirements or a selected release
+type Constraint struct {
+	VersionRequirement string `json:"version_requirement"`
+	// RequiredBy is the slug of the release requiring the module, empty for root requirements
+	RequiredBy string `json:"required_by,omitempty"`
+
+	versionRange *utils.VersionRange
+}
+
+// Conflict describes a module for which no release satisfies all constraints
+type Conflict struct {
+	Module            string       `json:"module"`
+	Reason            string       `json:"reason"`
+	Constraints       []Constraint `json:"constraints"`
+	AvailableVersions []string     `json:"available_versions"`
+}
+
+// ConflictError is returned if the requirements can't be resolved
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	reasons := make([]string, 0, len(e.Conflicts))
+	for _, conflict := range e.Conflicts {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", conflict.Module, conflict.Reason))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Resolution is a consistent set of releases satisfying all requirements
+type Resolution struct {
+	Releases []*Candidate
+	// RequiredBy contains the releases depending on each selected module
+	RequiredBy map[string][]string
+}
+
+type resolver struct {
+	ctx         context.Context
+	source      Source
+	candidates  map[string][]*Candidate
+	selected    map[string]*Candidate
+	constraints map[string][]Constraint
+	steps       int
+	// conflicts contains the latest failure of each module, used for the report
+	conflicts map[string]Conflict
+}
+
+// NormalizeName converts module names like puppetlabs/stdlib to the slug format puppetlabs-stdlib
+func NormalizeName(name string) string {
+	return strings.ReplaceAll(strings.TrimSpace(name), "/", "-")
+}
+
+// Resolve selects a release for every required module and their dependencies
+// The highest matching version is preferred and pre-releases are only used if no other release matches
+func Resolve(ctx context.Context, source Source, requirements []Requirement) (*Resolution, error) {
+	r := &resolver{
+		ctx:         ctx,
+		source:      source,
+		candidates:  map[string][]*Candidate{},
+		selected:    map[string]*Candidate{},
+		constraints: map[string][]Constraint{},
+		conflicts:   map[string]Conflict{},
+	}
+
+	pending := []string{}
+	for _, requirement := range requirements {
+		name := NormalizeName(requirement.Name)
+		constraint, err := newConstraint(requirement.VersionRequirement, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid requirement for %s: %w", requirement.Name, err)
+		}
+		r.constraints[name] = append(r.constraints[name], constraint)
+		pending = append(pending, name)
+	}
+
+	ok, err := r.solve(pending)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		conflictErr := &ConflictError{}
+		for _, conflict := range r.conflicts {
+			conflictErr.Conflicts = append(conflictErr.Conflicts, conflict)
+		}
+		sort.Slice(conflictErr.Conflicts, func(i, j int) bool {
+			return conflictErr.Conflicts[i].Module < conflictErr.Conflicts[j].Module
+		})
+		return nil, conflictErr
+	}
+
+	resolution := &Resolution{RequiredBy: map[string][]string{}}
+	for name, candidate := range r.selected {
+		resolution.Releases = append(resolution.Releases, candidate)
+		for _, constraint := range r.constraints[name] {
+			if constraint.RequiredBy != "" && !slices.Contains(resolution.RequiredBy[name], constraint.RequiredBy) {
+				resolution.RequiredBy[name] = append(resolution.RequiredBy[name], constraint.RequiredBy)
+			}
+		}
+		sort.Strings(resolution.RequiredBy[name])
+	}
+	sort.Slice(resolution.Releases, func(i, j int) bool {
+		return resolution.Releases[i].Module < resolution.Releases[j].Module
+	})
+
+	return resolution, nil
+}
+
+func newConstraint(versionRequirement, requiredBy string) (Constraint, error) {
+	versionRange, err := utils.ParseVersionRange(versionRequirement)
+	if err != nil {
+		return Constraint{}, err
+	}
+	if versionRequirement == "" {
+		versionRequirement = "*"
+	}
+	return Constraint{
+		VersionRequirement: versionRequirement,
+		RequiredBy:         requiredBy,
+		versionRange:       versionRange,
+	}, nil
+}
+
+// solve selects releases for all pending modules, backtracking if a selection leads to a conflict
+func (r *resolver) solve(pending []string) (bool, error) {
+	if len(pending) == 0 {
+		return true, nil
+	}
+
+	name, rest := pending[0], pending[1:]
+	if _, found := r.selected[name]; found {
+		// The constraints were checked when they have been added
+		return r.solve(rest)
+	}
+
+	candidates, err := r.releases(name)
+	if err != nil {
+		return false, err
+	}
+	if len(candidates) == 0 {
+		r.addConflict(name, "module could not be found", nil)
+		return false, nil
+	}
+
+	matched := false
+	for _, candidate := range candidates {
+		if !r.satisfies(name, candidate) {
+			continue
+		}
+		matched = true
+
+		r.steps++
+		if r.steps > maxSteps {
+			return false, ErrTooComplex
+		}
+
+		r.selected[name] = candidate
+		added, next, ok := r.addDependencies(candidate)
+		if ok {
+			solved, err := r.solve(append(append([]string{}, rest...), next...))
+			if err != nil {
+				return false, err
+			}
+			if solved {
+				return true, nil
+			}
+		}
+
+		// Undo the selection and try the next release
+		for _, dep := range added {
+			r.constraints[dep] = r.constraints[dep][:len(r.constraints[dep])-1]
+		}
+		delete(r.selected, name)
+	}
+
+	if !matched {
+		r.addConflict(name, "no release satisfies all version requirements", candidates)
+	}
+
+	return false, nil
+}
+
+// addDependencies adds the constraints of the candidate's dependencies
+// It returns the modules which got a constraint, the modules still to resolve and
+// false if a dependency conflicts with a release selected already
+func (r *resolver) addDependencies(candidate *Candidate) ([]string, []string, bool) {
+	added := []string{}
+	next := []string{}
+
+	for _, dep := range candidate.Dependencies {
+		name := NormalizeName(dep.Name)
+		constraint, err := newConstraint(dep.VersionRequirement, candidate.Slug)
+		if err != nil {
+			r.constraints[name] = append(r.constraints[name], Constraint{VersionRequirement: dep.VersionRequirement, RequiredBy: candidate.Slug})
+			added = append(added, name)
+			r.addConflict(name, fmt.Sprintf("%s has an invalid version requirement", candidate.Slug), nil)
+			return added, next, false
+		}
+
+		r.constraints[name] = append(r.constraints[name], constraint)
+		added = append(added, name)
+
+		if selected, found := r.selected[name]; found {
+			if !constraint.versionRange.Contains(selected.version) {
+				r.addConflict(name, fmt.Sprintf("%s requires %s, but %s is selected already", candidate.Slug, constraint.VersionRequirement, selected.Version), nil)
+				return added, next, false
+			}
+			continue
+		}
+		next = append(next, name)
+	}
+
+	return added, next, true
+}
+
+// satisfies reports whether the candidate matches all constraints of the module
+func (r *resolver) satisfies(name string, candidate *Candidate) bool {
+	for _, constraint := range r.constraints[name] {
+		if constraint.versionRange == nil || !constraint.versionRange.Contains(candidate.version) {
+			return false
+		}
+	}
+	return true
+}
+
+// releases returns the candidates of the module, sorted by preference
+func (r *resolver) releases(name string) ([]*Candidate, error) {
+	if candidates, found := r.candidates[name]; found {
+		return candidates, nil
+	}
+
+	releases, err := r.source.Releases(r.ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch releases of %s: %w", name, err)
+	}
+
+	candidates := []*Candidate{}
+	for _, release := range releases {
+		v, err := version.NewVersion(release.Version)
+		if err != nil {
+			continue
+		}
+		release.version = v
+		candidates = append(candidates, release)
+	}
+
+	// Stable releases come first, each group is ordered from the highest to the lowest version
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].version, candidates[j].version
+		if (a.Prerelease() == "") != (b.Prerelease() == "") {
+			return a.Prerelease() == ""
+		}
+		return a.GreaterThan(b)
+	})
+
+	r.candidates[name] = candidates
+	return candidates, nil
+}
+
+// addConflict records why no release of the module could be selected
+func (r *resolver) addConflict(name, reason string, candidates []*Candidate) {
+	if candidates == nil {
+		candidates = r.candidates[name]
+	}
+
+	available := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		available = append(available, candidate.Version)
+	}
+
+	r.conflicts[name] = Conflict{
+		Module:            name,
+		Reason:            reason,
+		Constraints:       append([]Constraint{}, r.constraints[name]...),
+		AvailableVersions: available,
+	}
+}
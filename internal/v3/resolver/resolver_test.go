@@ -0,0 +1,239 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/model"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Log = zap.NewNop().Sugar()
+	os.Exit(m.Run())
+}
+
+// fakeRelease describes a release of the fake source, dependencies are given as name and version requirement
+type fakeRelease struct {
+	version string
+	deps    map[string]string
+}
+
+// fakeSource serves releases from memory, the candidates are created on every call like a real source
+type fakeSource map[string][]fakeRelease
+
+func (f fakeSource) Releases(_ context.Context, module string) ([]*Candidate, error) {
+	candidates := []*Candidate{}
+	for _, release := range f[module] {
+		candidate := &Candidate{
+			Slug:    fmt.Sprintf("%s-%s", module, release.version),
+			Module:  module,
+			Version: release.version,
+			Source:  SourceLocal,
+		}
+		for name, versionRequirement := range release.deps {
+			candidate.Dependencies = append(candidate.Dependencies, model.ModuleDependency{Name: name, VersionRequirement: versionRequirement})
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// failingSource fails for every module
+type failingSource struct{}
+
+func (failingSource) Releases(context.Context, string) ([]*Candidate, error) {
+	return nil, errors.New("source is down")
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name         string
+		source       Source
+		requirements []Requirement
+		// want contains the selected release slugs, sorted by module
+		want []string
+		// wantConflicts contains the modules reported as conflict
+		wantConflicts []string
+		wantErr       error
+	}{
+		{
+			name: "simple",
+			source: fakeSource{
+				"acme-app": {{version: "1.0.0", deps: map[string]string{"acme/lib": ">= 1.0.0 < 2.0.0"}}},
+				"acme-lib": {{version: "1.0.0"}, {version: "1.5.0"}, {version: "2.0.0"}},
+			},
+			requirements: []Requirement{{Name: "acme/app"}},
+			want:         []string{"acme-app-1.0.0", "acme-lib-1.5.0"},
+		},
+		{
+			name: "pre-releases are only used without stable release",
+			source: fakeSource{
+				"acme-app": {{version: "2.0.0-rc1"}, {version: "1.0.0"}},
+				"acme-lib": {{version: "1.0.0-beta"}},
+			},
+			requirements: []Requirement{{Name: "acme-app"}, {Name: "acme-lib"}},
+			want:         []string{"acme-app-1.0.0", "acme-lib-1.0.0-beta"},
+		},
+		{
+			name: "backtracking after a conflict",
+			source: fakeSource{
+				// The latest app needs a lib the tool doesn't support, so the older app has to be selected
+				"acme-app":  {{version: "2.0.0", deps: map[string]string{"acme-lib": ">= 2.0.0"}}, {version: "1.0.0", deps: map[string]string{"acme-lib": "1.x"}}},
+				"acme-tool": {{version: "1.0.0", deps: map[string]string{"acme-lib": "< 2.0.0"}}},
+				"acme-lib":  {{version: "2.0.0"}, {version: "1.2.0"}},
+			},
+			requirements: []Requirement{{Name: "acme-tool"}, {Name: "acme-app"}},
+			want:         []string{"acme-app-1.0.0", "acme-lib-1.2.0", "acme-tool-1.0.0"},
+		},
+		{
+			name: "backtracking over a dependency selected later",
+			source: fakeSource{
+				"acme-app": {{version: "1.0.0", deps: map[string]string{"acme-lib": "*", "acme-db": "*"}}},
+				"acme-lib": {{version: "2.0.0", deps: map[string]string{"acme-db": "< 1.0.0"}}, {version: "1.0.0"}},
+				"acme-db":  {{version: "1.0.0"}},
+			},
+			requirements: []Requirement{{Name: "acme-app"}},
+			want:         []string{"acme-app-1.0.0", "acme-db-1.0.0", "acme-lib-1.0.0"},
+		},
+		{
+			name: "unsatisfiable requirement",
+			source: fakeSource{
+				"acme-app": {{version: "1.0.0"}, {version: "1.1.0"}},
+			},
+			requirements:  []Requirement{{Name: "acme-app", VersionRequirement: ">= 2.0.0"}},
+			wantConflicts: []string{"acme-app"},
+		},
+		{
+			name: "conflicting dependencies",
+			source: fakeSource{
+				"acme-app":  {{version: "1.0.0", deps: map[string]string{"acme-lib": ">= 2.0.0"}}},
+				"acme-tool": {{version: "1.0.0", deps: map[string]string{"acme-lib": "< 2.0.0"}}},
+				"acme-lib":  {{version: "1.0.0"}, {version: "2.0.0"}},
+			},
+			requirements:  []Requirement{{Name: "acme-app"}, {Name: "acme-tool"}},
+			wantConflicts: []string{"acme-lib"},
+		},
+		{
+			name:          "unknown module",
+			source:        fakeSource{},
+			requirements:  []Requirement{{Name: "acme-missing"}},
+			wantConflicts: []string{"acme-missing"},
+		},
+		{
+			name:         "failing source",
+			source:       failingSource{},
+			requirements: []Requirement{{Name: "acme-app"}},
+			wantErr:      errors.New("failed to fetch releases of acme-app: source is down"),
+		},
+		{
+			name:         "step limit",
+			source:       tooComplexSource(),
+			requirements: []Requirement{{Name: "acme-app"}},
+			wantErr:      ErrTooComplex,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolution, err := Resolve(context.Background(), tt.source, tt.requirements)
+
+			if tt.wantErr != nil {
+				if err == nil || (!errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error()) {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+
+			if tt.wantConflicts != nil {
+				var conflictErr *ConflictError
+				if !errors.As(err, &conflictErr) {
+					t.Fatalf("expected a ConflictError, got %v", err)
+				}
+				modules := []string{}
+				for _, conflict := range conflictErr.Conflicts {
+					modules = append(modules, conflict.Module)
+					if conflict.Reason == "" {
+						t.Errorf("conflict of %s has no reason", conflict.Module)
+					}
+				}
+				if !slices.Equal(modules, tt.wantConflicts) {
+					t.Errorf("expected conflicts %v, got %v", tt.wantConflicts, modules)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := []string{}
+			for _, release := range resolution.Releases {
+				got = append(got, release.Slug)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveConflictReport(t *testing.T) {
+	source := fakeSource{
+		"acme-app": {{version: "1.0.0", deps: map[string]string{"acme-lib": ">= 2.0.0"}}},
+		"acme-lib": {{version: "1.0.0"}},
+	}
+
+	_, err := Resolve(context.Background(), source, []Requirement{{Name: "acme-app"}})
+
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) || len(conflictErr.Conflicts) != 1 {
+		t.Fatalf("expected a single conflict, got %v", err)
+	}
+	conflict := conflictErr.Conflicts[0]
+	if conflict.Module != "acme-lib" {
+		t.Errorf("expected the conflict of acme-lib, got %s", conflict.Module)
+	}
+	if len(conflict.Constraints) != 1 || conflict.Constraints[0].RequiredBy != "acme-app-1.0.0" || conflict.Constraints[0].VersionRequirement != ">= 2.0.0" {
+		t.Errorf("unexpected constraints %+v", conflict.Constraints)
+	}
+	if !slices.Equal(conflict.AvailableVersions, []string{"1.0.0"}) {
+		t.Errorf("unexpected available versions %v", conflict.AvailableVersions)
+	}
+}
+
+func TestResolveRequiredBy(t *testing.T) {
+	source := fakeSource{
+		"acme-app":  {{version: "1.0.0", deps: map[string]string{"acme-lib": "*"}}},
+		"acme-tool": {{version: "1.0.0", deps: map[string]string{"acme-lib": "*"}}},
+		"acme-lib":  {{version: "1.0.0"}},
+	}
+
+	resolution, err := Resolve(context.Background(), source, []Requirement{{Name: "acme-app"}, {Name: "acme-tool"}, {Name: "acme-lib"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := resolution.RequiredBy["acme-lib"]; !slices.Equal(got, []string{"acme-app-1.0.0", "acme-tool-1.0.0"}) {
+		t.Errorf("unexpected dependents of acme-lib %v", got)
+	}
+	if got := resolution.RequiredBy["acme-app"]; len(got) != 0 {
+		t.Errorf("root requirements must not have dependents, got %v", got)
+	}
+}
+
+// tooComplexSource returns more releases than maxSteps, each of them depends on a missing version
+func tooComplexSource() fakeSource {
+	releases := make([]fakeRelease, 0, maxSteps+1)
+	for i := range maxSteps + 1 {
+		releases = append(releases, fakeRelease{version: fmt.Sprintf("1.0.%d", i), deps: map[string]string{"acme-lib": ">= 2.0.0"}})
+	}
+	return fakeSource{
+		"acme-app": releases,
+		"acme-lib": {{version: "1.0.0"}},
+	}
+}
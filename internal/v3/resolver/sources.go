@@ -0,0 +1,184 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dadav/gorge/internal/log"
+	"github.com/dadav/gorge/internal/middleware"
+	"github.com/dadav/gorge/internal/model"
+	"github.com/dadav/gorge/internal/v3/backend"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+const (
+	// SourceLocal is the source of releases found in the configured backend
+	SourceLocal = "local"
+
+	// proxyTimeout limits fetching all releases of a module from a proxy
+	proxyTimeout = 10 * time.Second
+	// maxProxyPages limits the number of pages fetched for a single module
+	maxProxyPages = 50
+)
+
+// LocalSource returns the releases of the configured backend, deleted releases are ignored
+type LocalSource struct{}
+
+func (LocalSource) Releases(ctx context.Context, module string) ([]*Candidate, error) {
+	releases, _, err := backend.Traced(ctx).QueryReleases(&backend.ReleaseQuery{Module: module})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]*Candidate, 0, len(releases))
+	for _, release := range releases {
+		candidate, err := releaseToCandidate(release, SourceLocal)
+		if err != nil {
+			log.Log.Errorf("Ignoring release %s with invalid metadata: %v", release.Slug, err)
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+// ProxySource fetches the releases from the fallback proxies
+// The first proxy knowing the module is used, unhealthy proxies are skipped
+type ProxySource struct {
+	Upstreams []*middleware.Upstream
+}
+
+func NewProxySource(upstreams []*middleware.Upstream) *ProxySource {
+	return &ProxySource{Upstreams: upstreams}
+}
+
+func (p *ProxySource) Releases(ctx context.Context, module string) ([]*Candidate, error) {
+	var lastErr error
+
+	for _, upstream := range p.Upstreams {
+		candidates, err := p.fetchReleases(ctx, upstream, module)
+		if err != nil {
+			log.Log.Errorf("Failed to fetch releases of %s from %s: %v", module, upstream.Url, err)
+			lastErr = err
+			continue
+		}
+		if len(candidates) > 0 {
+			return candidates, nil
+		}
+	}
+
+	return []*Candidate{}, lastErr
+}
+
+type proxyReleasesResponse struct {
+	Pagination struct {
+		Next *string `json:"next"`
+	} `json:"pagination"`
+	Results []gen.Release `json:"results"`
+}
+
+// fetchReleases follows the pagination of /v3/releases until all releases of the module are fetched
+func (p *ProxySource) fetchReleases(ctx context.Context, upstream *middleware.Upstream, module string) ([]*Candidate, error) {
+	ctx, cancel := context.WithTimeout(ctx, proxyTimeout)
+	defer cancel()
+
+	params := url.Values{}
+	params.Set("module", module)
+	params.Set("limit", "100")
+	params.Set("exclude_fields", "readme changelog license reference")
+	next := "/v3/releases?" + params.Encode()
+
+	candidates := []*Candidate{}
+	for page := 0; next != "" && page < maxProxyPages; page++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream.Url+next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "gorge")
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := upstream.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return candidates, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+
+		var body proxyReleasesResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range body.Results {
+			candidate, err := releaseToCandidate(&body.Results[i], upstream.Url)
+			if err != nil {
+				continue
+			}
+			candidates = append(candidates, candidate)
+		}
+
+		next = ""
+		if body.Pagination.Next != nil {
+			next = *body.Pagination.Next
+		}
+	}
+
+	return candidates, nil
+}
+
+// FallbackSource returns the releases of the first source knowing the module
+type FallbackSource []Source
+
+func (f FallbackSource) Releases(ctx context.Context, module string) ([]*Candidate, error) {
+	for _, source := range f {
+		candidates, err := source.Releases(ctx, module)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) > 0 {
+			return candidates, nil
+		}
+	}
+	return []*Candidate{}, nil
+}
+
+func releaseToCandidate(release *gen.Release, source string) (*Candidate, error) {
+	data, err := json.Marshal(release.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata model.ReleaseMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+
+	fileUri := release.FileUri
+	if source != SourceLocal && strings.HasPrefix(fileUri, "/") {
+		fileUri = source + fileUri
+	}
+
+	return &Candidate{
+		Slug:         release.Slug,
+		Module:       release.Module.Slug,
+		Version:      release.Version,
+		FileUri:      fileUri,
+		Dependencies: metadata.Dependencies,
+		Source:       source,
+	}, nil
+}
@@ -0,0 +1,127 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dadav/gorge/internal/middleware"
+	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
+)
+
+func newTestUpstream(url string) *middleware.Upstream {
+	return middleware.NewUpstream(url, middleware.UpstreamConfig{
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		Cooldown:         time.Minute,
+	})
+}
+
+func TestProxySourceReleases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("module") != "acme-lib" {
+			http.NotFound(w, r)
+			return
+		}
+
+		// The releases are split into two pages
+		body := proxyReleasesResponse{}
+		if r.URL.Query().Get("offset") == "" {
+			next := "/v3/releases?module=acme-lib&offset=1"
+			body.Pagination.Next = &next
+			body.Results = []gen.Release{{Slug: "acme-lib-2.0.0", Version: "2.0.0", FileUri: "/v3/files/acme-lib-2.0.0.tar.gz", Module: gen.ReleaseModule{Slug: "acme-lib"}}}
+		} else {
+			body.Results = []gen.Release{{Slug: "acme-lib-1.0.0", Version: "1.0.0", FileUri: "/v3/files/acme-lib-1.0.0.tar.gz", Module: gen.ReleaseModule{Slug: "acme-lib"}}}
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	defer server.Close()
+
+	source := NewProxySource([]*middleware.Upstream{newTestUpstream(server.URL)})
+
+	candidates, err := source.Releases(context.Background(), "acme-lib")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := []string{}
+	for _, candidate := range candidates {
+		got = append(got, candidate.FileUri)
+		if candidate.Source != server.URL {
+			t.Errorf("expected the source %s, got %s", server.URL, candidate.Source)
+		}
+	}
+	want := []string{server.URL + "/v3/files/acme-lib-2.0.0.tar.gz", server.URL + "/v3/files/acme-lib-1.0.0.tar.gz"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	candidates, err = source.Releases(context.Background(), "acme-missing")
+	if err != nil || len(candidates) != 0 {
+		t.Errorf("expected no releases of an unknown module, got %v, %v", candidates, err)
+	}
+}
+
+func TestProxySourceSkipsUnhealthyUpstream(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	source := NewProxySource([]*middleware.Upstream{newTestUpstream(server.URL)})
+
+	for range 3 {
+		if _, err := source.Releases(context.Background(), "acme-lib"); err == nil {
+			t.Fatal("expected an error of the failing upstream")
+		}
+	}
+
+	// The first failure opens the circuit, afterwards the upstream isn't requested anymore
+	if got := requests.Load(); got != 1 {
+		t.Errorf("expected a single request to the failing upstream, got %d", got)
+	}
+
+	_, err := source.Releases(context.Background(), "acme-lib")
+	if !errors.Is(err, middleware.ErrUpstreamUnhealthy) {
+		t.Errorf("expected ErrUpstreamUnhealthy, got %v", err)
+	}
+}
+
+func TestFallbackSource(t *testing.T) {
+	source := FallbackSource{
+		fakeSource{"acme-app": {{version: "1.0.0"}}},
+		fakeSource{"acme-app": {{version: "2.0.0"}}, "acme-lib": {{version: "1.0.0"}}},
+	}
+
+	tests := []struct {
+		module string
+		want   []string
+	}{
+		{module: "acme-app", want: []string{"1.0.0"}},
+		{module: "acme-lib", want: []string{"1.0.0"}},
+		{module: "acme-missing", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.module, func(t *testing.T) {
+			candidates, err := source.Releases(context.Background(), tt.module)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := []string{}
+			for _, candidate := range candidates {
+				got = append(got, candidate.Version)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
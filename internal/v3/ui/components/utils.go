@@ -5,22 +5,10 @@ import (
 	"sort"
 	"strings"
 
-	customMiddleware "github.com/dadav/gorge/internal/middleware"
 	model "github.com/dadav/gorge/internal/model"
 	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
 )
 
-// getSortedKeys extracts and sorts endpoint keys from Statistics
-// Returns a sorted slice of endpoint strings
-func getSortedKeys(stats *customMiddleware.Statistics) []string {
-	keys := []string{}
-	for k := range stats.ConnectionsPerEndpoint {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	return keys
-}
-
 // sortModules sorts a slice of modules alphabetically by name
 // Returns the sorted slice of modules
 func sortModules(modules []*gen.Module) []*gen.Module {
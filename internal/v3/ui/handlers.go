@@ -5,8 +5,11 @@ import (
 	"strings"
 
 	"github.com/a-h/templ"
+	"github.com/dadav/gorge/internal/auth"
 	"github.com/dadav/gorge/internal/log"
-	customMiddleware "github.com/dadav/gorge/internal/middleware"
+	"github.com/dadav/gorge/internal/metrics"
+	"github.com/dadav/gorge/internal/middleware"
+	v3 "github.com/dadav/gorge/internal/v3/api"
 	"github.com/dadav/gorge/internal/v3/backend"
 	"github.com/dadav/gorge/internal/v3/ui/components"
 	gen "github.com/dadav/gorge/pkg/gen/v3/openapi"
@@ -18,22 +21,35 @@ func handleError(w http.ResponseWriter, err error) {
 	log.Log.Error(err)
 }
 
+// visibleModules drops the modules whose releases have all been deleted
+func visibleModules(modules []*gen.Module) []*gen.Module {
+	result := make([]*gen.Module, 0, len(modules))
+	for _, module := range modules {
+		if !backend.ModuleDeleted(module) {
+			result = append(result, module)
+		}
+	}
+	return result
+}
+
 func IndexHandler(w http.ResponseWriter, r *http.Request) {
-	modules, err := backend.ConfiguredBackend.GetAllModules()
+	modules, err := backend.Traced(r.Context()).GetAllModules()
 	if err != nil {
 		handleError(w, err)
 		return
 	}
+	modules = visibleModules(modules)
 	templ.Handler(components.Page("Gorge", components.SearchView("", modules))).ServeHTTP(w, r)
 }
 
 func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	query := strings.ToLower(r.URL.Query().Get("query"))
-	modules, err := backend.ConfiguredBackend.GetAllModules()
+	modules, err := backend.Traced(r.Context()).GetAllModules()
 	if err != nil {
 		handleError(w, err)
 		return
 	}
+	modules = visibleModules(modules)
 
 	filtered := make([]*gen.Module, 0, len(modules))
 	queryTerms := strings.Fields(query)
@@ -41,6 +57,15 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	for _, module := range modules {
 		matches := true
 		for _, term := range queryTerms {
+			// Saved search filters use key=value terms
+			if key, value, found := strings.Cut(term, "="); found {
+				if !matchesSearchFilterTerm(module, key, value) {
+					matches = false
+					break
+				}
+				continue
+			}
+
 			if !strings.Contains(strings.ToLower(module.Name), term) &&
 				!strings.Contains(strings.ToLower(module.Owner.Username), term) &&
 				!strings.Contains(strings.ToLower(module.CurrentRelease.Version), term) {
@@ -56,13 +81,54 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	templ.Handler(components.Page("Gorge", components.SearchView(query, filtered))).ServeHTTP(w, r)
 }
 
+// matchesSearchFilterTerm checks if the module matches a key=value term of a search filter
+// Unknown keys are ignored
+func matchesSearchFilterTerm(module *gen.Module, key, value string) bool {
+	switch key {
+	case "owner", "author":
+		return strings.EqualFold(module.Owner.Slug, value)
+	case "tag":
+		for _, tag := range module.CurrentRelease.Tags {
+			if strings.EqualFold(tag, value) {
+				return true
+			}
+		}
+		return false
+	case "module", "name":
+		return strings.Contains(strings.ToLower(module.Name), value)
+	case "version":
+		return strings.Contains(strings.ToLower(module.CurrentRelease.Version), value)
+	default:
+		return true
+	}
+}
+
+func SearchFiltersHandler(store *v3.SearchFilterStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Saved searches are private, only the ones of the authenticated user are shown
+		owner := auth.Subject(r.Context())
+		if owner == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		queries, err := store.Queries(owner)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		templ.Handler(components.Page("Saved searches", components.SearchFiltersView(queries))).ServeHTTP(w, r)
+	}
+}
+
 func AuthorHandler(w http.ResponseWriter, r *http.Request) {
 	authorSlug := chi.URLParam(r, "author")
-	modules, err := backend.ConfiguredBackend.GetAllModules()
+	modules, err := backend.Traced(r.Context()).GetAllModules()
 	if err != nil {
 		handleError(w, err)
 		return
 	}
+	modules = visibleModules(modules)
 
 	authorModules := make(map[string][]*gen.Module)
 	for _, module := range modules {
@@ -80,14 +146,14 @@ func AuthorHandler(w http.ResponseWriter, r *http.Request) {
 func ReleaseHandler(w http.ResponseWriter, r *http.Request) {
 	moduleSlug := chi.URLParam(r, "module")
 	version := chi.URLParam(r, "version")
-	releases, err := backend.ConfiguredBackend.GetAllReleases()
+	releases, err := backend.Traced(r.Context()).GetAllReleases()
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
 	for _, release := range releases {
-		if release.Module.Slug == moduleSlug && release.Version == version {
+		if release.Module.Slug == moduleSlug && release.Version == version && !backend.ReleaseDeleted(release) {
 			templ.Handler(components.Page(release.Slug, components.ReleaseView(release))).ServeHTTP(w, r)
 			return
 		}
@@ -98,16 +164,31 @@ func ReleaseHandler(w http.ResponseWriter, r *http.Request) {
 
 func ModuleHandler(w http.ResponseWriter, r *http.Request) {
 	moduleSlug := chi.URLParam(r, "module")
-	modules, err := backend.ConfiguredBackend.GetAllModules()
+	modules, err := backend.Traced(r.Context()).GetAllModules()
 	if err != nil {
 		w.WriteHeader(500)
 		log.Log.Error(err)
 		return
 	}
 
-	for _, module := range modules {
+	for _, module := range visibleModules(modules) {
 		if module.Slug == moduleSlug {
-			templ.Handler(components.Page(module.Slug, components.ModuleView(module))).ServeHTTP(w, r)
+			dependents, err := backend.Traced(r.Context()).GetDependents(module.Slug)
+			if err != nil {
+				w.WriteHeader(500)
+				log.Log.Error(err)
+				return
+			}
+
+			// Only the current releases of other modules are of interest
+			usedBy := []*backend.Dependent{}
+			for _, dependent := range dependents {
+				if dependent.Current {
+					usedBy = append(usedBy, dependent)
+				}
+			}
+
+			templ.Handler(components.Page(module.Slug, components.ModuleView(module, usedBy))).ServeHTTP(w, r)
 			return
 		}
 	}
@@ -115,10 +196,13 @@ func ModuleHandler(w http.ResponseWriter, r *http.Request) {
 	http.NotFound(w, r)
 }
 
-func StatisticsHandler(stats *customMiddleware.Statistics) func(http.ResponseWriter, *http.Request) {
+func StatisticsHandler(upstreams []*middleware.Upstream) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		stats.Mutex.Lock()
-		defer stats.Mutex.Unlock()
-		templ.Handler(components.Page("Statistics", components.StatisticsView(stats))).ServeHTTP(w, r)
+		stats, err := metrics.Summarize()
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		templ.Handler(components.Page("Statistics", components.StatisticsView(stats, middleware.UpstreamStatuses(upstreams)))).ServeHTTP(w, r)
 	}
 }
@@ -0,0 +1,356 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// VersionRange is a semantic version range as used in the metadata.json of puppet modules
+// Example valid ranges: "1.2.3", ">= 1.0.0 < 2.0.0", "1.x", "~1.2", "^2.1.0", "1.0.0 - 1.4.0", "4.x || 5.x"
+type VersionRange struct {
+	raw       string
+	intervals []versionInterval
+}
+
+// versionBound is one end of an interval, a nil bound is unbounded
+type versionBound struct {
+	version   *version.Version
+	inclusive bool
+}
+
+// versionInterval contains all versions between lower and upper
+type versionInterval struct {
+	lower *versionBound
+	upper *versionBound
+}
+
+// VersionBound is one end of a VersionInterval
+type VersionBound struct {
+	Version   *version.Version
+	Inclusive bool
+}
+
+// VersionInterval contains all versions between Lower and Upper, a nil bound is unbounded
+type VersionInterval struct {
+	Lower *VersionBound
+	Upper *VersionBound
+}
+
+var (
+	// operatorSpaces matches the whitespace between an operator and its version
+	operatorSpaces = regexp.MustCompile(`(>=|<=|~>|>|<|=|~|\^)\s+`)
+	comparatorExp  = regexp.MustCompile(`^(>=|<=|~>|>|<|=|~|\^)?v?(.+)$`)
+	// semverExp is the regular expression recommended by the semver 2.0.0 specification
+	semverExp = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+)
+
+// CheckSemver validates if the version is a strict semantic version (e.g. "1.2.3" or "2.0.0-rc.1+build.5")
+// Leading zeros, missing components and a "v" prefix are not allowed
+func CheckSemver(v string) bool {
+	return semverExp.MatchString(v)
+}
+
+// ParseVersionRange parses a version range, an empty range or "*" matches all versions
+func ParseVersionRange(raw string) (*VersionRange, error) {
+	r := &VersionRange{raw: strings.TrimSpace(raw)}
+
+	for _, part := range strings.Split(r.raw, "||") {
+		interval, err := parseInterval(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid version range %q: %w", raw, err)
+		}
+		r.intervals = append(r.intervals, interval)
+	}
+
+	return r, nil
+}
+
+func (r *VersionRange) String() string {
+	return r.raw
+}
+
+// Contains reports whether the version is part of the range
+func (r *VersionRange) Contains(v *version.Version) bool {
+	for _, interval := range r.intervals {
+		if interval.contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersects reports whether at least one version is part of both ranges
+func (r *VersionRange) Intersects(other *VersionRange) bool {
+	for _, a := range r.intervals {
+		for _, b := range other.intervals {
+			if !a.intersect(b).empty() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Intervals returns the non-empty intervals of the range, a version is part of the range if it's part of any of them
+func (r *VersionRange) Intervals() []VersionInterval {
+	result := []VersionInterval{}
+	for _, interval := range r.intervals {
+		if interval.empty() {
+			continue
+		}
+		exported := VersionInterval{}
+		if interval.lower != nil {
+			exported.Lower = &VersionBound{Version: interval.lower.version, Inclusive: interval.lower.inclusive}
+		}
+		if interval.upper != nil {
+			exported.Upper = &VersionBound{Version: interval.upper.version, Inclusive: interval.upper.inclusive}
+		}
+		result = append(result, exported)
+	}
+	return result
+}
+
+// VersionKey returns a string whose byte order is the precedence of the versions,
+// so versions can be sorted and compared by databases
+func VersionKey(v *version.Version) string {
+	segments := v.Segments64()
+	// 1.2.3.0 equals 1.2.3
+	for len(segments) > 3 && segments[len(segments)-1] == 0 {
+		segments = segments[:len(segments)-1]
+	}
+
+	var b strings.Builder
+	for _, segment := range segments {
+		writeNumberKey(&b, strconv.FormatInt(segment, 10))
+	}
+	// Lower than the digits of further segments
+	b.WriteByte('/')
+
+	pre := v.Prerelease()
+	if pre == "" {
+		// Releases have a higher precedence than their pre-releases
+		b.WriteByte('~')
+		return b.String()
+	}
+
+	b.WriteByte('-')
+	for i, identifier := range strings.Split(pre, ".") {
+		if i > 0 {
+			// Lower than all characters allowed in identifiers, so fewer identifiers have a lower precedence
+			b.WriteByte('!')
+		}
+		if _, err := strconv.ParseUint(identifier, 10, 64); err == nil {
+			// Numeric identifiers have a lower precedence than alphanumeric ones
+			b.WriteByte('0')
+			writeNumberKey(&b, strings.TrimLeft(identifier, "0"))
+		} else {
+			b.WriteByte('1')
+			b.WriteString(identifier)
+		}
+	}
+	return b.String()
+}
+
+// writeNumberKey writes the number prefixed by its length, so longer numbers are sorted after shorter ones
+func writeNumberKey(b *strings.Builder, digits string) {
+	fmt.Fprintf(b, "%02d%s", len(digits), digits)
+}
+
+// parseInterval parses a range without "||"
+func parseInterval(raw string) (versionInterval, error) {
+	interval := versionInterval{}
+
+	if raw == "" || raw == "*" {
+		return interval, nil
+	}
+
+	// Hyphen ranges like "1.0.0 - 2.0.0" include both ends
+	if lower, upper, found := strings.Cut(raw, " - "); found {
+		from, err := parseComparator(">=" + strings.TrimSpace(lower))
+		if err != nil {
+			return interval, err
+		}
+		to, err := parseComparator("<=" + strings.TrimSpace(upper))
+		if err != nil {
+			return interval, err
+		}
+		return from.intersect(to), nil
+	}
+
+	raw = operatorSpaces.ReplaceAllString(raw, "$1")
+	for _, token := range strings.FieldsFunc(raw, func(r rune) bool { return r == ' ' || r == ',' }) {
+		comparator, err := parseComparator(token)
+		if err != nil {
+			return interval, err
+		}
+		interval = interval.intersect(comparator)
+	}
+
+	return interval, nil
+}
+
+// parseComparator parses a single comparator like ">=1.2.0", "1.x" or "~1.2"
+func parseComparator(token string) (versionInterval, error) {
+	matches := comparatorExp.FindStringSubmatch(token)
+	if matches == nil {
+		return versionInterval{}, fmt.Errorf("invalid comparator %q", token)
+	}
+	operator := matches[1]
+
+	parts, pre, err := parsePartialVersion(matches[2])
+	if err != nil {
+		return versionInterval{}, err
+	}
+
+	// A version without any numbers (e.g. "x") matches everything
+	if len(parts) == 0 {
+		if operator == "<" || operator == ">" {
+			return versionInterval{}, fmt.Errorf("invalid comparator %q", token)
+		}
+		return versionInterval{}, nil
+	}
+
+	low := newVersion(parts, pre)
+	next := nextVersion(parts)
+
+	switch operator {
+	case "", "=":
+		if len(parts) == 3 {
+			return versionInterval{lower: &versionBound{low, true}, upper: &versionBound{low, true}}, nil
+		}
+		return versionInterval{lower: &versionBound{low, true}, upper: &versionBound{next, false}}, nil
+	case ">=":
+		return versionInterval{lower: &versionBound{low, true}}, nil
+	case ">":
+		if len(parts) == 3 {
+			return versionInterval{lower: &versionBound{low, false}}, nil
+		}
+		return versionInterval{lower: &versionBound{next, true}}, nil
+	case "<":
+		return versionInterval{upper: &versionBound{low, false}}, nil
+	case "<=":
+		if len(parts) == 3 {
+			return versionInterval{upper: &versionBound{low, true}}, nil
+		}
+		return versionInterval{upper: &versionBound{next, false}}, nil
+	case "~", "~>":
+		// ~1 is 1.x, ~1.2 and ~1.2.3 allow patch level changes
+		upper := nextVersion(parts[:min(len(parts), 2)])
+		return versionInterval{lower: &versionBound{low, true}, upper: &versionBound{upper, false}}, nil
+	case "^":
+		// ^ allows changes which don't modify the left-most non-zero component
+		significant := 1
+		for significant < len(parts) && parts[significant-1] == 0 {
+			significant++
+		}
+		upper := nextVersion(parts[:significant])
+		return versionInterval{lower: &versionBound{low, true}, upper: &versionBound{upper, false}}, nil
+	}
+
+	return versionInterval{}, fmt.Errorf("invalid operator %q", operator)
+}
+
+// parsePartialVersion parses versions like "1", "1.2", "1.x" or "1.2.3-rc1"
+// Only the numeric components in front of the first wildcard are returned
+func parsePartialVersion(raw string) ([]int, string, error) {
+	core, pre, _ := strings.Cut(raw, "-")
+	core, _, _ = strings.Cut(core, "+")
+
+	parts := []int{}
+	for i, component := range strings.Split(core, ".") {
+		if i >= 3 {
+			return nil, "", fmt.Errorf("invalid version %q", raw)
+		}
+		if component == "x" || component == "X" || component == "*" {
+			break
+		}
+		n, err := strconv.Atoi(component)
+		if err != nil || n < 0 {
+			return nil, "", fmt.Errorf("invalid version %q", raw)
+		}
+		parts = append(parts, n)
+	}
+
+	if pre != "" && len(parts) < 3 {
+		return nil, "", fmt.Errorf("invalid version %q", raw)
+	}
+
+	return parts, pre, nil
+}
+
+// newVersion creates the version from the given components, missing components are zero
+func newVersion(parts []int, pre string) *version.Version {
+	full := []int{0, 0, 0}
+	copy(full, parts)
+
+	raw := fmt.Sprintf("%d.%d.%d", full[0], full[1], full[2])
+	if pre != "" {
+		raw += "-" + pre
+	}
+
+	v, err := version.NewVersion(raw)
+	if err != nil {
+		// The components are validated already, so this can only be an invalid pre-release
+		v, _ = version.NewVersion(fmt.Sprintf("%d.%d.%d", full[0], full[1], full[2]))
+	}
+	return v
+}
+
+// nextVersion increments the last of the given components
+func nextVersion(parts []int) *version.Version {
+	next := append([]int{}, parts...)
+	next[len(next)-1]++
+	return newVersion(next, "")
+}
+
+func (i versionInterval) contains(v *version.Version) bool {
+	if i.lower != nil {
+		c := v.Compare(i.lower.version)
+		if c < 0 || (c == 0 && !i.lower.inclusive) {
+			return false
+		}
+	}
+	if i.upper != nil {
+		c := v.Compare(i.upper.version)
+		if c > 0 || (c == 0 && !i.upper.inclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// intersect returns the interval containing the versions of both intervals
+func (i versionInterval) intersect(other versionInterval) versionInterval {
+	result := versionInterval{lower: i.lower, upper: i.upper}
+
+	if other.lower != nil {
+		if result.lower == nil {
+			result.lower = other.lower
+		} else if c := other.lower.version.Compare(result.lower.version); c > 0 || (c == 0 && !other.lower.inclusive) {
+			result.lower = other.lower
+		}
+	}
+
+	if other.upper != nil {
+		if result.upper == nil {
+			result.upper = other.upper
+		} else if c := other.upper.version.Compare(result.upper.version); c < 0 || (c == 0 && !other.upper.inclusive) {
+			result.upper = other.upper
+		}
+	}
+
+	return result
+}
+
+// empty reports whether no version is part of the interval
+func (i versionInterval) empty() bool {
+	if i.lower == nil || i.upper == nil {
+		return false
+	}
+	c := i.lower.version.Compare(i.upper.version)
+	return c > 0 || (c == 0 && !(i.lower.inclusive && i.upper.inclusive))
+}
@@ -0,0 +1,157 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dadav/gorge/internal/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// debounce is the time to wait for more events before a change is reported
+	debounce = 250 * time.Millisecond
+	tarGzExt = ".tar.gz"
+)
+
+// isRelease reports whether name looks like a release tarball
+// Temporary files (e.g. .acme-foo-1.0.0.tar.gz.XXXXXX written by rsync) are ignored
+func isRelease(name string) bool {
+	return strings.HasSuffix(name, tarGzExt) && !strings.HasPrefix(name, ".")
+}
+
+// debouncer calls fn once no trigger happened for the debounce duration
+type debouncer struct {
+	timer *time.Timer
+}
+
+func newDebouncer(fn func()) *debouncer {
+	timer := time.AfterFunc(time.Hour, fn)
+	timer.Stop()
+	return &debouncer{timer: timer}
+}
+
+func (d *debouncer) trigger() {
+	d.timer.Reset(debounce)
+}
+
+func (d *debouncer) stop() {
+	d.timer.Stop()
+}
+
+// watcher keeps track of the watched directories of a tree
+type watcher struct {
+	*fsnotify.Watcher
+	dirs map[string]bool
+}
+
+// Watch watches dir and all its subdirectories
+// onChange is called (debounced) whenever a tarball has been added, replaced or removed
+// or a directory has been created or removed. Watch blocks until ctx is done.
+func Watch(ctx context.Context, dir string, onChange func()) error {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer fsWatcher.Close()
+
+	w := &watcher{Watcher: fsWatcher, dirs: map[string]bool{}}
+	if err := w.addTree(dir); err != nil {
+		return err
+	}
+	log.Log.Infof("Watching %s for changes", dir)
+
+	changes := newDebouncer(onChange)
+	defer changes.stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if w.handle(event) {
+				changes.trigger()
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				log.Log.Warn("Watcher queue overflowed, rescanning modules")
+				changes.trigger()
+				continue
+			}
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+}
+
+// handle processes a single event and reports whether the modules have to be rescanned
+func (w *watcher) handle(event fsnotify.Event) bool {
+	path := event.Name
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		if w.dirs[path] {
+			// The watch is removed together with the directory
+			log.Log.Debugf("Directory %s has been removed", path)
+			delete(w.dirs, path)
+			return true
+		}
+	}
+
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			// Files could have been added before the watch was in place, so always rescan
+			log.Log.Debugf("Directory %s has been created", path)
+			if err := w.addTree(path); err != nil {
+				log.Log.Errorf("Failed to watch %s: %v", path, err)
+			}
+			return true
+		}
+	}
+
+	if !isRelease(filepath.Base(path)) {
+		return false
+	}
+
+	// Writes keep delaying the rescan, so tarballs are usually read once they are complete
+	if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		log.Log.Debugf("Release file %s has changed", path)
+		return true
+	}
+
+	return false
+}
+
+// addTree adds a watch for root and all directories below
+func (w *watcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory could have been removed in the meantime
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if err := w.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		w.dirs[path] = true
+
+		return nil
+	})
+}
@@ -0,0 +1,97 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dadav/gorge/internal/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Log = zap.NewNop().Sugar()
+	os.Exit(m.Run())
+}
+
+// startWatch watches dir until the test is done, a value is sent on the returned channel for every reload
+func startWatch(t *testing.T, dir string) <-chan struct{} {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reloads := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, dir, func() { reloads <- struct{}{} })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watch failed: %v", err)
+		}
+	})
+
+	// Give the watcher some time to add its watches
+	time.Sleep(100 * time.Millisecond)
+	return reloads
+}
+
+func expectReload(t *testing.T, reloads <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected a reload after %s", what)
+	}
+}
+
+func expectNoReload(t *testing.T, reloads <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-reloads:
+		t.Fatalf("expected no reload after %s", what)
+	case <-time.After(2 * debounce):
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	reloads := startWatch(t, dir)
+
+	tarball := filepath.Join(dir, "acme-foo-1.0.0.tar.gz")
+	if err := os.WriteFile(tarball, []byte("release"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectReload(t, reloads, "creating a tarball")
+
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("readme"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectNoReload(t, reloads, "creating another file")
+
+	// Files in new directories are picked up as well
+	moduleDir := filepath.Join(dir, "acme-bar")
+	if err := os.Mkdir(moduleDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	expectReload(t, reloads, "creating a directory")
+
+	if err := os.WriteFile(filepath.Join(moduleDir, "acme-bar-1.0.0.tar.gz"), []byte("release"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectReload(t, reloads, "creating a tarball in a new directory")
+
+	if err := os.Remove(tarball); err != nil {
+		t.Fatal(err)
+	}
+	expectReload(t, reloads, "removing a tarball")
+
+	if err := os.RemoveAll(moduleDir); err != nil {
+		t.Fatal(err)
+	}
+	expectReload(t, reloads, "removing a directory")
+}